@@ -0,0 +1,104 @@
+// Package api gives read-only commands access to a database that another
+// process may have open. Badger holds an exclusive lock on its directory, so
+// a long `grit run` serves reads over a unix socket inside the repo path and
+// Open falls back to that socket when the database is locked.
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"grit/db"
+	"grit/log"
+	"grit/types"
+)
+
+var apiLogger = log.NewLogger("API")
+
+// Reader is the read-only view of a database shared by db.Database and Client.
+type Reader interface {
+	ListSteps() chan types.Step
+	GetStep(id string) (*types.Step, error)
+	CountTasksForStep(stepID string) (int64, error)
+	CountUnprocessedTasksForStep(stepID string) (int64, error)
+	CountSkippedTasksForStep(stepID string) (int64, error)
+	CountFailedTasksForStep(stepID string) (int64, error)
+	CountRunningTasksForStep(stepID string) (int64, error)
+	GetPipelineStatus() (complete bool, totalTasks int64, processedTasks int64, err error)
+	GetTasksForStep(stepID string) chan types.Task
+	GetTask(id string) (*types.Task, error)
+	GetTaskLog(taskID string) ([]byte, error)
+	GetResource(id string) (*types.Resource, error)
+	GetResourcesByName(name string) chan types.Resource
+	GetAllResources() chan types.Resource
+	GetAllResourceNames() chan string
+	ListRuns() ([]types.Run, error)
+	GetRun(id string) (*types.Run, error)
+	GetResourcesForRun(runID string) chan types.Resource
+	ObjectExists(hash string) bool
+	GetObject(hash string) ([]byte, error)
+	OpenObject(hash string) (io.ReadCloser, error)
+	Close() error
+}
+
+var _ Reader = db.Database{}
+var _ Reader = (*Client)(nil)
+
+// SocketPath returns the control socket of the repo at dbPath.
+func SocketPath(dbPath string) string {
+	return filepath.Join(dbPath, "grit.sock")
+}
+
+// Open opens the repo at dbPath directly with opts, or through its control
+// socket if another process holds the database lock.
+func Open(dbPath string, opts db.Options) (Reader, error) {
+	database, err := db.Open(dbPath, opts)
+	if err == nil {
+		return database, nil
+	}
+
+	socket := SocketPath(dbPath)
+	conn, dialErr := net.DialTimeout("unix", socket, time.Second)
+	if dialErr != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", dbPath, err)
+	}
+	conn.Close()
+
+	apiLogger.Verbosef("Database %s is locked, reading through %s\n", dbPath, socket)
+	return NewClient(socket), nil
+}
+
+// ServeSocket serves read-only access to database on the control socket of
+// dbPath until the returned stop function is called. The caller must hold
+// the database open, so any socket already there is stale.
+func ServeSocket(database db.Database, dbPath string) (stop func(), err error) {
+	socket := SocketPath(dbPath)
+	os.Remove(socket)
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict %s: %w", socket, err)
+	}
+
+	server := &http.Server{Handler: NewHandler(database)}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			apiLogger.Printf("Control socket stopped: %v\n", err)
+		}
+	}()
+
+	return func() {
+		server.Close()
+		os.Remove(socket)
+	}, nil
+}
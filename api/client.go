@@ -0,0 +1,337 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"grit/types"
+)
+
+// Client reads a database through the control socket of the process that
+// has it open.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the control socket at path.
+func NewClient(path string) *Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}
+}
+
+func (c *Client) ListSteps() chan types.Step {
+	return getList[types.Step](c, "/api/v1/steps")
+}
+
+func (c *Client) GetStep(id string) (*types.Step, error) {
+	return getEntity[types.Step](c, "/api/v1/steps/"+url.PathEscape(id))
+}
+
+func (c *Client) CountTasksForStep(stepID string) (int64, error) {
+	counts, err := c.stepCounts(stepID)
+	return counts.Total, err
+}
+
+func (c *Client) CountUnprocessedTasksForStep(stepID string) (int64, error) {
+	counts, err := c.stepCounts(stepID)
+	return counts.Unprocessed, err
+}
+
+func (c *Client) CountSkippedTasksForStep(stepID string) (int64, error) {
+	counts, err := c.stepCounts(stepID)
+	return counts.Skipped, err
+}
+
+func (c *Client) CountFailedTasksForStep(stepID string) (int64, error) {
+	counts, err := c.stepCounts(stepID)
+	return counts.Failed, err
+}
+
+func (c *Client) CountRunningTasksForStep(stepID string) (int64, error) {
+	counts, err := c.stepCounts(stepID)
+	return counts.Running, err
+}
+
+func (c *Client) GetPipelineStatus() (bool, int64, int64, error) {
+	status, err := getEntity[PipelineStatus](c, "/api/v1/status")
+	if err != nil || status == nil {
+		return false, 0, 0, err
+	}
+	return status.Complete, status.Total, status.Processed, nil
+}
+
+func (c *Client) stepCounts(stepID string) (StepCounts, error) {
+	counts, err := getEntity[StepCounts](c, "/api/v1/steps/"+url.PathEscape(stepID)+"/counts")
+	if err != nil || counts == nil {
+		return StepCounts{}, err
+	}
+	return *counts, nil
+}
+
+func (c *Client) GetTasksForStep(stepID string) chan types.Task {
+	return getPages[types.Task](c, "/api/v1/tasks?step="+url.QueryEscape(stepID))
+}
+
+func (c *Client) GetTask(id string) (*types.Task, error) {
+	return getEntity[types.Task](c, "/api/v1/tasks/"+url.PathEscape(id))
+}
+
+func (c *Client) GetTaskLog(taskID string) ([]byte, error) {
+	body, err := c.get("/api/v1/tasks/" + url.PathEscape(taskID) + "/log")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (c *Client) GetResource(id string) (*types.Resource, error) {
+	return getEntity[types.Resource](c, "/api/v1/resources/"+url.PathEscape(id))
+}
+
+func (c *Client) GetResourcesByName(name string) chan types.Resource {
+	return getPages[types.Resource](c, "/api/v1/resources?name="+url.QueryEscape(name))
+}
+
+func (c *Client) GetAllResources() chan types.Resource {
+	return getPages[types.Resource](c, "/api/v1/resources?name=")
+}
+
+func (c *Client) GetAllResourceNames() chan string {
+	return getList[string](c, "/api/v1/names")
+}
+
+func (c *Client) ListRuns() ([]types.Run, error) {
+	runs, err := getEntity[[]types.Run](c, "/api/v1/runs")
+	if err != nil || runs == nil {
+		return nil, err
+	}
+	return *runs, nil
+}
+
+func (c *Client) GetRun(id string) (*types.Run, error) {
+	return getEntity[types.Run](c, "/api/v1/runs/"+url.PathEscape(id))
+}
+
+func (c *Client) GetResourcesForRun(runID string) chan types.Resource {
+	return getPages[types.Resource](c, "/api/v1/resources?run="+url.QueryEscape(runID))
+}
+
+func (c *Client) ObjectExists(hash string) bool {
+	resp, err := c.http.Head("http://unix/api/v1/objects/" + url.PathEscape(hash))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+func (c *Client) GetObject(hash string) ([]byte, error) {
+	body, err := c.get("/api/v1/objects/" + url.PathEscape(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// OpenObject streams an object. The reader also implements io.Seeker: after
+// a seek it reads on with a Range request, so serving part of an object does
+// not fetch all of it.
+func (c *Client) OpenObject(hash string) (io.ReadCloser, error) {
+	obj := &remoteObject{c: c, path: "/api/v1/objects/" + url.PathEscape(hash), size: -1}
+	if err := obj.open(); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// remoteObject reads an object over the control socket. body, if open,
+// continues from offset at; a read at any other position requests the rest
+// of the object from there.
+type remoteObject struct {
+	c    *Client
+	path string
+	size int64
+	pos  int64
+	body io.ReadCloser
+	at   int64
+}
+
+// open requests the object from pos to its end.
+func (o *remoteObject) open() error {
+	req, err := http.NewRequest(http.MethodGet, "http://unix"+o.path, nil)
+	if err != nil {
+		return err
+	}
+	if o.pos > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.pos))
+	}
+	resp, err := o.c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return errNotFound
+	case resp.StatusCode == http.StatusOK && o.pos > 0:
+		resp.Body.Close()
+		return fmt.Errorf("control socket ignored the range request for %s", o.path)
+	case resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent:
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("control socket returned %s: %s", resp.Status, msg)
+	}
+	if o.size < 0 && resp.StatusCode == http.StatusOK {
+		o.size = resp.ContentLength
+	}
+	o.body, o.at = resp.Body, o.pos
+	return nil
+}
+
+func (o *remoteObject) Read(p []byte) (int, error) {
+	if o.size >= 0 && o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.at != o.pos {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	o.at = o.pos
+	return n, err
+}
+
+func (o *remoteObject) Seek(offset int64, whence int) (int64, error) {
+	pos := offset
+	switch whence {
+	case io.SeekCurrent:
+		pos += o.pos
+	case io.SeekEnd:
+		if o.size < 0 {
+			return 0, errors.New("object size is unknown")
+		}
+		pos += o.size
+	}
+	if pos < 0 {
+		return 0, errors.New("seek before start of object")
+	}
+	o.pos = pos
+	return pos, nil
+}
+
+func (o *remoteObject) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	return o.body.Close()
+}
+
+func (c *Client) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// errNotFound is returned by get for a 404 so entity lookups can report a
+// missing entity as nil, the way db.Database does.
+var errNotFound = errors.New("not found")
+
+func (c *Client) get(path string) (io.ReadCloser, error) {
+	resp, err := c.http.Get("http://unix" + path)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("control socket returned %s: %s", resp.Status, msg)
+	}
+	return resp.Body, nil
+}
+
+func getEntity[T any](c *Client, path string) (*T, error) {
+	body, err := c.get(path)
+	if err == errNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var v T
+	if err := json.NewDecoder(body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// getList sends every element of the JSON array at path on a channel.
+func getList[T any](c *Client, path string) chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		items, err := getEntity[[]T](c, path)
+		if err != nil {
+			apiLogger.Printf("Error reading %s: %v\n", path, err)
+			return
+		}
+		if items == nil {
+			return
+		}
+		for _, item := range *items {
+			ch <- item
+		}
+	}()
+	return ch
+}
+
+// getPages follows the pages of the list at path, which must already carry a
+// query string, and sends every item on a channel.
+func getPages[T any](c *Client, path string) chan T {
+	ch := make(chan T)
+	go func() {
+		defer close(ch)
+		after := ""
+		for {
+			page, err := getEntity[Page[T]](c, path+"&limit="+strconv.Itoa(maxPageSize)+"&after="+url.QueryEscape(after))
+			if err != nil {
+				apiLogger.Printf("Error reading %s: %v\n", path, err)
+				return
+			}
+			if page == nil {
+				return
+			}
+			for _, item := range page.Items {
+				ch <- item
+			}
+			if page.Next == "" {
+				return
+			}
+			after = page.Next
+		}
+	}()
+	return ch
+}
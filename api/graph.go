@@ -0,0 +1,52 @@
+package api
+
+import (
+	"fmt"
+
+	"grit/db"
+	"grit/types"
+)
+
+// Edge connects two steps. Data edges carry the resource name that flows
+// between them; ordering edges (from after) carry none.
+type Edge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Name string `json:"name,omitempty"`
+}
+
+// Graph is the latest version of every step and the edges between them.
+type Graph struct {
+	Steps []types.Step `json:"steps"`
+	Edges []Edge       `json:"edges"`
+}
+
+// StepGraph builds the step graph. Data edges are only known once a step has
+// produced something, so they come from the recorded producers of each input
+// name.
+func StepGraph(database db.Database) (Graph, error) {
+	steps, err := database.ListLatestSteps()
+	if err != nil {
+		return Graph{}, fmt.Errorf("failed to list steps: %w", err)
+	}
+
+	graph := Graph{Steps: nonNil(steps), Edges: []Edge{}}
+	for _, step := range steps {
+		if step.Input != "" {
+			producers, err := database.GetNameProducers(step.Input)
+			if err != nil {
+				return Graph{}, fmt.Errorf("failed to read producers of %s: %w", step.Input, err)
+			}
+			if len(producers) == 0 {
+				apiLogger.Verbosef("No producers recorded yet for %s\n", step.Input)
+			}
+			for _, producer := range producers {
+				graph.Edges = append(graph.Edges, Edge{From: producer, To: step.Name, Name: step.Input})
+			}
+		}
+		for _, dep := range step.After {
+			graph.Edges = append(graph.Edges, Edge{From: dep, To: step.Name})
+		}
+	}
+	return graph, nil
+}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"grit/db"
+	"grit/types"
+)
+
+// Page sizes for paginated lists.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Page is one page of a list. Pass Next as the after parameter to get the
+// following page; it is empty on the last page.
+type Page[T any] struct {
+	Items []T    `json:"items"`
+	Next  string `json:"next,omitempty"`
+}
+
+// StepCounts is the task tally of one step.
+type StepCounts struct {
+	Total       int64 `json:"total"`
+	Unprocessed int64 `json:"unprocessed"`
+	Skipped     int64 `json:"skipped"`
+	Failed      int64 `json:"failed"`
+	// Running counts the unprocessed tasks executing right now.
+	Running int64 `json:"running"`
+}
+
+// PipelineStatus totals the tasks of every step.
+type PipelineStatus struct {
+	Complete  bool  `json:"complete"`
+	Total     int64 `json:"total"`
+	Processed int64 `json:"processed"`
+}
+
+// StepProgress is the task tally of the latest version of a step.
+type StepProgress struct {
+	StepID  string `json:"step_id"`
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	StepCounts
+}
+
+// NewHandler returns read-only HTTP routes over database.
+func NewHandler(database db.Database) http.Handler {
+	h := handler{db: database}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/steps", h.listSteps)
+	mux.HandleFunc("GET /api/v1/steps/{id}", h.getStep)
+	mux.HandleFunc("GET /api/v1/steps/{id}/counts", h.getStepCounts)
+	mux.HandleFunc("GET /api/v1/progress", h.getProgress)
+	mux.HandleFunc("GET /api/v1/status", h.getStatus)
+	mux.HandleFunc("GET /api/v1/runs", h.listRuns)
+	mux.HandleFunc("GET /api/v1/runs/{id}", h.getRun)
+	mux.HandleFunc("GET /api/v1/graph", h.getGraph)
+	mux.HandleFunc("GET /api/v1/tasks", h.listTasks)
+	mux.HandleFunc("GET /api/v1/tasks/{id}", h.getTask)
+	mux.HandleFunc("GET /api/v1/tasks/{id}/log", h.getTaskLog)
+	mux.HandleFunc("GET /api/v1/resources", h.listResources)
+	mux.HandleFunc("GET /api/v1/resources/{id}", h.getResource)
+	mux.HandleFunc("GET /api/v1/resources/{id}/lineage", h.getLineage)
+	mux.HandleFunc("GET /api/v1/names", h.listNames)
+	mux.HandleFunc("GET /api/v1/objects/{hash}", h.getObject)
+	mux.HandleFunc("GET /api/v1/events", h.streamEvents)
+	return mux
+}
+
+type handler struct {
+	db db.Database
+}
+
+// listSteps returns every version of every step, or of the step given by
+// name, oldest first.
+func (h handler) listSteps(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	steps := []types.Step{}
+	for step := range h.db.ListSteps() {
+		if name == "" || step.Name == name {
+			steps = append(steps, step)
+		}
+	}
+	writeJSON(w, steps)
+}
+
+func (h handler) getStep(w http.ResponseWriter, r *http.Request) {
+	step, err := h.db.GetStep(r.PathValue("id"))
+	writeEntity(w, r, step, err)
+}
+
+func (h handler) getStepCounts(w http.ResponseWriter, r *http.Request) {
+	counts, err := h.stepCounts(r.PathValue("id"))
+	writeEntity(w, r, &counts, err)
+}
+
+func (h handler) stepCounts(stepID string) (StepCounts, error) {
+	var counts StepCounts
+	var err error
+	if counts.Total, err = h.db.CountTasksForStep(stepID); err != nil {
+		return counts, err
+	}
+	if counts.Unprocessed, err = h.db.CountUnprocessedTasksForStep(stepID); err != nil {
+		return counts, err
+	}
+	if counts.Skipped, err = h.db.CountSkippedTasksForStep(stepID); err != nil {
+		return counts, err
+	}
+	if counts.Failed, err = h.db.CountFailedTasksForStep(stepID); err != nil {
+		return counts, err
+	}
+	counts.Running, err = h.db.CountRunningTasksForStep(stepID)
+	return counts, err
+}
+
+func (h handler) getProgress(w http.ResponseWriter, r *http.Request) {
+	steps, err := h.db.ListLatestSteps()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	progress := make([]StepProgress, 0, len(steps))
+	for _, step := range steps {
+		counts, err := h.stepCounts(step.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		progress = append(progress, StepProgress{StepID: step.ID, Name: step.Name, Version: step.Version, StepCounts: counts})
+	}
+	writeJSON(w, progress)
+}
+
+func (h handler) getStatus(w http.ResponseWriter, r *http.Request) {
+	var status PipelineStatus
+	var err error
+	status.Complete, status.Total, status.Processed, err = h.db.GetPipelineStatus()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, status)
+}
+
+func (h handler) listRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := h.db.ListRuns()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, nonNil(runs))
+}
+
+func (h handler) getRun(w http.ResponseWriter, r *http.Request) {
+	run, err := h.db.GetRun(r.PathValue("id"))
+	writeEntity(w, r, run, err)
+}
+
+func (h handler) getGraph(w http.ResponseWriter, r *http.Request) {
+	graph, err := StepGraph(h.db)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, graph)
+}
+
+// listTasks pages through the tasks of one step, optionally filtered by
+// status (pending, done, failed or skipped).
+func (h handler) listTasks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	stepID := query.Get("step")
+	if stepID == "" {
+		http.Error(w, "step is required", http.StatusBadRequest)
+		return
+	}
+	limit, err := pageLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tasks, next, err := h.db.ListTasksPage(stepID, query.Get("status"), query.Get("after"), limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, Page[types.Task]{Items: nonNil(tasks), Next: next})
+}
+
+func (h handler) getTask(w http.ResponseWriter, r *http.Request) {
+	task, err := h.db.GetTask(r.PathValue("id"))
+	writeEntity(w, r, task, err)
+}
+
+func (h handler) getTaskLog(w http.ResponseWriter, r *http.Request) {
+	output, err := h.db.GetTaskLog(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(output)
+}
+
+// listResources pages through the resources with the given name, or every
+// resource when no name is given, oldest first.
+func (h handler) listResources(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := pageLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var resources []types.Resource
+	var next string
+	if runID := query.Get("run"); runID != "" {
+		resources, next, err = h.db.ListRunResourcesPage(runID, query.Get("after"), limit)
+	} else {
+		resources, next, err = h.db.ListResourcesPage(query.Get("name"), query.Get("after"), limit)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, Page[types.Resource]{Items: nonNil(resources), Next: next})
+}
+
+func (h handler) getResource(w http.ResponseWriter, r *http.Request) {
+	resource, err := h.db.GetResource(r.PathValue("id"))
+	writeEntity(w, r, resource, err)
+}
+
+func (h handler) getLineage(w http.ResponseWriter, r *http.Request) {
+	node, err := Lineage(h.db, r.PathValue("id"))
+	if err == nil && node.Resource == nil {
+		node = nil
+	}
+	writeEntity(w, r, node, err)
+}
+
+func (h handler) listNames(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for name := range h.db.GetAllResourceNames() {
+		names = append(names, name)
+	}
+	writeJSON(w, names)
+}
+
+// getObject serves an object's content. Objects are immutable and named by
+// their hash, so the hash doubles as the ETag and Range requests are
+// supported.
+func (h handler) getObject(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if !h.db.ObjectExists(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	obj, err := h.db.OpenObject(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("ETag", `"`+hash+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	content, ok := obj.(io.ReadSeeker)
+	if !ok {
+		// Without a seeker there is no Range support; stream it whole.
+		io.Copy(w, obj)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, content)
+}
+
+// streamEvents sends task and resource events as server-sent events until
+// the client disconnects.
+func (h handler) streamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	events := h.db.Subscribe(256)
+	defer h.db.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep idle connections from being cut by proxies.
+	keepalive := time.NewTicker(15 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				apiLogger.Printf("Error encoding event: %v\n", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+		}
+		flusher.Flush()
+	}
+}
+
+func pageLimit(r *http.Request) (int, error) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, fmt.Errorf("invalid limit %q", s)
+	}
+	return min(limit, maxPageSize), nil
+}
+
+// nonNil makes empty pages encode as [] rather than null.
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return []T{}
+	}
+	return items
+}
+
+// writeEntity writes v as JSON, or 404 when it is nil.
+func writeEntity[T any](w http.ResponseWriter, r *http.Request, v *T, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if v == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, v)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		apiLogger.Printf("Error writing response: %v\n", err)
+	}
+}
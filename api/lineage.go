@@ -0,0 +1,79 @@
+package api
+
+import (
+	"grit/types"
+)
+
+// LineageNode is a resource together with the task that produced it and,
+// recursively, that task's inputs.
+type LineageNode struct {
+	// ID is set even when the resource has since been deleted, in which case
+	// Resource is nil.
+	ID       string          `json:"id"`
+	Resource *types.Resource `json:"resource,omitempty"`
+	// Task and Step are nil for resources created outside a task, and Task
+	// is nil when the producing task has been deleted.
+	Task   *types.Task    `json:"task,omitempty"`
+	Step   *types.Step    `json:"step,omitempty"`
+	Inputs []*LineageNode `json:"inputs,omitempty"`
+	// Repeated marks a resource already expanded elsewhere in the tree.
+	Repeated bool `json:"repeated,omitempty"`
+}
+
+// Lineage traces the resource id back through the tasks that produced it.
+func Lineage(r Reader, id string) (*LineageNode, error) {
+	t := lineageTracer{db: r, steps: make(map[string]*types.Step), seen: make(map[string]bool)}
+	return t.trace(id)
+}
+
+type lineageTracer struct {
+	db    Reader
+	steps map[string]*types.Step
+	seen  map[string]bool
+}
+
+func (t *lineageTracer) trace(id string) (*LineageNode, error) {
+	node := &LineageNode{ID: id}
+	resource, err := t.db.GetResource(id)
+	if err != nil || resource == nil {
+		return node, err
+	}
+	node.Resource = resource
+
+	if t.seen[id] {
+		node.Repeated = true
+		return node, nil
+	}
+	t.seen[id] = true
+
+	if resource.CreatedByTaskID == nil {
+		return node, nil
+	}
+	if node.Task, err = t.db.GetTask(*resource.CreatedByTaskID); err != nil || node.Task == nil {
+		return node, err
+	}
+	if node.Step, err = t.step(node.Task.StepID); err != nil {
+		return node, err
+	}
+
+	for _, inputID := range node.Task.InputIDs() {
+		input, err := t.trace(inputID)
+		if err != nil {
+			return node, err
+		}
+		node.Inputs = append(node.Inputs, input)
+	}
+	return node, nil
+}
+
+func (t *lineageTracer) step(id string) (*types.Step, error) {
+	if step, ok := t.steps[id]; ok {
+		return step, nil
+	}
+	step, err := t.db.GetStep(id)
+	if err != nil {
+		return nil, err
+	}
+	t.steps[id] = step
+	return step, nil
+}
@@ -0,0 +1,122 @@
+// Package chunker splits a stream into content-defined chunks with FastCDC.
+//
+// Chunk boundaries depend only on the bytes around them, so inserting or
+// removing data in one place of a stream only changes the chunks near the
+// edit. Storing chunks by their hash then dedups near-identical streams.
+//
+// The gear table and masks are part of the on-disk format: changing them
+// changes every boundary and loses dedup against chunks already stored.
+package chunker
+
+import (
+	"errors"
+	"io"
+)
+
+// Chunk sizes. Boundaries are only looked for between MinSize and MaxSize,
+// and normalized chunking keeps most chunks near AvgSize.
+const (
+	MinSize = 16 << 10
+	AvgSize = 64 << 10
+	MaxSize = 256 << 10
+)
+
+// Masks for normalized chunking (level 2). maskS has two more bits than
+// log2(AvgSize) and is used below AvgSize, making cuts there less likely;
+// maskL has two fewer and is used above it. The bits are the top ones of the
+// fingerprint, which depend on the most recent 64 bytes.
+const (
+	maskS uint64 = ((1 << 18) - 1) << (64 - 18)
+	maskL uint64 = ((1 << 14) - 1) << (64 - 14)
+)
+
+// gear maps each byte to a pseudo-random 64-bit value.
+var gear = func() (table [256]uint64) {
+	// splitmix64 with a fixed seed, so the table never changes.
+	state := uint64(0x6772697463646300)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker reads a stream and returns it chunk by chunk.
+type Chunker struct {
+	r   io.Reader
+	buf []byte
+	// start and end delimit the unread data in buf.
+	start, end int
+	eof        bool
+}
+
+// New returns a Chunker reading from r.
+func New(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, 2*MaxSize)}
+}
+
+// Next returns the next chunk, or io.EOF after the last one. The chunk is
+// only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := Cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// fill makes sure at least MaxSize bytes are buffered, unless the stream
+// ends first.
+func (c *Chunker) fill() error {
+	if c.eof || c.end-c.start >= MaxSize {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	for c.end < len(c.buf) && !c.eof {
+		n, err := c.r.Read(c.buf[c.end:])
+		c.end += n
+		if errors.Is(err, io.EOF) {
+			c.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cut returns the length of the first chunk of data.
+func Cut(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	if n > MaxSize {
+		n = MaxSize
+	}
+	normal := min(AvgSize, n)
+
+	var fp uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskS == 0 {
+			return i
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskL == 0 {
+			return i
+		}
+	}
+	return n
+}
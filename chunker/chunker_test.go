@@ -0,0 +1,69 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var out [][]byte
+	c := New(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		out = append(out, bytes.Clone(chunk))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 8<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	got := chunks(t, data)
+	if joined := bytes.Join(got, nil); !bytes.Equal(joined, data) {
+		t.Fatalf("chunks do not reassemble to the input")
+	}
+	for i, chunk := range got {
+		if len(chunk) > MaxSize || (len(chunk) < MinSize && i != len(got)-1) {
+			t.Errorf("chunk %d is %d bytes", i, len(chunk))
+		}
+	}
+	if avg := len(data) / len(got); avg < AvgSize/2 || avg > 2*AvgSize {
+		t.Errorf("average chunk size = %d, want about %d", avg, AvgSize)
+	}
+
+	// An insertion near the start only changes the chunks around it.
+	edited := append(append(bytes.Clone(data[:1000]), "inserted"...), data[1000:]...)
+	seen := make(map[[32]byte]bool)
+	for _, chunk := range got {
+		seen[sha256.Sum256(chunk)] = true
+	}
+	var shared int
+	editedChunks := chunks(t, edited)
+	for _, chunk := range editedChunks {
+		if seen[sha256.Sum256(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(editedChunks)-2 {
+		t.Errorf("%d of %d chunks shared after a small insertion", shared, len(editedChunks))
+	}
+}
+
+func TestChunkerShortInput(t *testing.T) {
+	for _, n := range []int{0, 1, MinSize} {
+		got := chunks(t, make([]byte, n))
+		if n == 0 && len(got) != 0 || n > 0 && (len(got) != 1 || len(got[0]) != n) {
+			t.Errorf("chunks of %d bytes = %d chunks", n, len(got))
+		}
+	}
+}
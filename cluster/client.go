@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+
+	"grit/types"
+)
+
+// Client talks to a coordinator on behalf of one worker. It implements
+// exec.Store, so a ScriptExecutor can run leased tasks against it directly.
+type Client struct {
+	http   *http.Client
+	base   string
+	worker string
+}
+
+// NewClient returns a client for the coordinator at addr, identifying itself
+// as worker.
+func NewClient(addr, worker string) *Client {
+	httpClient, base := httpClient(addr)
+	return &Client{http: httpClient, base: base, worker: worker}
+}
+
+// Lease asks for up to max tasks.
+func (c *Client) Lease(max int) (LeaseResponse, error) {
+	var resp LeaseResponse
+	err := c.postJSON("/v1/lease", LeaseRequest{Worker: c.worker, Max: max}, &resp)
+	return resp, err
+}
+
+// Heartbeat extends the leases on taskIDs and returns the ones that were lost.
+func (c *Client) Heartbeat(taskIDs []string) ([]string, error) {
+	var resp HeartbeatResponse
+	err := c.postJSON("/v1/heartbeat", HeartbeatRequest{Worker: c.worker, TaskIDs: taskIDs}, &resp)
+	return resp.Lost, err
+}
+
+// Complete reports the outcome of a leased task.
+func (c *Client) Complete(taskID string, errMsg *string, skipped bool) error {
+	return c.postJSON("/v1/complete", CompleteRequest{Worker: c.worker, TaskID: taskID, Error: errMsg, Skipped: skipped}, nil)
+}
+
+func (c *Client) GetResource(id string) (*types.Resource, error) {
+	resp, err := c.http.Get(c.base + "/v1/resources/" + url.PathEscape(id))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	var resource types.Resource
+	if err := json.NewDecoder(resp.Body).Decode(&resource); err != nil {
+		return nil, err
+	}
+	return &resource, nil
+}
+
+// ListTaskInputs calls fn with the inputs of a leased task. The coordinator
+// fills in the input set of a reduce task when leasing it.
+func (c *Client) ListTaskInputs(task types.Task, fn func(resourceID string) error) error {
+	for _, id := range task.InputIDs() {
+		if err := fn(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) GetObject(hash string) ([]byte, error) {
+	resp, err := c.http.Get(c.base + "/v1/objects/" + url.PathEscape(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// OpenObject streams an object from the coordinator.
+func (c *Client) OpenObject(hash string) (io.ReadCloser, error) {
+	resp, err := c.http.Get(c.base + "/v1/objects/" + url.PathEscape(hash))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// IngestFile uploads an output file for a task this worker has leased.
+func (c *Client) IngestFile(path, name, taskID string, labels map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open output file %s: %w", path, err)
+	}
+	defer f.Close()
+
+	query := url.Values{"name": {name}, "task": {taskID}}
+	for key, value := range labels {
+		query.Add("label", key+"="+value)
+	}
+	req, err := http.NewRequest(http.MethodPost, c.base+"/v1/outputs?"+query.Encode(), f)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(workerHeader, c.worker)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload output %s: %w", name, err)
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// SaveTaskLog uploads the captured output of a task this worker has leased.
+func (c *Client) SaveTaskLog(taskID string, output []byte) error {
+	req, err := http.NewRequest(http.MethodPost, c.base+"/v1/logs?task="+url.QueryEscape(taskID), bytes.NewReader(output))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(workerHeader, c.worker)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload log for task %s: %w", taskID, err)
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+func (c *Client) postJSON(path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Post(c.base+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// checkStatus turns a non-2xx response into an error carrying its body.
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("coordinator returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,416 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"grit/db"
+	"grit/pipeline"
+	"grit/types"
+)
+
+// Coordinator owns the database and leases tasks to workers. Leases live in
+// memory only: if the coordinator restarts, every unprocessed task becomes
+// leasable again, which at worst runs a task twice.
+type Coordinator struct {
+	db        *db.Database
+	steps     []types.Step
+	leaseTTL  time.Duration
+	startedAt time.Time
+
+	mu      sync.Mutex
+	leases  map[string]*lease
+	lastGen uint64
+
+	// scheduleMu serialises scheduling passes triggered by idle workers.
+	scheduleMu sync.Mutex
+}
+
+type lease struct {
+	worker  string
+	stepID  string
+	expires time.Time
+	// gen tells this lease apart from a later one on the same task, even
+	// when that goes to the same worker again.
+	gen uint64
+	// writers counts requests writing on the lease's behalf. The lease does
+	// not expire while any are in flight.
+	writers int
+}
+
+// NewCoordinator returns a coordinator for already registered steps, in the
+// order they should be offered to workers.
+func NewCoordinator(database *db.Database, steps []types.Step, leaseTTL time.Duration) *Coordinator {
+	return &Coordinator{
+		db:        database,
+		steps:     steps,
+		leaseTTL:  leaseTTL,
+		startedAt: time.Now(),
+		leases:    make(map[string]*lease),
+	}
+}
+
+// Handler returns the coordinator's HTTP API.
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/lease", c.handleLease)
+	mux.HandleFunc("POST /v1/heartbeat", c.handleHeartbeat)
+	mux.HandleFunc("POST /v1/complete", c.handleComplete)
+	mux.HandleFunc("GET /v1/resources/{id}", c.handleGetResource)
+	mux.HandleFunc("GET /v1/objects/{hash}", c.handleGetObject)
+	mux.HandleFunc("POST /v1/outputs", c.handleOutput)
+	mux.HandleFunc("POST /v1/logs", c.handleLog)
+	return mux
+}
+
+// Schedule runs one scheduling pass over every step and returns how many
+// tasks it created.
+func (c *Coordinator) Schedule() (int64, error) {
+	c.scheduleMu.Lock()
+	defer c.scheduleMu.Unlock()
+
+	var total int64
+	for _, step := range c.steps {
+		created, _, err := pipeline.ScheduleStep(c.db, step, c.startedAt)
+		if err != nil {
+			return total, err
+		}
+		if created > 0 {
+			clusterLogger.Printf("Step %s: scheduled %d new tasks\n", step.Name, created)
+		}
+		total += created
+	}
+	return total, nil
+}
+
+// lease hands out up to max tasks to worker, expiring stale leases first.
+// Steps are offered in order, and a step's parallel setting caps how many of
+// its tasks are leased at once across all workers.
+func (c *Coordinator) lease(worker string, max int) ([]LeasedTask, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	active := make(map[string]int)
+	for taskID, l := range c.leases {
+		if now.After(l.expires) && l.writers == 0 {
+			clusterLogger.Printf("Lease on task %s held by %s expired, reassigning\n", taskID, l.worker)
+			delete(c.leases, taskID)
+			c.db.TaskStopped(l.stepID)
+			continue
+		}
+		active[l.stepID]++
+	}
+
+	var out []LeasedTask
+	for _, step := range c.steps {
+		limit := max - len(out)
+		if limit <= 0 {
+			break
+		}
+		if step.Parallel != nil {
+			limit = min(limit, *step.Parallel-active[step.ID])
+			if limit <= 0 {
+				continue
+			}
+		}
+		tasks, err := c.db.NextUnprocessedTasks(step.ID, limit, func(taskID string) bool {
+			_, leased := c.leases[taskID]
+			return leased
+		})
+		if err != nil {
+			return out, fmt.Errorf("failed to list tasks for step %s: %w", step.Name, err)
+		}
+		for _, task := range tasks {
+			if step.Reduce() {
+				err := c.db.ListTaskInputs(task, func(resourceID string) error {
+					task.InputResourceIDs = append(task.InputResourceIDs, resourceID)
+					return nil
+				})
+				if err != nil {
+					return out, fmt.Errorf("failed to list inputs of task %s: %w", task.ID, err)
+				}
+			}
+			c.lastGen++
+			c.leases[task.ID] = &lease{worker: worker, stepID: step.ID, expires: now.Add(c.leaseTTL), gen: c.lastGen}
+			c.db.TaskStarted(step.ID)
+			out = append(out, LeasedTask{Task: task, Step: step})
+		}
+	}
+	return out, nil
+}
+
+// idle reports whether no task is leased or waiting to be leased.
+func (c *Coordinator) idle() (bool, error) {
+	c.mu.Lock()
+	leased := len(c.leases)
+	c.mu.Unlock()
+	if leased > 0 {
+		return false, nil
+	}
+	for _, step := range c.steps {
+		pending, err := c.db.CountUnprocessedTasksForStep(step.ID)
+		if err != nil || pending > 0 {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// holds returns the generation of the lease worker currently holds on
+// taskID, or false if it holds none.
+func (c *Coordinator) holds(worker, taskID string) (uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.leases[taskID]
+	if !ok || l.worker != worker || !time.Now().Before(l.expires) {
+		return 0, false
+	}
+	return l.gen, true
+}
+
+// pin keeps worker's lease on taskID from expiring until unpin is called, so
+// a write made on its behalf cannot race a reassignment. It fails unless the
+// lease is still held and, if gen is not 0, is the one of that generation.
+func (c *Coordinator) pin(worker, taskID string, gen uint64) (unpin func(), ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.leases[taskID]
+	if !ok || l.worker != worker || !time.Now().Before(l.expires) || (gen != 0 && l.gen != gen) {
+		return nil, false
+	}
+	l.writers++
+	return func() {
+		c.mu.Lock()
+		l.writers--
+		c.mu.Unlock()
+	}, true
+}
+
+func (c *Coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var req LeaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Worker == "" || req.Max <= 0 {
+		http.Error(w, "worker and a positive max are required", http.StatusBadRequest)
+		return
+	}
+
+	tasks, err := c.lease(req.Worker, req.Max)
+	if err == nil && len(tasks) == 0 {
+		// Nothing pending: outputs may have arrived since the last pass.
+		var created int64
+		created, err = c.Schedule()
+		if err == nil && created > 0 {
+			tasks, err = c.lease(req.Worker, req.Max)
+		}
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := LeaseResponse{Tasks: tasks, TTL: c.leaseTTL}
+	if len(tasks) == 0 {
+		resp.Idle, err = c.idle()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		clusterLogger.Verbosef("Leased %d tasks to %s\n", len(tasks), req.Worker)
+	}
+	writeJSON(w, resp)
+}
+
+func (c *Coordinator) handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	var req HeartbeatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	now := time.Now()
+	var resp HeartbeatResponse
+	for _, taskID := range req.TaskIDs {
+		l, ok := c.leases[taskID]
+		if !ok || l.worker != req.Worker || now.After(l.expires) {
+			resp.Lost = append(resp.Lost, taskID)
+			continue
+		}
+		l.expires = now.Add(c.leaseTTL)
+	}
+	c.mu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+func (c *Coordinator) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req CompleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unpin, ok := c.pin(req.Worker, req.TaskID, 0)
+	if !ok {
+		http.Error(w, "lease not held", http.StatusConflict)
+		return
+	}
+	defer unpin()
+
+	update := db.TaskStatusUpdate{ID: req.TaskID, Processed: true, Error: req.Error, Skipped: req.Skipped}
+	if err := c.db.BatchUpdateTaskStatus([]db.TaskStatusUpdate{update}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.mu.Lock()
+	if l, ok := c.leases[req.TaskID]; ok {
+		delete(c.leases, req.TaskID)
+		c.db.TaskStopped(l.stepID)
+	}
+	c.mu.Unlock()
+
+	if req.Error != nil {
+		clusterLogger.Printf("Task %s failed on %s: %s\n", req.TaskID, req.Worker, *req.Error)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleGetResource(w http.ResponseWriter, r *http.Request) {
+	resource, err := c.db.GetResource(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if resource == nil {
+		http.NotFound(w, r)
+		return
+	}
+	writeJSON(w, resource)
+}
+
+func (c *Coordinator) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	hash := r.PathValue("hash")
+	if !c.db.ObjectExists(hash) {
+		http.NotFound(w, r)
+		return
+	}
+	obj, err := c.db.OpenObject(hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, obj)
+}
+
+// handleOutput ingests one output file of a leased task. Labels are passed
+// as repeated label=key=value query parameters.
+func (c *Coordinator) handleOutput(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name, taskID := query.Get("name"), query.Get("task")
+	if name == "" || taskID == "" {
+		http.Error(w, "name and task are required", http.StatusBadRequest)
+		return
+	}
+	worker := r.Header.Get(workerHeader)
+	gen, ok := c.holds(worker, taskID)
+	if !ok {
+		http.Error(w, "lease not held", http.StatusConflict)
+		return
+	}
+
+	var labels map[string]string
+	for _, label := range query["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok {
+			http.Error(w, fmt.Sprintf("invalid label %q", label), http.StatusBadRequest)
+			return
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+
+	path, err := receive(r.Body, name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(path)
+
+	// The upload may have outlived the lease; only ingest it if the lease it
+	// started under is still held.
+	unpin, ok := c.pin(worker, taskID, gen)
+	if !ok {
+		http.Error(w, "lease not held", http.StatusConflict)
+		return
+	}
+	defer unpin()
+	if err := c.db.IngestFile(path, name, taskID, labels); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *Coordinator) handleLog(w http.ResponseWriter, r *http.Request) {
+	taskID := r.URL.Query().Get("task")
+	worker := r.Header.Get(workerHeader)
+	gen, ok := c.holds(worker, taskID)
+	if !ok {
+		http.Error(w, "lease not held", http.StatusConflict)
+		return
+	}
+	output, err := io.ReadAll(io.LimitReader(r.Body, db.MaxTaskLogSize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	unpin, ok := c.pin(worker, taskID, gen)
+	if !ok {
+		http.Error(w, "lease not held", http.StatusConflict)
+		return
+	}
+	defer unpin()
+	if err := c.db.SaveTaskLog(taskID, output); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receive writes an uploaded output to a temporary file and returns its path.
+func receive(body io.Reader, name string) (string, error) {
+	f, err := os.CreateTemp("", "grit-upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload file: %w", err)
+	}
+	_, copyErr := io.Copy(f, body)
+	closeErr := f.Close()
+	if err := errors.Join(copyErr, closeErr); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to receive output %s: %w", name, err)
+	}
+	return f.Name(), nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		clusterLogger.Printf("Error writing response: %v\n", err)
+	}
+}
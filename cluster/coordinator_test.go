@@ -0,0 +1,135 @@
+package cluster
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"grit/db"
+	"grit/types"
+)
+
+func TestExpiredLeaseIsReassigned(t *testing.T) {
+	database, err := db.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("payload"))); err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	step := types.Step{Name: "up", Script: "true", Input: "row"}
+	step.ID, err = database.CreateStep(step)
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+
+	coordinator := NewCoordinator(&database, []types.Step{step}, 100*time.Millisecond)
+	server := httptest.NewServer(coordinator.Handler())
+	defer server.Close()
+
+	a := NewClient(server.URL, "a")
+	b := NewClient(server.URL, "b")
+
+	leased, err := a.Lease(1)
+	if err != nil {
+		t.Fatalf("Lease(a) error = %v", err)
+	}
+	if len(leased.Tasks) != 1 {
+		t.Fatalf("expected worker a to lease 1 task, got %d", len(leased.Tasks))
+	}
+	taskID := leased.Tasks[0].Task.ID
+
+	other, err := b.Lease(1)
+	if err != nil {
+		t.Fatalf("Lease(b) error = %v", err)
+	}
+	if len(other.Tasks) != 0 || other.Idle {
+		t.Fatalf("expected nothing for worker b while a holds the lease, got %d tasks (idle=%v)", len(other.Tasks), other.Idle)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	other, err = b.Lease(1)
+	if err != nil {
+		t.Fatalf("Lease(b) after expiry error = %v", err)
+	}
+	if len(other.Tasks) != 1 || other.Tasks[0].Task.ID != taskID {
+		t.Fatalf("expected worker b to be reassigned task %s, got %+v", taskID, other.Tasks)
+	}
+
+	lost, err := a.Heartbeat([]string{taskID})
+	if err != nil {
+		t.Fatalf("Heartbeat(a) error = %v", err)
+	}
+	if len(lost) != 1 {
+		t.Fatalf("expected worker a to learn its lease was lost")
+	}
+	if err := a.Complete(taskID, nil, false); err == nil {
+		t.Fatalf("expected completion from worker a to be rejected")
+	}
+	if err := b.Complete(taskID, nil, false); err != nil {
+		t.Fatalf("Complete(b) error = %v", err)
+	}
+
+	done, err := a.Lease(1)
+	if err != nil {
+		t.Fatalf("Lease(a) after completion error = %v", err)
+	}
+	if len(done.Tasks) != 0 || !done.Idle {
+		t.Fatalf("expected the coordinator to be idle, got %d tasks (idle=%v)", len(done.Tasks), done.Idle)
+	}
+}
+
+func TestPinnedLeaseOutlivesItsTTL(t *testing.T) {
+	database, err := db.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("payload"))); err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	step := types.Step{Name: "up", Script: "true", Input: "row"}
+	step.ID, err = database.CreateStep(step)
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	coordinator := NewCoordinator(&database, []types.Step{step}, 50*time.Millisecond)
+	if _, err := coordinator.Schedule(); err != nil {
+		t.Fatal(err)
+	}
+
+	leased, err := coordinator.lease("a", 1)
+	if err != nil || len(leased) != 1 {
+		t.Fatalf("lease(a) = %v, %v; want 1 task", leased, err)
+	}
+	taskID := leased[0].Task.ID
+	gen, ok := coordinator.holds("a", taskID)
+	if !ok {
+		t.Fatal("holds(a) = false right after leasing")
+	}
+
+	// A write in progress keeps the lease from being reassigned.
+	unpin, ok := coordinator.pin("a", taskID, gen)
+	if !ok {
+		t.Fatal("pin(a) = false while the lease is held")
+	}
+	time.Sleep(80 * time.Millisecond)
+	if other, _ := coordinator.lease("b", 1); len(other) != 0 {
+		t.Fatalf("lease(b) took %d tasks while a was writing, want 0", len(other))
+	}
+	unpin()
+
+	// Once it has expired, a write begun under the old lease is refused,
+	// even if the same worker leases the task again.
+	if again, _ := coordinator.lease("a", 1); len(again) != 1 {
+		t.Fatalf("lease(a) after expiry got %d tasks, want 1", len(again))
+	}
+	if _, ok := coordinator.pin("a", taskID, gen); ok {
+		t.Error("pin(a) succeeded with the generation of an expired lease")
+	}
+}
@@ -0,0 +1,91 @@
+// Package cluster runs a pipeline across several worker processes. A single
+// coordinator owns the database and hands out time-limited task leases over
+// HTTP; workers lease tasks, run scripts locally and upload their outputs.
+//
+// Addresses are either host:port (or an http:// URL) or unix:/path/to/socket.
+package cluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"grit/log"
+	"grit/types"
+)
+
+var clusterLogger = log.NewLogger("CLUSTER")
+
+// workerHeader identifies the calling worker on requests that act on a lease.
+const workerHeader = "X-Grit-Worker"
+
+type LeaseRequest struct {
+	Worker string `json:"worker"`
+	Max    int    `json:"max"`
+}
+
+type LeaseResponse struct {
+	Tasks []LeasedTask `json:"tasks"`
+	// TTL is how long a lease lasts without a heartbeat.
+	TTL time.Duration `json:"ttl"`
+	// Idle is set when nothing is pending or leased anywhere.
+	Idle bool `json:"idle"`
+}
+
+type LeasedTask struct {
+	Task types.Task `json:"task"`
+	Step types.Step `json:"step"`
+}
+
+type HeartbeatRequest struct {
+	Worker  string   `json:"worker"`
+	TaskIDs []string `json:"task_ids"`
+}
+
+type HeartbeatResponse struct {
+	// Lost lists tasks whose lease expired or moved to another worker.
+	Lost []string `json:"lost"`
+}
+
+type CompleteRequest struct {
+	Worker  string  `json:"worker"`
+	TaskID  string  `json:"task_id"`
+	Error   *string `json:"error,omitempty"`
+	Skipped bool    `json:"skipped,omitempty"`
+}
+
+// Listen opens a listener for a coordinator address. A stale unix socket
+// left behind by a previous process is removed first.
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if _, err := os.Stat(path); err == nil {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+			} else {
+				os.Remove(path)
+			}
+		}
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", strings.TrimPrefix(addr, "http://"))
+}
+
+// httpClient returns a client and base URL for a coordinator address.
+func httpClient(addr string) (*http.Client, string) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		return &http.Client{Transport: transport}, "http://unix"
+	}
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return &http.Client{}, strings.TrimSuffix(addr, "/")
+}
@@ -0,0 +1,160 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"grit/exec"
+)
+
+// Worker leases tasks from a coordinator and runs them locally.
+type Worker struct {
+	client   *Client
+	executor *exec.ScriptExecutor
+	parallel int
+	poll     time.Duration
+	exitIdle bool
+
+	mu     sync.Mutex
+	active map[string]bool
+	lost   map[string]bool
+}
+
+// NewWorker returns a worker that runs up to parallel tasks at once and polls
+// every poll when there is nothing to do. With exitIdle it returns once the
+// coordinator reports that no work is pending anywhere.
+func NewWorker(client *Client, parallel int, poll time.Duration, exitIdle bool) *Worker {
+	return &Worker{
+		client:   client,
+		executor: exec.NewScriptExecutor(client),
+		parallel: parallel,
+		poll:     poll,
+		exitIdle: exitIdle,
+		active:   make(map[string]bool),
+		lost:     make(map[string]bool),
+	}
+}
+
+// Run leases and executes tasks until ctx is cancelled or, with exitIdle, the
+// coordinator goes idle. Tasks already running are allowed to finish.
+func (w *Worker) Run(ctx context.Context) error {
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	heartbeat := time.NewTicker(time.Second)
+	defer heartbeat.Stop()
+	done := make(chan struct{}, w.parallel)
+
+	for {
+		free := w.parallel - w.activeCount()
+		if free > 0 {
+			resp, err := w.client.Lease(free)
+			if err != nil {
+				clusterLogger.Printf("Error leasing tasks: %v\n", err)
+			} else {
+				// Heartbeat well inside the lease TTL.
+				if interval := resp.TTL / 3; interval > 0 {
+					heartbeat.Reset(interval)
+				}
+				if len(resp.Tasks) == 0 && resp.Idle && w.exitIdle && w.activeCount() == 0 {
+					clusterLogger.Printf("Coordinator is idle, exiting\n")
+					return nil
+				}
+				for _, leased := range resp.Tasks {
+					w.setActive(leased.Task.ID, true)
+					running.Add(1)
+					go func(leased LeasedTask) {
+						defer running.Done()
+						w.runTask(leased)
+						w.setActive(leased.Task.ID, false)
+						done <- struct{}{}
+					}(leased)
+				}
+				if len(resp.Tasks) > 0 {
+					continue
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+		case <-heartbeat.C:
+			w.heartbeat()
+		case <-time.After(w.poll):
+		}
+	}
+}
+
+func (w *Worker) runTask(leased LeasedTask) {
+	task, step := leased.Task, leased.Step
+	clusterLogger.Verbosef("Executing task %s for step %s\n", task.ID, step.Name)
+
+	// Tasks run to completion even once the worker is told to stop.
+	execErr := w.executor.Execute(context.Background(), task, step)
+
+	var errMsg *string
+	skipped := errors.Is(execErr, exec.ErrSkipped)
+	if execErr != nil && !skipped {
+		msg := execErr.Error()
+		errMsg = &msg
+		clusterLogger.Printf("Task %s failed: %v\n", task.ID, execErr)
+	}
+
+	if w.isLost(task.ID) {
+		clusterLogger.Printf("Lease on task %s was lost, dropping result\n", task.ID)
+		return
+	}
+	if err := w.client.Complete(task.ID, errMsg, skipped); err != nil {
+		clusterLogger.Printf("Error completing task %s: %v\n", task.ID, err)
+	}
+}
+
+func (w *Worker) heartbeat() {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.active))
+	for id := range w.active {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	lost, err := w.client.Heartbeat(ids)
+	if err != nil {
+		clusterLogger.Printf("Error sending heartbeat: %v\n", err)
+		return
+	}
+	w.mu.Lock()
+	for _, id := range lost {
+		w.lost[id] = true
+	}
+	w.mu.Unlock()
+}
+
+func (w *Worker) activeCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.active)
+}
+
+func (w *Worker) setActive(taskID string, active bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if active {
+		w.active[taskID] = true
+	} else {
+		delete(w.active, taskID)
+		delete(w.lost, taskID)
+	}
+}
+
+func (w *Worker) isLost(taskID string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lost[taskID]
+}
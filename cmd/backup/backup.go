@@ -0,0 +1,94 @@
+// Description: Write a full or incremental backup of a database to a file
+package backup
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	outPath *string
+	since   *uint64
+	jsonOut *bool
+)
+
+// RegisterFlags sets up the flags for the backup command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	outPath = fs.String("out", "", "file to write the backup to (required)")
+	since = fs.Uint64("since", 0, "only back up what changed after this version, the next of an earlier backup (0 for a full backup)")
+	jsonOut = fs.Bool("json", false, "print the backup manifest as JSON")
+}
+
+// Execute runs the command
+func Execute() {
+	if *outPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -out is required")
+		os.Exit(1)
+	}
+
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	// Write to a temporary file so a failed backup never looks complete.
+	tmp := *outPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating backup file: %v\n", err)
+		os.Exit(1)
+	}
+	manifest, err := database.Backup(f, *since)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, *outPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		database.Close()
+		fmt.Fprintf(os.Stderr, "Error writing backup: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(manifest)
+		return
+	}
+
+	kind := "Full backup"
+	if manifest.Since > 0 {
+		kind = fmt.Sprintf("Incremental backup since %d", manifest.Since)
+	}
+	fmt.Printf("%s written to %s with %d object files (%s)\n",
+		kind, *outPath, manifest.Objects, formatBytes(manifest.Bytes))
+	if manifest.External > 0 {
+		fmt.Printf("%d objects are in another object store and were not copied\n", manifest.External)
+	}
+	fmt.Printf("Take the next incremental backup with -since %d\n", manifest.Next)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
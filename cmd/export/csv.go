@@ -4,11 +4,11 @@ import (
 	"encoding/csv"
 	"os"
 
-	"grit/db"
+	"grit/api"
 	"grit/types"
 )
 
-func exportResourceTableCSV(database db.Database, outputPath string, resourceName string) {
+func exportResourceTableCSV(database api.Reader, outputPath string, resourceName string) {
 	if resourceName != "" {
 		exportLogger.Printf("Exporting resources with name '%s' to CSV: %s\n", resourceName, outputPath)
 	} else {
@@ -6,8 +6,9 @@ import (
 	"fmt"
 	"os"
 
-	"grit/db"
+	"grit/api"
 	"grit/log"
+	"grit/repo"
 )
 
 var exportLogger = log.NewLogger("EXPORT")
@@ -40,7 +41,7 @@ func Execute() {
 	}
 
 	exportLogger.Printf("Initializing database at: %s\n", *dbPath)
-	database, err := db.NewDatabase(*dbPath)
+	database, err := api.Open(*dbPath, repo.Options())
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
 		os.Exit(1)
@@ -3,12 +3,12 @@ package export
 import (
 	"os"
 
-	"grit/db"
+	"grit/api"
 
 	"github.com/fatih/color"
 )
 
-func exportResourceByHash(database db.Database, hash string) {
+func exportResourceByHash(database api.Reader, hash string) {
 	exportLogger.Printf("Exporting resource with hash: %s\n", color.MagentaString(hash[:16]+"..."))
 
 	// Check if object exists
@@ -4,12 +4,12 @@ import (
 	"fmt"
 	"os"
 
-	"grit/db"
+	"grit/api"
 
 	"github.com/fatih/color"
 )
 
-func exportResourcesByName(database db.Database, resourceName string) {
+func exportResourcesByName(database api.Reader, resourceName string) {
 	exportLogger.Printf("Listing resources with name: %s\n", color.MagentaString(resourceName))
 
 	// List all resources with the given name
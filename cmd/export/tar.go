@@ -7,12 +7,12 @@ import (
 	"io"
 	"os"
 
-	"grit/db"
+	"grit/api"
 
 	"github.com/danhab99/idk/chans"
 )
 
-func exportTarball(database db.Database, outputPath string, compressed bool, resourceNames []string) {
+func exportTarball(database api.Reader, outputPath string, compressed bool, resourceNames []string) {
 
 	outFile, err := os.Create(outputPath)
 	if err != nil {
@@ -0,0 +1,89 @@
+// Description: Verify objects and find dangling records, optionally repairing them
+package fsck
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"grit/db"
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	repair  *bool
+	jsonOut *bool
+)
+
+// RegisterFlags sets up the flags for the fsck command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	repair = fs.Bool("repair", false, "fix what can be fixed without losing data")
+	jsonOut = fs.Bool("json", false, "print the report as JSON")
+}
+
+// Execute runs the command. It exits with status 1 when problems remain.
+func Execute() {
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	report, err := database.Fsck(*repair)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking database: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printReport(report)
+	}
+
+	if report.Unresolved() > 0 {
+		database.Close()
+		os.Exit(1)
+	}
+}
+
+func printReport(report db.FsckReport) {
+	expected := 0
+	repaired := 0
+	for _, issue := range report.Issues {
+		var note string
+		switch {
+		case issue.Repaired:
+			note = " [repaired]"
+			repaired++
+		case issue.Expected:
+			note = " [expected]"
+			expected++
+		}
+		if issue.Detail != "" {
+			fmt.Printf("%-20s %s: %s%s\n", issue.Kind, issue.Subject, issue.Detail, note)
+		} else {
+			fmt.Printf("%-20s %s%s\n", issue.Kind, issue.Subject, note)
+		}
+	}
+
+	fmt.Printf("Checked %d objects, %d object files, %d resources, %d tasks and %d index entries\n",
+		report.Objects, report.Files, report.Resources, report.Tasks, report.IndexEntries)
+	unresolved := report.Unresolved()
+	switch {
+	case len(report.Issues) == 0:
+		fmt.Println("No problems found")
+	default:
+		fmt.Printf("%d problems, %d repaired, %d expected\n", len(report.Issues), repaired, expected)
+		if unresolved > 0 && !*repair {
+			fmt.Println("Run with -repair to fix what can be fixed safely")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Description: Delete objects no resource refers to, and stale index entries
+package gc
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"grit/db"
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	dryRun  *bool
+	grace   *time.Duration
+	jsonOut *bool
+)
+
+// RegisterFlags sets up the flags for the gc command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	dryRun = fs.Bool("dry-run", false, "show what would be deleted without writing changes")
+	grace = fs.Duration("grace", time.Hour, "keep unreferenced objects written less than this long ago")
+	jsonOut = fs.Bool("json", false, "print the result as JSON")
+}
+
+// Execute runs the command
+func Execute() {
+	if *grace < 0 {
+		fmt.Fprintln(os.Stderr, "Error: -grace must be >= 0")
+		os.Exit(1)
+	}
+
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	res, err := database.GC(db.GCOptions{DryRun: *dryRun, Grace: *grace})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error collecting garbage: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		return
+	}
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("Scanned %d objects: %d referenced, %d newer than %s\n",
+		res.ObjectsScanned, res.ObjectsReferenced, res.ObjectsInGrace, *grace)
+	fmt.Printf("%s %d objects and %d files, reclaiming %s\n",
+		verb, res.ObjectsDeleted, res.FilesDeleted, formatBytes(res.BytesReclaimed))
+	fmt.Printf("%s %d dangling index entries and %d orphaned task logs\n",
+		verb, res.IndexEntriesDeleted, res.LogsDeleted)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
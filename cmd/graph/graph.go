@@ -0,0 +1,97 @@
+// Description: Show the step dependency graph
+package graph
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"grit/api"
+	"grit/repo"
+	"grit/types"
+)
+
+// Command flags
+var (
+	dbPath *string
+	format *string
+)
+
+// RegisterFlags sets up the flags for the graph command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	format = fs.String("format", "text", "output format: text or dot")
+}
+
+// Execute runs the command
+func Execute() {
+	if *format != "text" && *format != "dot" {
+		fmt.Fprintf(os.Stderr, "Error: -format must be text or dot\n")
+		os.Exit(1)
+	}
+
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	graph, err := api.StepGraph(database)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error building graph: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *format == "dot" {
+		printDot(graph.Steps, graph.Edges)
+	} else {
+		printText(graph.Steps, graph.Edges)
+	}
+}
+
+func printText(steps []types.Step, edges []api.Edge) {
+	for _, step := range steps {
+		var parts []string
+		if step.Input != "" {
+			parts = append(parts, "input "+step.Input)
+		}
+		if step.Reduce() {
+			parts = append(parts, "reduce")
+		}
+		if len(step.After) > 0 {
+			parts = append(parts, "after "+strings.Join(step.After, ", "))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "seed")
+		}
+		fmt.Printf("%s (v%d): %s\n", step.Name, step.Version, strings.Join(parts, "; "))
+
+		for _, e := range edges {
+			if e.To != step.Name {
+				continue
+			}
+			if e.Name != "" {
+				fmt.Printf("  <- %s [%s]\n", e.From, e.Name)
+			} else {
+				fmt.Printf("  <- %s [after]\n", e.From)
+			}
+		}
+	}
+}
+
+func printDot(steps []types.Step, edges []api.Edge) {
+	fmt.Println("digraph grit {")
+	for _, step := range steps {
+		fmt.Printf("  %q;\n", step.Name)
+	}
+	for _, e := range edges {
+		if e.Name != "" {
+			fmt.Printf("  %q -> %q [label=%q];\n", e.From, e.To, e.Name)
+		} else {
+			fmt.Printf("  %q -> %q [style=dashed, label=\"after\"];\n", e.From, e.To)
+		}
+	}
+	fmt.Println("}")
+}
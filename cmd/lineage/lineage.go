@@ -0,0 +1,107 @@
+// Description: Trace a resource back through the tasks that produced it
+package lineage
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"grit/api"
+	"grit/repo"
+	"grit/types"
+)
+
+// Command flags
+var (
+	dbPath *string
+	id     *string
+	hash   *string
+)
+
+// RegisterFlags sets up the flags for the lineage command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	id = fs.String("id", "", "resource ID to trace")
+	hash = fs.String("hash", "", "trace every resource with this object hash")
+}
+
+// Execute runs the command
+func Execute() {
+	if (*id == "") == (*hash == "") {
+		fmt.Fprintf(os.Stderr, "Error: specify one of -id or -hash\n")
+		os.Exit(1)
+	}
+
+	database, err := api.Open(*dbPath, repo.Options())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	var roots []types.Resource
+	if *id != "" {
+		resource, err := database.GetResource(*id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading resource: %v\n", err)
+			os.Exit(1)
+		}
+		if resource != nil {
+			roots = append(roots, *resource)
+		}
+	} else {
+		for resource := range database.GetAllResources() {
+			if resource.ObjectHash == *hash {
+				roots = append(roots, resource)
+			}
+		}
+	}
+	if len(roots) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no matching resource\n")
+		os.Exit(1)
+	}
+
+	for _, resource := range roots {
+		node, err := api.Lineage(database, resource.ID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error tracing %s: %v\n", resource.ID, err)
+			os.Exit(1)
+		}
+		printNode(node, 0)
+	}
+}
+
+// printNode prints a resource and, indented below it, the task that produced
+// it and that task's inputs.
+func printNode(node *api.LineageNode, depth int) {
+	indent := strings.Repeat("    ", depth)
+	if node.Resource == nil {
+		fmt.Printf("%s%s (deleted)\n", indent, node.ID)
+		return
+	}
+	resource := node.Resource
+	fmt.Printf("%s%s %s (%s)\n", indent, resource.Name, resource.ID, resource.ObjectHash)
+
+	switch {
+	case node.Repeated:
+		if resource.CreatedByTaskID != nil {
+			fmt.Printf("%s    ...\n", indent)
+		}
+		return
+	case resource.CreatedByTaskID == nil:
+		return
+	case node.Task == nil:
+		fmt.Printf("%s  <- task %s (deleted)\n", indent, *resource.CreatedByTaskID)
+		return
+	}
+
+	stepName := node.Task.StepID
+	if node.Step != nil {
+		stepName = fmt.Sprintf("%s v%d", node.Step.Name, node.Step.Version)
+	}
+	fmt.Printf("%s  <- %s, task %s\n", indent, stepName, node.Task.ID)
+	for _, input := range node.Inputs {
+		printNode(input, depth+1)
+	}
+}
@@ -0,0 +1,104 @@
+// Description: Show the captured output of tasks
+package logs
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"grit/api"
+	"grit/log"
+	"grit/repo"
+	"grit/types"
+)
+
+var logsLogger = log.NewLogger("LOGS")
+
+// Command flags
+var (
+	dbPath *string
+	taskID *string
+	step   *string
+	failed *bool
+)
+
+// RegisterFlags sets up the flags for the logs command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	taskID = fs.String("task", "", "show the output of one task")
+	step = fs.String("step", "", "show the output of every task of a step")
+	failed = fs.Bool("failed", false, "with -step, only show failed tasks")
+}
+
+// Execute runs the command
+func Execute() {
+	if (*taskID == "") == (*step == "") {
+		fmt.Fprintf(os.Stderr, "Error: specify one of -task or -step\n")
+		os.Exit(1)
+	}
+
+	database, err := api.Open(*dbPath, repo.Options())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	if *taskID != "" {
+		output, err := database.GetTaskLog(*taskID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading log: %v\n", err)
+			os.Exit(1)
+		}
+		if output == nil {
+			logsLogger.Printf("No output was kept for task %s\n", *taskID)
+			os.Exit(1)
+		}
+		os.Stdout.Write(output)
+		return
+	}
+
+	// Tasks of every version of the step, so older failures stay visible.
+	var stepIDs []string
+	for s := range database.ListSteps() {
+		if s.Name == *step {
+			stepIDs = append(stepIDs, s.ID)
+		}
+	}
+	if len(stepIDs) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no step named %s\n", *step)
+		os.Exit(1)
+	}
+
+	shown := 0
+	for _, stepID := range stepIDs {
+		for task := range database.GetTasksForStep(stepID) {
+			if *failed && task.Error == nil {
+				continue
+			}
+			output, err := database.GetTaskLog(task.ID)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading log for task %s: %v\n", task.ID, err)
+				os.Exit(1)
+			}
+			if output == nil && task.Error == nil {
+				continue
+			}
+			printTask(task, output)
+			shown++
+		}
+	}
+	logsLogger.Printf("Showed %d task(s)\n", shown)
+}
+
+func printTask(task types.Task, output []byte) {
+	fmt.Printf("==> task %s", task.ID)
+	if task.Error != nil {
+		fmt.Printf(" (failed: %s)", *task.Error)
+	}
+	fmt.Println(" <==")
+	os.Stdout.Write(output)
+	if len(output) > 0 && output[len(output)-1] != '\n' {
+		fmt.Println()
+	}
+}
@@ -0,0 +1,78 @@
+// Description: Bring a database's key layout up to date
+package migrate
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"grit/db"
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	dryRun  *bool
+	jsonOut *bool
+)
+
+// RegisterFlags sets up the flags for the migrate command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	dryRun = fs.Bool("dry-run", false, "show what each pending migration would change without writing changes")
+	jsonOut = fs.Bool("json", false, "print the result as JSON")
+}
+
+// Execute runs the command
+func Execute() {
+	database, err := db.OpenUnmigrated(*dbPath, repo.Options())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	version, err := database.SchemaVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading schema version: %v\n", err)
+		os.Exit(1)
+	}
+
+	results, err := database.Migrate(*dryRun)
+	if *jsonOut {
+		if results == nil {
+			results = []db.MigrationResult{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		printResults(version, results)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error migrating database: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func printResults(version int, results []db.MigrationResult) {
+	if len(results) == 0 {
+		fmt.Printf("Schema version %d is current, nothing to migrate\n", version)
+		return
+	}
+	fmt.Printf("Schema version %d, migrating to %d\n", version, db.SchemaVersion)
+	verb := "changed"
+	if *dryRun {
+		verb = "would change"
+	}
+	for _, res := range results {
+		resumed := ""
+		if res.Resumed {
+			resumed = " (resumed)"
+		}
+		fmt.Printf("  %d  %-30s scanned %d, %s %d%s\n",
+			res.Version, res.Description, res.Scanned, verb, res.Changed, resumed)
+	}
+}
@@ -1,12 +1,21 @@
+// Description: Show pipeline progress and statistics
 package progress
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"math"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"grit/db"
+	"grit/api"
 	"grit/log"
+	"grit/repo"
 
 	"github.com/danhab99/idk/chans"
 )
@@ -15,49 +24,251 @@ var progressLogger = log.NewLogger("progress")
 
 // Command flags
 var (
-	dbPath *string
+	dbPath   *string
+	watch    *bool
+	interval *time.Duration
+	window   *time.Duration
+	jsonOut  *bool
 )
 
 // RegisterFlags sets up the flags for the status command
 func RegisterFlags(fs *flag.FlagSet) {
 	dbPath = fs.String("db", "./db", "database path")
+	watch = fs.Bool("watch", false, "refresh in place until interrupted")
+	interval = fs.Duration("interval", 2*time.Second, "with -watch, time between refreshes")
+	window = fs.Duration("window", time.Minute, "with -watch, sliding window for throughput and ETA")
+	jsonOut = fs.Bool("json", false, "print JSON; with -watch, one object per line per refresh")
+}
+
+// StepStatus is the task tally of one step version.
+type StepStatus struct {
+	StepID  string  `json:"step_id"`
+	Name    string  `json:"name"`
+	Version int     `json:"version"`
+	Total   int64   `json:"total"`
+	Done    int64   `json:"done"`
+	Failed  int64   `json:"failed"`
+	Skipped int64   `json:"skipped"`
+	Pending int64   `json:"pending"`
+	Running int64   `json:"running"`
+	Percent float64 `json:"percent"`
+	// Rate and ETA need two samples, so they are only set with -watch.
+	Rate *float64 `json:"tasks_per_second,omitempty"`
+	ETA  *float64 `json:"eta_seconds,omitempty"`
+}
+
+// Snapshot is the progress of every step at one moment.
+type Snapshot struct {
+	Time      time.Time    `json:"time"`
+	Complete  bool         `json:"complete"`
+	Total     int64        `json:"total"`
+	Processed int64        `json:"processed"`
+	Running   int64        `json:"running"`
+	Rate      *float64     `json:"tasks_per_second,omitempty"`
+	ETA       *float64     `json:"eta_seconds,omitempty"`
+	Steps     []StepStatus `json:"steps"`
 }
 
 // Execute runs the command
 func Execute() {
-	db, err := db.NewDatabase(*dbPath)
-	if err != nil {
-		panic(err)
+	if !*watch {
+		snap, err := snapshot()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *jsonOut {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(snap)
+			return
+		}
+		render(os.Stdout, snap)
+		return
 	}
 
-	fmt.Println("Progress...")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	info, _ := os.Stdout.Stat()
+	terminal := info != nil && info.Mode()&os.ModeCharDevice != 0
+	rates := newRateTracker(*window)
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
 
-	allSteps := <-chans.Accumulate(db.ListSteps())
+	for {
+		// Open the database afresh each time so watching never holds the
+		// lock a run needs, and picks up a run that starts later.
+		snap, err := snapshot()
+		switch {
+		case err != nil && *jsonOut:
+			progressLogger.Error("Failed to read progress", "error", err)
+		case err != nil:
+			if terminal {
+				fmt.Print("\033[H\033[2J")
+			}
+			fmt.Printf("Error: %v\n", err)
+		case *jsonOut:
+			rates.update(&snap)
+			json.NewEncoder(os.Stdout).Encode(snap)
+		default:
+			rates.update(&snap)
+			if terminal {
+				fmt.Print("\033[H\033[2J")
+			} else {
+				fmt.Println()
+			}
+			render(os.Stdout, snap)
+			fmt.Printf("\nUpdated %s, every %s. Press Ctrl-C to quit.\n", snap.Time.Format("15:04:05"), *interval)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
 
-	padLen := 0
-	for _, step := range allSteps {
-		padLen = max(len(step.Name), padLen)
+// snapshot reads the current counts of every step. With -watch the database
+// is opened read-only, so refreshing never takes the lock a run needs,
+// migrates the repo or writes grit.toml; a locked database is read through
+// the control socket.
+func snapshot() (Snapshot, error) {
+	opts := repo.Options()
+	opts.ReadOnly = *watch
+	database, err := api.Open(*dbPath, opts)
+	if err != nil {
+		return Snapshot{}, err
 	}
+	defer database.Close()
 
-	for _, step := range allSteps {
+	snap := Snapshot{Time: time.Now()}
+	snap.Complete, snap.Total, snap.Processed, err = database.GetPipelineStatus()
+	if err != nil {
+		return snap, fmt.Errorf("failed to read pipeline status: %w", err)
+	}
 
-		totalSteps, err := db.CountTasksForStep(step.ID)
-		if err != nil {
-			panic(err)
+	for _, step := range <-chans.Accumulate(database.ListSteps()) {
+		s := StepStatus{StepID: step.ID, Name: step.Name, Version: step.Version}
+		counts := []struct {
+			dst   *int64
+			count func(string) (int64, error)
+		}{
+			{&s.Total, database.CountTasksForStep},
+			{&s.Pending, database.CountUnprocessedTasksForStep},
+			{&s.Skipped, database.CountSkippedTasksForStep},
+			{&s.Failed, database.CountFailedTasksForStep},
+			{&s.Running, database.CountRunningTasksForStep},
 		}
-
-		uncompletedSteps, err := db.CountUnprocessedTasksForStep(step.ID)
-		if err != nil {
-			panic(err)
+		for _, c := range counts {
+			if *c.dst, err = c.count(step.ID); err != nil {
+				return snap, fmt.Errorf("failed to count tasks of step %s: %w", step.Name, err)
+			}
+		}
+		s.Done = s.Total - s.Pending - s.Skipped - s.Failed
+		if s.Total > 0 {
+			s.Percent = 100 * float64(s.Total-s.Pending) / float64(s.Total)
 		}
+		snap.Running += s.Running
+		snap.Steps = append(snap.Steps, s)
+	}
+	return snap, nil
+}
+
+func render(w io.Writer, snap Snapshot) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tVERSION\tTOTAL\tDONE\tFAILED\tSKIPPED\tPENDING\tRUNNING\tPROGRESS\tTASKS/S\tETA\t")
+	for _, s := range snap.Steps {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%.1f%%\t%s\t%s\t\n",
+			s.Name, s.Version, s.Total, s.Done, s.Failed, s.Skipped, s.Pending, s.Running,
+			s.Percent, formatRate(s.Rate), formatETA(s.ETA, s.Pending))
+	}
+	tw.Flush()
 
-		completedSteps := totalSteps - uncompletedSteps
-		completedPercentage := (float64(completedSteps) / float64(totalSteps)) * 100
+	var percent float64
+	if snap.Total > 0 {
+		percent = 100 * float64(snap.Processed) / float64(snap.Total)
+	}
+	state := "in progress"
+	if snap.Complete {
+		state = "complete"
+	}
+	fmt.Fprintf(w, "\nPipeline %s: %d/%d tasks processed (%.1f%%), %d running",
+		state, snap.Processed, snap.Total, percent, snap.Running)
+	if snap.Rate != nil {
+		fmt.Fprintf(w, ", %s tasks/s, ETA %s", formatRate(snap.Rate), formatETA(snap.ETA, snap.Total-snap.Processed))
+	}
+	fmt.Fprintln(w)
+}
 
-		if math.IsNaN(completedPercentage) {
-			completedPercentage = 0
-		}
+func formatRate(rate *float64) string {
+	if rate == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", *rate)
+}
+
+func formatETA(eta *float64, pending int64) string {
+	switch {
+	case pending == 0:
+		return "done"
+	case eta == nil:
+		return "-"
+	}
+	d := time.Duration(*eta * float64(time.Second)).Round(time.Second)
+	switch {
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh%02dm", int(d.Hours()), int(d.Minutes())%60)
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm%02ds", int(d.Minutes()), int(d.Seconds())%60)
+	}
+	return d.String()
+}
 
-		fmt.Printf("  %*s => %3.4f%%\n", padLen, step.Name, completedPercentage)
+type sample struct {
+	at        time.Time
+	processed int64
+}
+
+// rateTracker turns successive snapshots into throughput over a sliding
+// window.
+type rateTracker struct {
+	window  time.Duration
+	history map[string][]sample
+}
+
+func newRateTracker(window time.Duration) *rateTracker {
+	return &rateTracker{window: window, history: make(map[string][]sample)}
+}
+
+// update records snap and fills in its rates and ETAs.
+func (r *rateTracker) update(snap *Snapshot) {
+	for i := range snap.Steps {
+		s := &snap.Steps[i]
+		s.Rate, s.ETA = r.observe(s.StepID, snap.Time, s.Total-s.Pending, s.Pending)
+	}
+	snap.Rate, snap.ETA = r.observe("", snap.Time, snap.Processed, snap.Total-snap.Processed)
+}
+
+func (r *rateTracker) observe(key string, at time.Time, processed, pending int64) (rate, eta *float64) {
+	samples := append(r.history[key], sample{at, processed})
+	// Keep one sample older than the window so the rate spans all of it.
+	for len(samples) > 2 && at.Sub(samples[1].at) >= r.window {
+		samples = samples[1:]
+	}
+	r.history[key] = samples
+
+	oldest := samples[0]
+	elapsed := at.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return nil, nil
+	}
+	perSecond := max(float64(processed-oldest.processed), 0) / elapsed
+	rate = &perSecond
+	if perSecond > 0 {
+		remaining := float64(pending) / perSecond
+		eta = &remaining
 	}
+	return rate, eta
 }
@@ -6,7 +6,7 @@ import (
 	"fmt"
 	"os"
 
-	"grit/db"
+	"grit/repo"
 )
 
 var (
@@ -29,7 +29,7 @@ func Execute() {
 		os.Exit(1)
 	}
 
-	database, err := db.NewDatabase(*dbPath)
+	database, err := repo.Open(*dbPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
 		os.Exit(1)
@@ -0,0 +1,144 @@
+// Description: Restore a database from backups into an empty directory and verify it
+package restore
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"grit/db"
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	inPaths stringSlice
+	verify  *bool
+	jsonOut *bool
+)
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return fmt.Sprintf("%v", *s)
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// RegisterFlags sets up the flags for the restore command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "directory to restore into; must be empty or not exist")
+	fs.Var(&inPaths, "in", "backup file to restore: a full backup, then its incremental backups in order (can be specified multiple times)")
+	verify = fs.Bool("verify", true, "run fsck on the restored database")
+	jsonOut = fs.Bool("json", false, "print the result as JSON")
+}
+
+// Execute runs the command. It exits with status 1 when verification finds
+// problems.
+func Execute() {
+	if len(inPaths) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: -in is required")
+		os.Exit(1)
+	}
+
+	var backups []io.Reader
+	for _, path := range inPaths {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening backup: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		backups = append(backups, f)
+	}
+
+	res, err := db.Restore(*dbPath, backups...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error restoring: %v\n", err)
+		fmt.Fprintf(os.Stderr, "%s may be incomplete; remove it before trying again\n", *dbPath)
+		os.Exit(1)
+	}
+
+	var report *db.FsckReport
+	if *verify {
+		database, err := repo.Open(*dbPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening restored database: %v\n", err)
+			os.Exit(1)
+		}
+		r, err := database.Fsck(false)
+		database.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error checking restored database: %v\n", err)
+			os.Exit(1)
+		}
+		report = &r
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			db.RestoreResult
+			Fsck *db.FsckReport `json:"fsck,omitempty"`
+		}{res, report})
+	} else {
+		printResult(res, report)
+	}
+
+	if report != nil && report.Unresolved() > 0 {
+		os.Exit(1)
+	}
+}
+
+func printResult(res db.RestoreResult, report *db.FsckReport) {
+	fmt.Printf("Restored %d backups up to version %d into %s: %d object files (%s)\n",
+		res.Backups, res.Next, *dbPath, res.Objects, formatBytes(res.Bytes))
+	if res.Removed > 0 {
+		fmt.Printf("Removed %d object files of objects deleted by a later backup\n", res.Removed)
+	}
+	if res.External > 0 {
+		fmt.Printf("%d objects are in another object store and were not restored\n", res.External)
+	}
+	if report == nil {
+		return
+	}
+
+	for _, issue := range report.Issues {
+		var note string
+		if issue.Expected {
+			note = " [expected]"
+		}
+		if issue.Detail != "" {
+			fmt.Printf("%-20s %s: %s%s\n", issue.Kind, issue.Subject, issue.Detail, note)
+		} else {
+			fmt.Printf("%-20s %s%s\n", issue.Kind, issue.Subject, note)
+		}
+	}
+	fmt.Printf("Verified %d objects, %d object files, %d resources and %d tasks\n",
+		report.Objects, report.Files, report.Resources, report.Tasks)
+	if unresolved := report.Unresolved(); unresolved > 0 {
+		fmt.Printf("%d problems found; see grit fsck -db %s\n", unresolved, *dbPath)
+	} else {
+		fmt.Println("No problems found")
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
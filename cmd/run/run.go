@@ -3,8 +3,13 @@ package run
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
@@ -14,18 +19,23 @@ import (
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"grit/api"
 	"grit/db"
 	"grit/exec"
 	"grit/log"
 	"grit/manifest"
+	"grit/metrics"
 	"grit/pipeline"
+	"grit/repo"
+	"grit/tracing"
 	"grit/types"
 	"grit/utils"
 
-	"github.com/pelletier/go-toml"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var runLogger = log.NewLogger("RUN")
@@ -39,6 +49,12 @@ var (
 	pprofAddr       *string
 	profileDir      *string
 	profileInterval *time.Duration
+	sample          *int
+	sampleSeed      *int64
+	shard           *string
+	overlayPath     *string
+	metricsAddr     *string
+	objectStore     *string
 )
 
 type stringSlice []string
@@ -58,6 +74,33 @@ func RegisterFlags(fs *flag.FlagSet) {
 	dbPath = fs.String("db", "./db", "database path")
 	parallel = fs.Int("parallel", runtime.NumCPU(), "number of processes to run in parallel")
 	fs.Var(&enabledSteps, "step", "steps to run (can be specified multiple times)")
+	sample = fs.Int("sample", 0, "run each selected step on a deterministic sample of N inputs")
+	sampleSeed = fs.Int64("sample-seed", 0, "seed for -sample")
+	shard = fs.String("shard", "", "only process tasks in shard k of n (e.g. 3/8)")
+	overlayPath = fs.String("overlay", "", "with -sample, write tasks and outputs to this throwaway database instead of -db")
+	metricsAddr = fs.String("metrics-addr", "", "serve Prometheus metrics at /metrics on this address (e.g. :9090)")
+	objectStore = fs.String("object-store", "", "store large objects here from now on, e.g. s3://bucket/prefix?endpoint=host:9000 or fs (overrides the manifest)")
+	tracing.RegisterFlags(fs)
+}
+
+// parseShard parses a "k/n" shard spec with 1 <= k <= n.
+func parseShard(spec string) (int, int, error) {
+	k, n, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("shard must be k/n, got %q", spec)
+	}
+	shardIndex, err := strconv.Atoi(k)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid shard index %q: %w", k, err)
+	}
+	shardCount, err := strconv.Atoi(n)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid shard count %q: %w", n, err)
+	}
+	if shardCount < 1 || shardIndex < 1 || shardIndex > shardCount {
+		return 0, 0, fmt.Errorf("shard %q out of range: need 1 <= k <= n", spec)
+	}
+	return shardIndex, shardCount, nil
 }
 
 // Execute runs the pipeline
@@ -72,40 +115,119 @@ func Execute() {
 
 	runLogger.Printf("Loading manifest from: %s\n", *manifestPath)
 
-	manifestToml, err := os.ReadFile(*manifestPath)
+	m, err := manifest.Load(*manifestPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading manifest: %v\n", err)
 		os.Exit(1)
 	}
-
-	var m manifest.Manifest
-	err = toml.Unmarshal(manifestToml, &m)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error parsing manifest: %v\n", err)
+	if err := m.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid manifest:\n%v\n", err)
 		os.Exit(1)
 	}
 	runLogger.Printf("Loaded %d steps from manifest\n", len(m.Steps))
 
+	opts := pipeline.Options{Sample: *sample, SampleSeed: *sampleSeed}
+	if *shard != "" {
+		opts.Shard, opts.Shards, err = parseShard(*shard)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if *overlayPath != "" && *sample <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: -overlay requires -sample\n")
+		os.Exit(1)
+	}
+
 	// Check disk space before opening database
 	utils.CheckDiskSpace(*dbPath)
 
 	runLogger.Printf("Initializing database at: %s\n", *dbPath)
-	database, err := db.NewDatabase(*dbPath)
+	database, err := repo.Open(*dbPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
 		os.Exit(1)
 	}
 	defer database.Close()
 
-	// Limit the Go heap to 512 MB so the GC scavenger returns idle pages to
-	// the OS aggressively instead of sitting on hundreds of MB indefinitely.
-	debug.SetMemoryLimit(512 << 20)
+	uri := *objectStore
+	if uri == "" {
+		uri = m.ObjectStore
+	}
+	if uri != "" {
+		if err := database.UseObjectStore(uri); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring object store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	runLogger.Printf("Storing large objects in: %s\n", database.ObjectStore())
+
+	// Let progress, export and friends read the database while it is locked.
+	if stopSocket, err := api.ServeSocket(database, *dbPath); err != nil {
+		runLogger.Printf("Warning: read-only access while running is unavailable: %v\n", err)
+	} else {
+		defer stopSocket()
+	}
+
+	var overlay *db.Database
+	if *overlayPath != "" {
+		runLogger.Printf("Writing sampled run to overlay at: %s\n", *overlayPath)
+		// -set is for -db; the throwaway overlay keeps its own grit.toml.
+		overlayDB, err := db.NewDatabase(*overlayPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening overlay database: %v\n", err)
+			os.Exit(1)
+		}
+		defer overlayDB.Close()
+		overlay = &overlayDB
+	}
+
+	// Limit the Go heap (512 MB by default) so the GC scavenger returns idle
+	// pages to the OS aggressively instead of sitting on hundreds of MB
+	// indefinitely.
+	config := database.Config()
+	debug.SetMemoryLimit(int64(config.Run.MemoryLimit))
 
 	stopGC := make(chan struct{})
-	database.StartValueLogGC(30*time.Second, stopGC)
+	database.StartValueLogGC(time.Duration(config.Run.ValueLogGCInterval), stopGC)
 	defer close(stopGC)
 
-	run(m, database, *parallel, enabledSteps)
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr, database)
+	}
+
+	shutdownTracing, err := tracing.ApplyFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up tracing: %v\n", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing()
+
+	run(m, database, overlay, opts, *parallel, enabledSteps)
+}
+
+var residentMemory = metrics.NewGaugeFunc("process_resident_memory_bytes", "Resident memory size in bytes.")
+
+// serveMetrics exposes /metrics on addr for the rest of the run.
+func serveMetrics(addr string, database db.Database) {
+	database.ExportMetrics()
+	residentMemory.Set(func() []metrics.Sample {
+		return []metrics.Sample{{Value: float64(readRSSKB() * 1024)}}
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", addr, err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			runLogger.Printf("Metrics server stopped: %v\n", err)
+		}
+	}()
+	runLogger.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
 }
 
 // readRSSKB returns the current process RSS in kilobytes by reading
@@ -230,38 +352,123 @@ func startPeriodicProfiles(dir string, interval time.Duration) func() {
 	return stop
 }
 
-func constructRunnerPipeline(m manifest.Manifest, database db.Database, enabledSteps []string) ([]types.Step, *pipeline.Pipeline, func()) {
-	steps := m.RegisterSteps(&database, enabledSteps)
+// newRunRecord describes this invocation for the run history.
+func newRunRecord() types.Run {
+	record := types.Run{
+		ManifestPath: *manifestPath,
+		Args:         os.Args[2:],
+		GritVersion:  utils.GritVersion(),
+	}
+	if abs, err := filepath.Abs(*manifestPath); err == nil {
+		record.ManifestPath = abs
+	}
+	record.Host, _ = os.Hostname()
+	if content, err := os.ReadFile(*manifestPath); err == nil {
+		sum := sha256.Sum256(content)
+		record.Manifest = string(content)
+		record.ManifestHash = hex.EncodeToString(sum[:])
+	}
+	return record
+}
+
+// constructRunnerPipeline registers the steps and builds the pipeline. On a
+// termination signal it calls onSignal and closes the databases.
+func constructRunnerPipeline(m manifest.Manifest, database db.Database, overlay *db.Database, opts pipeline.Options, enabledSteps []string, onSignal func()) ([]types.Step, *pipeline.Pipeline, func()) {
+	// With an overlay, steps, tasks and outputs all live in the overlay and
+	// only input resources are read from the main database.
+	target := &database
+	var store exec.Store = &database
+	if overlay != nil {
+		target = overlay
+		store = exec.NewOverlayStore(&database, overlay)
+		opts.Source = &database
+	}
+
+	steps := m.RegisterSteps(target, enabledSteps)
 
 	runLogger.Printf("Registered %d steps\n", len(steps))
 
-	executor := exec.NewScriptExecutor(&database)
+	executor := exec.NewScriptExecutor(store)
 
 	// Create pipeline
-	pipeline, err := pipeline.NewPipeline(executor, &database)
+	pipeline, err := pipeline.NewPipeline(executor, target, opts)
 	if err != nil {
 		panic(err)
 	}
 
 	stop := func() {
 		database.Close()
+		if overlay != nil {
+			overlay.Close()
+		}
 	}
 
 	go func() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGABRT, os.Interrupt, os.Kill, syscall.SIGTERM)
 		<-c
+		onSignal()
 		stop()
 	}()
 
 	return steps, pipeline, stop
 }
 
-func run(m manifest.Manifest, database db.Database, parallel int, enabledSteps []string) {
+func run(m manifest.Manifest, database db.Database, overlay *db.Database, opts pipeline.Options, parallel int, enabledSteps []string) {
 	startTime := time.Now()
+	ctx, span := tracing.Start(context.Background(), "run")
+	defer span.End()
+
+	// The run is recorded where its tasks go: the overlay, if there is one.
+	target := database
+	if overlay != nil {
+		target = *overlay
+	}
+	record := newRunRecord()
+	if err := target.BeginRun(&record); err != nil {
+		runLogger.Warn("Failed to record run", "error", err)
+		record.ID = ""
+	} else {
+		runLogger.Info("Started run", "run_id", record.ID)
+		span.SetAttributes(attribute.String("grit.run_id", record.ID))
+	}
 
-	// Ingest CSV files before pipeline execution
-	if len(m.CsvFiles) > 0 {
+	var steps []types.Step
+	var pipeline *pipeline.Pipeline
+	var finishOnce sync.Once
+	finish := func(status string, runErr error) {
+		finishOnce.Do(func() {
+			if record.ID == "" {
+				return
+			}
+			record.Status = status
+			if runErr != nil {
+				record.Error = runErr.Error()
+			}
+			if pipeline != nil {
+				record.Steps = pipeline.Tally(steps)
+			}
+			if err := target.FinishRun(record); err != nil {
+				runLogger.Warn("Failed to record end of run", "run_id", record.ID, "error", err)
+			}
+		})
+	}
+
+	steps, pipeline, stop := constructRunnerPipeline(m, database, overlay, opts, enabledSteps, func() {
+		finish(types.RunStatusInterrupted, nil)
+	})
+	defer stop()
+	defer func() {
+		if r := recover(); r != nil {
+			finish(types.RunStatusFailed, fmt.Errorf("%v", r))
+			panic(r)
+		}
+		finish(types.RunStatusSucceeded, nil)
+	}()
+
+	// Ingest CSV files before pipeline execution. An overlay run must leave
+	// the main database untouched, so it works with what is already there.
+	if len(m.CsvFiles) > 0 && overlay == nil {
 		csvCount, err := m.IngestCsvFiles(&database)
 		if err != nil {
 			runLogger.Printf("Error ingesting CSV files: %v\n", err)
@@ -271,40 +478,28 @@ func run(m manifest.Manifest, database db.Database, parallel int, enabledSteps [
 			runLogger.Printf("Ingested %d rows from %d CSV file(s)\n", csvCount, len(m.CsvFiles))
 		}
 	}
-
-	steps, pipeline, stop := constructRunnerPipeline(m, database, enabledSteps)
-	defer stop()
-
-	// Check if we need to seed
-	resourceCount, err := database.CountResources()
-	if err != nil {
-		panic(err)
-	}
+	span.SetAttributes(attribute.Int("grit.steps", len(steps)))
 
 	// Execute all steps
 	var totalStepExecutions int64
 
-	if resourceCount == 0 {
-		runLogger.Printf("No resources found, running seed steps\n")
-
-		for step := range database.GetStepsWithZeroInputs() {
-			totalStepExecutions += pipeline.ExecuteStep(step, parallel)
+	// Seeds go first so their output is there for the passes below. Each
+	// seed's policy decides whether it actually runs again.
+	for _, step := range steps {
+		if step.Input == "" {
+			totalStepExecutions += pipeline.ExecuteStep(ctx, step, parallel)
 		}
 	}
 
-	resourceCount, err = database.CountResources()
-	if err != nil {
-		panic(err)
-	}
-
-	if resourceCount == 0 {
-		panic("No resources were seeded")
+	// run twice to check that everything is done. A sampled run only
+	// executes its sample, so one pass is enough.
+	passes := 2
+	if opts.Sample > 0 {
+		passes = 1
 	}
-
-	// run twice to check that everything is done
-	for range 2 {
+	runPass := func() {
 		for _, step := range steps {
-			executions := pipeline.ExecuteStep(step, parallel)
+			executions := pipeline.ExecuteStep(ctx, step, parallel)
 			totalStepExecutions += executions
 
 			if executions > 0 {
@@ -317,7 +512,26 @@ func run(m manifest.Manifest, database db.Database, parallel int, enabledSteps [
 			debug.FreeOSMemory()
 		}
 	}
+	for range passes {
+		runPass()
+	}
+
+	// Nothing more will arrive in this run, so batches held back for
+	// batch_timeout are written now instead of waiting for a later run. Their
+	// outputs may fill held batches downstream, hence the loop.
+	for opts.Sample == 0 {
+		flushed, err := pipeline.FlushHeldBatches(steps)
+		if err != nil {
+			runLogger.Printf("Error flushing held batches: %v\n", err)
+			panic(err)
+		}
+		if flushed == 0 {
+			break
+		}
+		runPass()
+	}
 
+	span.SetAttributes(tracing.ExecutedKey.Int64(totalStepExecutions))
 	duration := time.Since(startTime)
 	runLogger.Printf("Pipeline complete: %d step tasks executed in %s\n", totalStepExecutions, duration.Round(time.Millisecond))
 }
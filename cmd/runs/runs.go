@@ -0,0 +1,241 @@
+// Description: List past runs and show what one of them did
+package runs
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"grit/api"
+	"grit/repo"
+	"grit/types"
+)
+
+// Command flags
+var (
+	flags        *flag.FlagSet
+	dbPath       *string
+	limit        *int
+	jsonOut      *bool
+	showManifest *bool
+	showOutputs  *bool
+)
+
+// RegisterFlags sets up the flags for the runs command
+func RegisterFlags(fs *flag.FlagSet) {
+	flags = fs
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage:\n  grit runs list [flags]\n  grit runs show [flags] <run-id|latest>\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	dbPath = fs.String("db", "./db", "database path")
+	limit = fs.Int("n", 20, "list: show this many of the most recent runs (0 for all)")
+	jsonOut = fs.Bool("json", false, "print JSON")
+	showManifest = fs.Bool("manifest", false, "show: print the manifest the run used")
+	showOutputs = fs.Bool("resources", false, "show: list every resource the run created")
+}
+
+// Execute runs the command
+func Execute() {
+	args := positional(flags.Args())
+	if len(args) == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	database, err := api.Open(*dbPath, repo.Options())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	runs, err := database.ListRuns()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading runs: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		list(runs)
+	case args[0] == "show" && len(args) == 2:
+		i, err := find(runs, args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		show(database, runs, i)
+	default:
+		flags.Usage()
+		os.Exit(2)
+	}
+}
+
+// positional lets flags follow the subcommand and run ID, returning the
+// non-flag arguments.
+func positional(args []string) []string {
+	var out []string
+	for len(args) > 0 {
+		out = append(out, args[0])
+		flags.Parse(args[1:])
+		args = flags.Args()
+	}
+	return out
+}
+
+// find returns the index of the run with the given ID, unique ID prefix, or
+// "latest".
+func find(runs []types.Run, id string) (int, error) {
+	if len(runs) == 0 {
+		return 0, fmt.Errorf("no runs recorded")
+	}
+	if id == "latest" {
+		return len(runs) - 1, nil
+	}
+	match := -1
+	for i, run := range runs {
+		if run.ID == id {
+			return i, nil
+		}
+		if strings.HasPrefix(run.ID, strings.ToUpper(id)) {
+			if match >= 0 {
+				return 0, fmt.Errorf("run ID prefix %q is ambiguous", id)
+			}
+			match = i
+		}
+	}
+	if match < 0 {
+		return 0, fmt.Errorf("no run %q", id)
+	}
+	return match, nil
+}
+
+func list(runs []types.Run) {
+	// Newest first.
+	recent := make([]types.Run, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		if *limit > 0 && len(recent) == *limit {
+			break
+		}
+		recent = append(recent, runs[i])
+	}
+
+	if *jsonOut {
+		printJSON(recent)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTARTED\tDURATION\tSTATUS\tSUCCEEDED\tFAILED\tMANIFEST\tHOST\tVERSION")
+	for _, run := range recent {
+		var succeeded, failed int64
+		for _, step := range run.Steps {
+			succeeded += step.Succeeded
+			failed += step.Failed
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			run.ID, run.StartedAt, duration(run), run.Status, succeeded, failed,
+			short(run.ManifestHash), run.Host, run.GritVersion)
+	}
+	tw.Flush()
+}
+
+func show(database api.Reader, runs []types.Run, i int) {
+	run := runs[i]
+	if *jsonOut {
+		printJSON(run)
+		return
+	}
+
+	fmt.Printf("Run       %s\n", run.ID)
+	fmt.Printf("Status    %s\n", run.Status)
+	if run.Error != "" {
+		fmt.Printf("Error     %s\n", run.Error)
+	}
+	fmt.Printf("Started   %s\n", run.StartedAt)
+	if run.FinishedAt != "" {
+		fmt.Printf("Finished  %s (%s)\n", run.FinishedAt, duration(run))
+	}
+	fmt.Printf("Host      %s\n", run.Host)
+	fmt.Printf("Version   %s\n", run.GritVersion)
+	fmt.Printf("Command   grit run %s\n", strings.Join(run.Args, " "))
+	fmt.Printf("Manifest  %s (%s)\n", run.ManifestPath, short(run.ManifestHash))
+	if i > 0 {
+		prev := runs[i-1]
+		if prev.ManifestHash == run.ManifestHash {
+			fmt.Printf("          unchanged since run %s\n", prev.ID)
+		} else {
+			fmt.Printf("          changed since run %s (%s)\n", prev.ID, short(prev.ManifestHash))
+		}
+	}
+
+	if len(run.Steps) > 0 {
+		fmt.Println()
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "STEP\tVERSION\tSCHEDULED\tSUCCEEDED\tFAILED\tSKIPPED")
+		for _, step := range run.Steps {
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n",
+				step.Name, step.Version, step.Scheduled, step.Succeeded, step.Failed, step.Skipped)
+		}
+		tw.Flush()
+	}
+
+	byName := make(map[string]int)
+	var created []types.Resource
+	for resource := range database.GetResourcesForRun(run.ID) {
+		byName[resource.Name]++
+		if *showOutputs {
+			created = append(created, resource)
+		}
+	}
+	if len(byName) > 0 {
+		names := make([]string, 0, len(byName))
+		for name := range byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("\nResources created:")
+		for _, name := range names {
+			fmt.Printf("  %-20s %d\n", name, byName[name])
+		}
+	}
+	if *showOutputs && len(created) > 0 {
+		fmt.Println()
+		for _, resource := range created {
+			fmt.Printf("%s  %s  %s\n", resource.ID, resource.Name, resource.ObjectHash)
+		}
+	}
+
+	if *showManifest {
+		fmt.Printf("\n%s", run.Manifest)
+	}
+}
+
+func duration(run types.Run) string {
+	start, err := time.Parse(time.RFC3339, run.StartedAt)
+	if err != nil {
+		return "-"
+	}
+	end := time.Now()
+	if run.FinishedAt != "" {
+		if end, err = time.Parse(time.RFC3339, run.FinishedAt); err != nil {
+			return "-"
+		}
+	}
+	return end.Sub(start).Round(time.Second).String()
+}
+
+func short(hash string) string {
+	return hash[:min(12, len(hash))]
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,183 @@
+// Description: Serve the HTTP API and coordinate distributed workers
+package serve
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"grit/api"
+	"grit/cluster"
+	"grit/dashboard"
+	"grit/db"
+	"grit/log"
+	"grit/manifest"
+	"grit/metrics"
+	"grit/repo"
+)
+
+var serveLogger = log.NewLogger("SERVE")
+
+// Command flags
+var (
+	manifestPath *string
+	dbPath       *string
+	addr         *string
+	leaseTTL     *time.Duration
+	httpAddr     *string
+	objectStore  *string
+)
+
+// RegisterFlags sets up the flags for the serve command
+func RegisterFlags(fs *flag.FlagSet) {
+	manifestPath = fs.String("manifest", "", "manifest path; coordinates workers when set")
+	dbPath = fs.String("db", "./db", "database path")
+	addr = fs.String("addr", "127.0.0.1:7070", "listen address (host:port or unix:/path/to/socket); unauthenticated, keep it on a trusted network")
+	leaseTTL = fs.Duration("lease-ttl", 30*time.Second, "how long a task lease lasts without a heartbeat")
+	httpAddr = fs.String("http", "", "serve the dashboard and JSON API on this address (e.g. :8080)")
+	objectStore = fs.String("object-store", "", "store large objects here from now on, e.g. s3://bucket/prefix?endpoint=host:9000 or fs (overrides the manifest)")
+}
+
+// Execute serves until interrupted
+func Execute() {
+	if *manifestPath == "" && *httpAddr == "" {
+		fmt.Fprintf(os.Stderr, "Error: specify -manifest, -http, or both\n")
+		os.Exit(1)
+	}
+
+	var m *manifest.Manifest
+	if *manifestPath != "" {
+		loaded, err := manifest.Load(*manifestPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if err := loaded.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid manifest:\n%v\n", err)
+			os.Exit(1)
+		}
+		m = &loaded
+	}
+
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	uri := *objectStore
+	if uri == "" && m != nil {
+		uri = m.ObjectStore
+	}
+	if uri != "" {
+		if err := database.UseObjectStore(uri); err != nil {
+			fmt.Fprintf(os.Stderr, "Error configuring object store: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Let progress, export and friends read the database while it is locked.
+	if stopSocket, err := api.ServeSocket(database, *dbPath); err != nil {
+		serveLogger.Printf("Warning: read-only access while running is unavailable: %v\n", err)
+	} else {
+		defer stopSocket()
+	}
+
+	stopGC := make(chan struct{})
+	database.StartValueLogGC(time.Duration(database.Config().Run.ValueLogGCInterval), stopGC)
+	defer close(stopGC)
+
+	var servers []*http.Server
+	if m != nil {
+		servers = append(servers, startCoordinator(m, &database))
+	}
+	if *httpAddr != "" {
+		servers = append(servers, startAPI(database))
+	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	<-c
+	serveLogger.Printf("Shutting down\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
+}
+
+// startCoordinator registers the manifest's steps and leases their tasks to
+// workers on -addr.
+func startCoordinator(m *manifest.Manifest, database *db.Database) *http.Server {
+	if len(m.CsvFiles) > 0 {
+		csvCount, err := m.IngestCsvFiles(database)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error ingesting CSV files: %v\n", err)
+			os.Exit(1)
+		}
+		if csvCount > 0 {
+			serveLogger.Printf("Ingested %d rows from %d CSV file(s)\n", csvCount, len(m.CsvFiles))
+		}
+	}
+
+	steps := m.RegisterSteps(database, nil)
+	serveLogger.Printf("Registered %d steps\n", len(steps))
+
+	coordinator := cluster.NewCoordinator(database, steps, *leaseTTL)
+	if _, err := coordinator.Schedule(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error scheduling tasks: %v\n", err)
+		os.Exit(1)
+	}
+
+	listener, err := cluster.Listen(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", *addr, err)
+		os.Exit(1)
+	}
+
+	server := &http.Server{Handler: coordinator.Handler()}
+	go serve(server, listener)
+	serveLogger.Printf("Coordinator listening on %s\n", *addr)
+	return server
+}
+
+// startAPI serves the read-only JSON API, the dashboard and metrics on -http.
+func startAPI(database db.Database) *http.Server {
+	listener, err := net.Listen("tcp", *httpAddr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error listening on %s: %v\n", *httpAddr, err)
+		os.Exit(1)
+	}
+
+	database.ExportMetrics()
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api.NewHandler(database))
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.Handle("/", dashboard.Handler())
+
+	// Event streams never end on their own; end them when shutting down.
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	server.RegisterOnShutdown(cancel)
+	go serve(server, listener)
+	serveLogger.Printf("Dashboard and API listening on http://%s/\n", listener.Addr())
+	return server
+}
+
+func serve(server *http.Server, listener net.Listener) {
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error serving: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,73 @@
+// Description: Copy a database to a new local repo, hard-linking its objects
+package snapshot
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"grit/repo"
+)
+
+// Command flags
+var (
+	dbPath  *string
+	outPath *string
+	jsonOut *bool
+)
+
+// RegisterFlags sets up the flags for the snapshot command
+func RegisterFlags(fs *flag.FlagSet) {
+	dbPath = fs.String("db", "./db", "database path")
+	outPath = fs.String("out", "", "directory to create the snapshot in; must be empty or not exist (required)")
+	jsonOut = fs.Bool("json", false, "print the result as JSON")
+}
+
+// Execute runs the command
+func Execute() {
+	if *outPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: -out is required")
+		os.Exit(1)
+	}
+
+	database, err := repo.Open(*dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening database: %v\n", err)
+		os.Exit(1)
+	}
+	defer database.Close()
+
+	res, err := database.Snapshot(*outPath)
+	if err != nil {
+		database.Close()
+		fmt.Fprintf(os.Stderr, "Error taking snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+		return
+	}
+
+	fmt.Printf("Snapshot written to %s: %d object files linked, %d copied (%s)\n",
+		*outPath, res.Linked, res.Copied, formatBytes(res.BytesCopied))
+	if res.External > 0 {
+		fmt.Printf("%d objects are in another object store, shared with %s\n", res.External, *dbPath)
+	}
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
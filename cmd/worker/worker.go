@@ -0,0 +1,69 @@
+// Description: Run tasks leased from a coordinator
+package worker
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"grit/cluster"
+	"grit/log"
+	"grit/tracing"
+)
+
+var workerLogger = log.NewLogger("WORKER")
+
+// Command flags
+var (
+	coordinator *string
+	name        *string
+	parallel    *int
+	poll        *time.Duration
+	exitIdle    *bool
+)
+
+// RegisterFlags sets up the flags for the worker command
+func RegisterFlags(fs *flag.FlagSet) {
+	coordinator = fs.String("coordinator", "", "coordinator address (host:port or unix:/path/to/socket, required)")
+	name = fs.String("name", "", "worker name (default hostname-pid)")
+	parallel = fs.Int("parallel", runtime.NumCPU(), "number of tasks to run at once")
+	poll = fs.Duration("poll", time.Second, "how often to ask for work when idle")
+	exitIdle = fs.Bool("exit-idle", false, "exit once the coordinator has no pending work")
+	tracing.RegisterFlags(fs)
+}
+
+// Execute runs the worker until interrupted
+func Execute() {
+	if *coordinator == "" {
+		fmt.Fprintf(os.Stderr, "Error: -coordinator is required\n")
+		os.Exit(1)
+	}
+	if *name == "" {
+		host, _ := os.Hostname()
+		*name = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+
+	shutdownTracing, err := tracing.ApplyFlags()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up tracing: %v\n", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	workerLogger.Printf("Worker %s connecting to %s (parallel %d)\n", *name, *coordinator, *parallel)
+	client := cluster.NewClient(*coordinator, *name)
+	w := cluster.NewWorker(client, *parallel, *poll, *exitIdle)
+	if err := w.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,22 @@
+// Package dashboard embeds the web UI that `grit serve -http` serves next to
+// the JSON API. The UI is plain HTML and JavaScript and reads everything from
+// /api/v1, so it needs no build step.
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the dashboard's static files.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
@@ -0,0 +1,445 @@
+"use strict";
+
+// Everything here reads from the JSON API under /api/v1. The pipeline view
+// refreshes on server-sent events and on a timer as a fallback.
+
+const API = "/api/v1";
+const REFRESH_MS = 5000;
+// Throughput is measured over this window of progress samples.
+const RATE_WINDOW_MS = 60000;
+
+async function getJSON(path) {
+  const resp = await fetch(API + path);
+  if (!resp.ok) throw new Error(`${path}: ${resp.status} ${await resp.text()}`);
+  return resp.json();
+}
+
+async function getText(path, headers) {
+  const resp = await fetch(API + path, { headers });
+  if (!resp.ok) throw new Error(`${path}: ${resp.status}`);
+  return resp.text();
+}
+
+// el builds a DOM element. Children may be strings or elements.
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+  for (const [key, value] of Object.entries(attrs || {})) {
+    if (key === "onclick") node.onclick = value;
+    else if (key === "class") node.className = value;
+    else node.setAttribute(key, value);
+  }
+  for (const child of children) {
+    if (child != null) node.append(child);
+  }
+  return node;
+}
+
+function svg(tag, attrs, ...children) {
+  const node = document.createElementNS("http://www.w3.org/2000/svg", tag);
+  for (const [key, value] of Object.entries(attrs || {})) node.setAttribute(key, value);
+  for (const child of children) node.append(child);
+  return node;
+}
+
+function formatBytes(n) {
+  const units = ["B", "KiB", "MiB", "GiB", "TiB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return `${i === 0 ? n : n.toFixed(1)} ${units[i]}`;
+}
+
+function formatDuration(seconds) {
+  if (!isFinite(seconds)) return "–";
+  if (seconds < 60) return `${Math.ceil(seconds)}s`;
+  if (seconds < 3600) return `${Math.floor(seconds / 60)}m ${Math.ceil(seconds % 60)}s`;
+  return `${Math.floor(seconds / 3600)}h ${Math.floor((seconds % 3600) / 60)}m`;
+}
+
+function progressBar(counts) {
+  const done = counts.total - counts.unprocessed - counts.skipped;
+  const pct = (n) => (counts.total ? (100 * n) / counts.total : 0);
+  const bar = el("div", { class: "bar", title: `${pct(done + counts.skipped).toFixed(1)}%` });
+  bar.append(el("span", { class: "done", style: `left:0;width:${pct(done)}%` }));
+  bar.append(el("span", { class: "skip", style: `left:${pct(done)}%;width:${pct(counts.skipped)}%` }));
+  return bar;
+}
+
+// --- Pipeline view ---
+
+const pipeline = {
+  graph: null,
+  // samples[stepID] is a list of {t, finished} used for throughput and ETA.
+  samples: {},
+  pending: null,
+};
+
+async function refreshPipeline() {
+  const [graph, progress] = await Promise.all([getJSON("/graph"), getJSON("/progress")]);
+  pipeline.graph = graph;
+
+  const now = Date.now();
+  const byName = {};
+  for (const p of progress) {
+    byName[p.name] = p;
+    const samples = (pipeline.samples[p.step_id] ||= []);
+    samples.push({ t: now, finished: p.total - p.unprocessed });
+    while (samples.length > 2 && now - samples[0].t > RATE_WINDOW_MS) samples.shift();
+  }
+
+  renderDAG(graph, byName);
+  renderProgress(progress);
+}
+
+// schedulePipelineRefresh coalesces bursts of events into one refresh.
+function schedulePipelineRefresh() {
+  if (pipeline.pending) return;
+  pipeline.pending = setTimeout(() => {
+    pipeline.pending = null;
+    refreshPipeline().catch(console.error);
+  }, 500);
+}
+
+function rate(stepID) {
+  const samples = pipeline.samples[stepID] || [];
+  if (samples.length < 2) return 0;
+  const first = samples[0];
+  const last = samples[samples.length - 1];
+  const seconds = (last.t - first.t) / 1000;
+  return seconds > 0 ? (last.finished - first.finished) / seconds : 0;
+}
+
+function renderProgress(progress) {
+  const tbody = document.querySelector("#progress tbody");
+  tbody.replaceChildren();
+  for (const p of progress) {
+    const perSecond = rate(p.step_id);
+    const eta = p.unprocessed === 0 ? "done" : perSecond > 0 ? formatDuration(p.unprocessed / perSecond) : "–";
+    tbody.append(
+      el("tr", {},
+        el("td", {}, `${p.name} v${p.version}`),
+        el("td", {}, progressBar(p)),
+        el("td", {}, String(p.total - p.unprocessed - p.skipped)),
+        el("td", {}, String(p.skipped)),
+        el("td", {}, String(p.unprocessed)),
+        el("td", {}, String(p.total)),
+        el("td", {}, perSecond > 0 ? `${(perSecond * 60).toFixed(1)}/min` : "–"),
+        el("td", {}, eta),
+      ),
+    );
+  }
+}
+
+// layers assigns each step to a column by its longest path from a source.
+function layers(steps, edges) {
+  const depth = {};
+  for (const s of steps) depth[s.name] = 0;
+  // Relax edges until stable; manifests reject cycles, so this terminates
+  // within one pass per step.
+  for (let i = 0; i < steps.length; i++) {
+    let changed = false;
+    for (const e of edges) {
+      if (e.from in depth && e.to in depth && depth[e.to] < depth[e.from] + 1) {
+        depth[e.to] = depth[e.from] + 1;
+        changed = true;
+      }
+    }
+    if (!changed) break;
+  }
+  return depth;
+}
+
+function renderDAG(graph, progressByName) {
+  const W = 180, H = 56, GAP_X = 90, GAP_Y = 24, PAD = 12;
+  const depth = layers(graph.steps, graph.edges);
+  const columns = [];
+  for (const s of graph.steps) (columns[depth[s.name]] ||= []).push(s);
+
+  const pos = {};
+  columns.forEach((column, x) => {
+    column.forEach((s, y) => {
+      pos[s.name] = { x: PAD + x * (W + GAP_X), y: PAD + y * (H + GAP_Y) };
+    });
+  });
+
+  const width = PAD * 2 + columns.length * (W + GAP_X) - GAP_X;
+  const height = PAD * 2 + Math.max(1, ...columns.map((c) => c.length)) * (H + GAP_Y) - GAP_Y;
+  const root = svg("svg", { width, height, viewBox: `0 0 ${width} ${height}` });
+
+  for (const e of graph.edges) {
+    const a = pos[e.from], b = pos[e.to];
+    if (!a || !b) continue;
+    const x1 = a.x + W, y1 = a.y + H / 2, x2 = b.x, y2 = b.y + H / 2;
+    const mid = (x1 + x2) / 2;
+    root.append(svg("path", {
+      class: e.name ? "edge" : "edge after",
+      d: `M${x1},${y1} C${mid},${y1} ${mid},${y2} ${x2},${y2}`,
+      "marker-end": "url(#arrow)",
+    }));
+    const label = svg("text", { class: "edge-label", x: mid, y: (y1 + y2) / 2 - 4, "text-anchor": "middle" });
+    label.textContent = e.name || "after";
+    root.append(label);
+  }
+
+  const defs = svg("defs", {},
+    svg("marker", { id: "arrow", viewBox: "0 0 10 10", refX: 10, refY: 5, markerWidth: 6, markerHeight: 6, orient: "auto" },
+      svg("path", { d: "M0,0 L10,5 L0,10 z", fill: "#8c959f" })));
+  root.prepend(defs);
+
+  for (const s of graph.steps) {
+    const p = pos[s.name];
+    const counts = progressByName[s.name] || { total: 0, unprocessed: 0, skipped: 0 };
+    const finished = counts.total - counts.unprocessed;
+    const frac = counts.total ? finished / counts.total : 0;
+
+    const name = svg("text", { x: 8, y: 18 });
+    name.textContent = `${s.name} v${s.version}`;
+    const detail = svg("text", { x: 8, y: 34, class: "muted" });
+    detail.textContent = `${finished}/${counts.total} tasks`;
+
+    root.append(svg("g", { class: "node", transform: `translate(${p.x},${p.y})` },
+      svg("rect", { class: "box", width: W, height: H, rx: 6 }),
+      name,
+      detail,
+      svg("rect", { class: "track", x: 8, y: 42, width: W - 16, height: 6, rx: 3 }),
+      svg("rect", { class: "fill", x: 8, y: 42, width: (W - 16) * frac, height: 6, rx: 3 }),
+    ));
+  }
+
+  document.getElementById("dag").replaceChildren(root);
+}
+
+// --- Failures view ---
+
+async function refreshFailures() {
+  const graph = pipeline.graph || (await getJSON("/graph"));
+  const list = document.getElementById("failure-list");
+  list.replaceChildren();
+
+  for (const step of graph.steps) {
+    const page = await getJSON(`/tasks?step=${encodeURIComponent(step.id)}&status=failed&limit=100`);
+    for (const task of page.items) {
+      const item = el("li", {}, `${step.name} v${step.version}`, el("small", {}, task.id));
+      item.onclick = () => {
+        list.querySelectorAll(".selected").forEach((n) => n.classList.remove("selected"));
+        item.classList.add("selected");
+        showFailure(step, task).catch(console.error);
+      };
+      list.append(item);
+    }
+  }
+  if (!list.children.length) list.append(el("li", {}, "No failed tasks"));
+}
+
+async function showFailure(step, task) {
+  document.getElementById("failure-title").textContent = `${step.name} v${step.version} — task ${task.id}`;
+  document.getElementById("failure-error").textContent = task.error || "";
+  const log = await getText(`/tasks/${encodeURIComponent(task.id)}/log`);
+  document.getElementById("failure-log").textContent = log || "(no output was captured)";
+}
+
+// --- Resources view ---
+
+const resources = { name: null, next: "" };
+
+async function refreshNames() {
+  const names = await getJSON("/names");
+  const list = document.getElementById("name-list");
+  list.replaceChildren();
+  for (const name of names) {
+    const item = el("li", {}, name);
+    item.onclick = () => {
+      list.querySelectorAll(".selected").forEach((n) => n.classList.remove("selected"));
+      item.classList.add("selected");
+      resources.name = name;
+      resources.next = "";
+      document.querySelector("#resource-table tbody").replaceChildren();
+      loadResources().catch(console.error);
+    };
+    list.append(item);
+  }
+}
+
+async function loadResources() {
+  const page = await getJSON(`/resources?name=${encodeURIComponent(resources.name)}&limit=100&after=${encodeURIComponent(resources.next)}`);
+  const tbody = document.querySelector("#resource-table tbody");
+  for (const r of page.items) {
+    const row = el("tr", { class: "clickable" },
+      el("td", {}, r.id),
+      el("td", {}, r.object_hash.slice(0, 12)),
+      el("td", {}, r.size ? formatBytes(r.size) : "–"),
+      el("td", {}, r.created_at),
+    );
+    row.onclick = () => showResource(r).catch(console.error);
+    tbody.append(row);
+  }
+  resources.next = page.next || "";
+  document.getElementById("resource-more").hidden = !page.next;
+}
+
+// Only the start of an object is fetched for the preview.
+const PREVIEW_BYTES = 8192;
+
+async function showResource(r) {
+  document.getElementById("resource-title").textContent = `${r.name} ${r.id}`;
+  const objectURL = `${API}/objects/${encodeURIComponent(r.object_hash)}`;
+  document.getElementById("resource-links").replaceChildren(
+    el("a", { href: objectURL, download: r.name }, "Download"),
+    ` · ${r.object_hash}`,
+  );
+
+  const resp = await fetch(objectURL, { headers: { Range: `bytes=0-${PREVIEW_BYTES - 1}` } });
+  const bytes = new Uint8Array(await resp.arrayBuffer());
+  const preview = document.getElementById("resource-preview");
+  preview.textContent = looksLikeText(bytes) ? new TextDecoder().decode(bytes) : hexDump(bytes);
+  if (r.size > PREVIEW_BYTES) preview.textContent += `\n… ${formatBytes(r.size - PREVIEW_BYTES)} more`;
+
+  const lineage = await getJSON(`/resources/${encodeURIComponent(r.id)}/lineage`);
+  document.getElementById("resource-lineage").replaceChildren(
+    el("h3", {}, "Lineage"),
+    el("div", { class: "lineage" }, el("ul", {}, lineageItem(lineage))),
+  );
+}
+
+function looksLikeText(bytes) {
+  let control = 0;
+  for (const b of bytes) {
+    if (b === 0) return false;
+    if (b < 9 || (b > 13 && b < 32)) control++;
+  }
+  return control <= bytes.length / 100;
+}
+
+function hexDump(bytes) {
+  const lines = [];
+  for (let i = 0; i < Math.min(bytes.length, 1024); i += 16) {
+    const row = Array.from(bytes.slice(i, i + 16));
+    const hex = row.map((b) => b.toString(16).padStart(2, "0")).join(" ");
+    const ascii = row.map((b) => (b >= 32 && b < 127 ? String.fromCharCode(b) : ".")).join("");
+    lines.push(`${i.toString(16).padStart(8, "0")}  ${hex.padEnd(47)}  ${ascii}`);
+  }
+  return lines.join("\n");
+}
+
+function lineageItem(node) {
+  if (!node.resource) return el("li", {}, `${node.id} (deleted)`);
+  const item = el("li", {}, `${node.resource.name} ${node.resource.id}`);
+  if (node.repeated) return item;
+  if (node.step) {
+    item.append(" ", el("span", { class: "step" }, `← ${node.step.name} v${node.step.version}`));
+  }
+  if (node.inputs && node.inputs.length) {
+    item.append(el("ul", {}, ...node.inputs.map(lineageItem)));
+  }
+  return item;
+}
+
+// --- History view ---
+
+async function refreshHistory() {
+  const graph = pipeline.graph || (await getJSON("/graph"));
+  const list = document.getElementById("step-list");
+  list.replaceChildren();
+  for (const step of graph.steps) {
+    const item = el("li", {}, step.name, el("small", {}, `${step.version} version(s)`));
+    item.onclick = () => {
+      list.querySelectorAll(".selected").forEach((n) => n.classList.remove("selected"));
+      item.classList.add("selected");
+      showHistory(step.name).catch(console.error);
+    };
+    list.append(item);
+  }
+}
+
+async function showHistory(name) {
+  const versions = (await getJSON(`/steps?name=${encodeURIComponent(name)}`)).sort((a, b) => a.version - b.version);
+  document.getElementById("history-title").textContent = `${name}: ${versions.length} version(s)`;
+
+  const from = el("select", {});
+  const to = el("select", {});
+  for (const v of versions) {
+    from.append(el("option", { value: v.id }, `v${v.version}`));
+    to.append(el("option", { value: v.id }, `v${v.version}`));
+  }
+  from.selectedIndex = Math.max(0, versions.length - 2);
+  to.selectedIndex = versions.length - 1;
+
+  const render = () => {
+    const a = versions.find((v) => v.id === from.value);
+    const b = versions.find((v) => v.id === to.value);
+    document.getElementById("script-diff").replaceChildren(...diffLines(a.script, b.script));
+  };
+  from.onchange = render;
+  to.onchange = render;
+  document.getElementById("version-picker").replaceChildren("Compare ", from, " with ", to);
+  render();
+}
+
+// diffLines returns a line diff of two scripts as spans, using the longest
+// common subsequence. Scripts are short enough for the quadratic table.
+function diffLines(a, b) {
+  const x = a.split("\n"), y = b.split("\n");
+  const lcs = Array.from({ length: x.length + 1 }, () => new Array(y.length + 1).fill(0));
+  for (let i = x.length - 1; i >= 0; i--) {
+    for (let j = y.length - 1; j >= 0; j--) {
+      lcs[i][j] = x[i] === y[j] ? lcs[i + 1][j + 1] + 1 : Math.max(lcs[i + 1][j], lcs[i][j + 1]);
+    }
+  }
+
+  const out = [];
+  let i = 0, j = 0;
+  while (i < x.length || j < y.length) {
+    if (i < x.length && j < y.length && x[i] === y[j]) {
+      out.push(el("span", {}, "  " + x[i] + "\n"));
+      i++;
+      j++;
+    } else if (j < y.length && (i === x.length || lcs[i][j + 1] >= lcs[i + 1][j])) {
+      out.push(el("span", { class: "add" }, "+ " + y[j] + "\n"));
+      j++;
+    } else {
+      out.push(el("span", { class: "del" }, "- " + x[i] + "\n"));
+      i++;
+    }
+  }
+  return out;
+}
+
+// --- Routing and live updates ---
+
+const views = {
+  pipeline: refreshPipeline,
+  failures: refreshFailures,
+  resources: refreshNames,
+  history: refreshHistory,
+};
+
+function route() {
+  const name = location.hash.slice(1) in views ? location.hash.slice(1) : "pipeline";
+  document.querySelectorAll(".view").forEach((v) => v.classList.toggle("active", v.id === name));
+  document.querySelectorAll("nav a").forEach((a) => a.classList.toggle("active", a.hash === "#" + name));
+  views[name]().catch(console.error);
+}
+
+function connectEvents() {
+  const live = document.getElementById("live");
+  const events = new EventSource(API + "/events");
+  events.onopen = () => {
+    live.textContent = "live";
+    live.classList.add("on");
+  };
+  events.onerror = () => {
+    live.textContent = "reconnecting";
+    live.classList.remove("on");
+  };
+  for (const kind of ["task.finished", "resource.created"]) {
+    events.addEventListener(kind, schedulePipelineRefresh);
+  }
+}
+
+document.getElementById("resource-more").onclick = () => loadResources().catch(console.error);
+window.addEventListener("hashchange", route);
+setInterval(() => refreshPipeline().catch(console.error), REFRESH_MS);
+connectEvents();
+route();
@@ -0,0 +1,495 @@
+package db
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"grit/objstore"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// A backup is a tar stream of
+//
+//	backup.json  the BackupManifest
+//	grit.toml    the repo configuration
+//	badger.bak   a Badger backup of every key written since the backup's Since
+//	objects/...  the files in objects/ of the objects among those keys
+//
+// in that order. Objects kept in other object stores are not copied.
+const (
+	backupManifestEntry = "backup.json"
+	backupBadgerEntry   = "badger.bak"
+	backupObjectsDir    = "objects/"
+)
+
+// loadPendingWrites bounds the writes Badger buffers while loading a backup.
+const loadPendingWrites = 256
+
+// BackupManifest describes a backup.
+type BackupManifest struct {
+	// Since is the Badger version the backup picks up after; 0 for a full
+	// backup.
+	Since uint64 `json:"since"`
+	// Next is the last version in the backup, the Since of an incremental
+	// backup that follows it.
+	Next    uint64    `json:"next"`
+	Schema  int       `json:"schema"`
+	Created time.Time `json:"created"`
+	// Objects and Bytes count the object files in the backup.
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+	// External counts the objects of the backup kept in an object store
+	// other than objects/, which are not copied.
+	External int64 `json:"external,omitempty"`
+}
+
+// Backup writes a backup of everything written to the database after the
+// Badger version since to w; since 0 backs up everything. Pass the Next of
+// one backup as the since of the next to take incremental backups.
+//
+// An incremental backup carries a deletion only while Badger still holds its
+// tombstone, which compaction eventually discards. Take a full backup after
+// large deletes, prunes or gc runs to be sure a restore does not bring back
+// what they removed.
+func (d Database) Backup(w io.Writer, since uint64) (BackupManifest, error) {
+	manifest := BackupManifest{Since: since, Created: time.Now().UTC()}
+	schema, err := d.SchemaVersion()
+	if err != nil {
+		return manifest, err
+	}
+	manifest.Schema = schema
+
+	// Badger's backup goes to a temporary file first, since a tar entry needs
+	// its size up front.
+	tmp, err := os.CreateTemp("", "grit-backup-*.bak")
+	if err != nil {
+		return manifest, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	version, err := d.badgerDB.Backup(tmp, since)
+	if err != nil {
+		return manifest, fmt.Errorf("failed to back up badger: %w", err)
+	}
+	manifest.Next = max(version, since)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return manifest, err
+	}
+
+	var hashes []string
+	manifest.External, err = d.fsObjects(since, func(hash string) error {
+		info, err := os.Stat(d.objectFilePath(hash))
+		if err != nil {
+			return fmt.Errorf("object %s: %w", hash, err)
+		}
+		hashes = append(hashes, hash)
+		manifest.Objects++
+		manifest.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	tw := tar.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeTarBytes(tw, backupManifestEntry, data, manifest.Created); err != nil {
+		return manifest, err
+	}
+	config, err := os.ReadFile(filepath.Join(d.repo_path, ConfigFile))
+	if err != nil {
+		return manifest, err
+	}
+	if err := writeTarBytes(tw, ConfigFile, config, manifest.Created); err != nil {
+		return manifest, err
+	}
+	if err := writeTarFile(tw, backupBadgerEntry, tmp); err != nil {
+		return manifest, err
+	}
+
+	root := filepath.Join(d.repo_path, "objects")
+	for _, hash := range hashes {
+		src := d.objectFilePath(hash)
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return manifest, err
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return manifest, err
+		}
+		err = writeTarFile(tw, backupObjectsDir+filepath.ToSlash(rel), f)
+		f.Close()
+		if err != nil {
+			return manifest, fmt.Errorf("object %s: %w", hash, err)
+		}
+	}
+	return manifest, tw.Close()
+}
+
+// fsObjects calls fn with the hash of every object in objects/ whose record
+// was written after the Badger version since, and returns how many
+// such objects are in other stores.
+func (d Database) fsObjects(since uint64, fn func(hash string) error) (int64, error) {
+	var external int64
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := []byte(prefixObject)
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		opts.PrefetchValues = false
+		opts.SinceTs = since
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			// Inline objects are larger than a store name; skip them without
+			// reading their content.
+			if item.Version() <= since || item.ValueSize() > 8 {
+				continue
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			store, err := d.storeFor(val)
+			if err != nil {
+				return err
+			}
+			switch {
+			case store == nil:
+			case store.Name() == "fs":
+				if err := fn(hex.EncodeToString(item.Key()[len(prefix):])); err != nil {
+					return err
+				}
+			default:
+				external++
+			}
+		}
+		return nil
+	})
+	return external, err
+}
+
+// RestoreResult is what Restore wrote.
+type RestoreResult struct {
+	Backups int    `json:"backups"`
+	Next    uint64 `json:"next"`
+	Schema  int    `json:"schema"`
+	Objects int64  `json:"objects"`
+	Bytes   int64  `json:"bytes"`
+	// Removed counts object files from earlier backups whose objects a later
+	// one deleted.
+	Removed  int64 `json:"removed"`
+	External int64 `json:"external,omitempty"`
+}
+
+// Restore creates a repo at repoPath, which must be empty or not exist, from
+// a full backup followed by any incremental backups taken after it, in the
+// order they were taken. Open the result with NewDatabase, which applies any
+// migrations the backups predate.
+func Restore(repoPath string, backups ...io.Reader) (RestoreResult, error) {
+	var result RestoreResult
+	if len(backups) == 0 {
+		return result, errors.New("no backups to restore")
+	}
+	if err := checkEmptyDir(repoPath); err != nil {
+		return result, err
+	}
+	if err := os.MkdirAll(filepath.Join(repoPath, "objects"), 0755); err != nil {
+		return result, err
+	}
+
+	var bdb *badger.DB
+	defer func() {
+		if bdb != nil {
+			bdb.Close()
+		}
+	}()
+	for i, r := range backups {
+		follows := func(manifest BackupManifest) error {
+			switch {
+			case i == 0 && manifest.Since != 0:
+				return fmt.Errorf("it is incremental (since %d); restore starts from a full backup", manifest.Since)
+			case i > 0 && manifest.Since > result.Next:
+				return fmt.Errorf("it starts at %d, after backup %d ends at %d; a backup in between is missing",
+					manifest.Since, i, result.Next)
+			}
+			return nil
+		}
+		manifest, err := restoreBackup(repoPath, tar.NewReader(r), follows, &bdb, &result)
+		if err != nil {
+			return result, fmt.Errorf("backup %d: %w", i+1, err)
+		}
+		result.Backups++
+		result.Next = max(result.Next, manifest.Next)
+		result.Schema = manifest.Schema
+		result.External += manifest.External
+	}
+	if bdb == nil {
+		return result, errors.New("backup has no database")
+	}
+
+	removed, err := removeDeletedObjectFiles(repoPath, bdb)
+	result.Removed = removed
+	if err != nil {
+		return result, err
+	}
+	err = bdb.Close()
+	bdb = nil
+	return result, err
+}
+
+// restoreBackup extracts one backup into repoPath once follows accepts its
+// manifest, opening the database in *bdb when it reaches the first Badger
+// backup.
+func restoreBackup(repoPath string, tr *tar.Reader, follows func(BackupManifest) error, bdb **badger.DB, result *RestoreResult) (BackupManifest, error) {
+	var manifest BackupManifest
+	hdr, err := tr.Next()
+	if err != nil {
+		return manifest, fmt.Errorf("not a grit backup: %w", err)
+	}
+	if hdr.Name != backupManifestEntry {
+		return manifest, fmt.Errorf("not a grit backup: starts with %s", hdr.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return manifest, fmt.Errorf("invalid %s: %w", backupManifestEntry, err)
+	}
+	if manifest.Schema > SchemaVersion {
+		return manifest, fmt.Errorf("the backup has schema version %d but this grit only understands up to %d; upgrade grit", manifest.Schema, SchemaVersion)
+	}
+	if err := follows(manifest); err != nil {
+		return manifest, err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return manifest, nil
+		}
+		if err != nil {
+			return manifest, err
+		}
+		switch name := path.Clean(hdr.Name); {
+		case name == ConfigFile:
+			if err := writeFileFrom(filepath.Join(repoPath, ConfigFile), tr, 0644); err != nil {
+				return manifest, err
+			}
+		case name == backupBadgerEntry:
+			if *bdb == nil {
+				config, err := loadConfig(repoPath, nil)
+				if err != nil {
+					return manifest, err
+				}
+				if *bdb, err = badger.Open(config.badgerOptions(filepath.Join(repoPath, "db"))); err != nil {
+					return manifest, fmt.Errorf("failed to open BadgerDB: %w", err)
+				}
+			}
+			if err := (*bdb).Load(tr, loadPendingWrites); err != nil {
+				return manifest, fmt.Errorf("failed to load badger backup: %w", err)
+			}
+		case strings.HasPrefix(name, backupObjectsDir) && !strings.Contains(name, ".."):
+			dst := filepath.Join(repoPath, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return manifest, err
+			}
+			if err := writeFileFrom(dst, tr, 0444); err != nil {
+				return manifest, err
+			}
+			result.Objects++
+			result.Bytes += hdr.Size
+		default:
+			return manifest, fmt.Errorf("unexpected entry %s", hdr.Name)
+		}
+	}
+}
+
+// removeDeletedObjectFiles removes the files in objects/ that no object
+// record points at, which an object deleted between two backups leaves.
+func removeDeletedObjectFiles(repoPath string, bdb *badger.DB) (int64, error) {
+	config, err := loadConfig(repoPath, nil)
+	if err != nil {
+		return 0, err
+	}
+	layout, err := parseObjectLayout(config.Storage.ObjectLayout)
+	if err != nil {
+		return 0, err
+	}
+	store := objstore.NewFS(filepath.Join(repoPath, "objects"), layout)
+	var removed int64
+	err = store.List(func(info objstore.Info) error {
+		hashBytes, err := hex.DecodeString(info.Hash)
+		if info.Hash == "" || err != nil {
+			return nil
+		}
+		var val []byte
+		err = bdb.View(func(txn *badger.Txn) error {
+			var err error
+			val, err = getVal(txn, objectKey(hashBytes))
+			return err
+		})
+		if err != nil || string(val) == store.Name() {
+			return err
+		}
+		removed++
+		return store.Delete(info.Hash)
+	})
+	return removed, err
+}
+
+// SnapshotResult is what Snapshot wrote.
+type SnapshotResult struct {
+	// Linked counts the object files hard-linked into the snapshot, and
+	// Copied those that had to be copied, such as across filesystems.
+	Linked      int64 `json:"linked"`
+	Copied      int64 `json:"copied"`
+	BytesCopied int64 `json:"bytes_copied"`
+	// External counts the objects kept in an object store other than
+	// objects/, which the snapshot shares with the database.
+	External int64 `json:"external,omitempty"`
+}
+
+// Snapshot copies the database into a new repo at dir, which must be empty
+// or not exist. Object files never change once written, so they are hard
+// links to the database's own where the filesystem allows, and the snapshot
+// costs little more than the size of the Badger data.
+func (d Database) Snapshot(dir string) (SnapshotResult, error) {
+	var result SnapshotResult
+	if err := checkEmptyDir(dir); err != nil {
+		return result, err
+	}
+	root := filepath.Join(d.repo_path, "objects")
+	dstRoot := filepath.Join(dir, "objects")
+	if err := os.MkdirAll(dstRoot, 0755); err != nil {
+		return result, err
+	}
+	config, err := os.Open(filepath.Join(d.repo_path, ConfigFile))
+	if err != nil {
+		return result, err
+	}
+	err = writeFileFrom(filepath.Join(dir, ConfigFile), config, 0644)
+	config.Close()
+	if err != nil {
+		return result, err
+	}
+
+	bdb, err := badger.Open(d.config.badgerOptions(filepath.Join(dir, "db")))
+	if err != nil {
+		return result, fmt.Errorf("failed to open BadgerDB: %w", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := d.badgerDB.Backup(pw, 0)
+		pw.CloseWithError(err)
+	}()
+	err = bdb.Load(pr, loadPendingWrites)
+	pr.CloseWithError(errors.New("snapshot stopped"))
+	if cerr := bdb.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to copy badger: %w", err)
+	}
+
+	result.External, err = d.fsObjects(0, func(hash string) error {
+		src := d.objectFilePath(hash)
+		rel, err := filepath.Rel(root, src)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(dstRoot, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Link(src, dst); err == nil {
+			result.Linked++
+			return nil
+		}
+		f, err := os.Open(src)
+		if err != nil {
+			return fmt.Errorf("object %s: %w", hash, err)
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := writeFileFrom(dst, f, 0444); err != nil {
+			return err
+		}
+		result.Copied++
+		result.BytesCopied += info.Size()
+		return nil
+	})
+	return result, err
+}
+
+// checkEmptyDir returns an error unless dir is empty or does not exist.
+func checkEmptyDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		return fmt.Errorf("%s is not empty", dir)
+	}
+	return nil
+}
+
+// writeFileFrom writes r to path through a temporary file, so an
+// interrupted write never leaves a partial file under the final name.
+func writeFileFrom(path string, r io.Reader, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func writeTarBytes(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeTarFile(tw *tar.Writer, name string, f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: name, Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	inline, _, err := database.CreateResourceFromReader("inline", bytes.NewReader([]byte("small")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := randomBytes(t, 2*fsObjectThreshold)
+	doomed, doomedHash, err := database.CreateResourceFromReader("doomed", bytes.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var full bytes.Buffer
+	fullManifest, err := database.Backup(&full, 0)
+	if err != nil {
+		t.Fatalf("Backup(full) error = %v", err)
+	}
+	if fullManifest.Objects != 1 {
+		t.Errorf("Backup(full) = %+v, want 1 object file", fullManifest)
+	}
+
+	if _, err := database.DeleteResourceHard(doomed); err != nil {
+		t.Fatal(err)
+	}
+	later := randomBytes(t, 2*fsObjectThreshold)
+	added, _, err := database.CreateResourceFromReader("added", bytes.NewReader(later))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var incremental bytes.Buffer
+	incManifest, err := database.Backup(&incremental, fullManifest.Next)
+	if err != nil {
+		t.Fatalf("Backup(incremental) error = %v", err)
+	}
+	if incManifest.Objects != 1 || incManifest.Since != fullManifest.Next {
+		t.Errorf("Backup(incremental) = %+v, want only the added object", incManifest)
+	}
+
+	if _, err := Restore(t.TempDir(), bytes.NewReader(incremental.Bytes())); err == nil {
+		t.Errorf("Restore(incremental only) succeeded, want an error")
+	}
+	if _, err := Restore(dir, bytes.NewReader(full.Bytes())); err == nil {
+		t.Errorf("Restore(non-empty dir) succeeded, want an error")
+	}
+
+	restoredDir := filepath.Join(t.TempDir(), "restored")
+	result, err := Restore(restoredDir, bytes.NewReader(full.Bytes()), bytes.NewReader(incremental.Bytes()))
+	if err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	if result.Backups != 2 || result.Objects != 2 || result.Removed != 1 {
+		t.Errorf("Restore() = %+v, want 2 backups, 2 object files, 1 removed", result)
+	}
+	restored, err := NewDatabase(restoredDir)
+	if err != nil {
+		t.Fatalf("NewDatabase(restored) error = %v", err)
+	}
+	defer restored.Close()
+	report, err := restored.Fsck(false)
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("Fsck(restored) = %+v, %v; want no issues", report.Issues, err)
+	}
+	for id, want := range map[string][]byte{inline: []byte("small"), added: later} {
+		if got := readResource(t, restored, id); !bytes.Equal(got, want) {
+			t.Errorf("restored resource %s has %d bytes, want %d", id, len(got), len(want))
+		}
+	}
+	if r, _ := restored.GetResource(doomed); r != nil || restored.ObjectExists(doomedHash) {
+		t.Errorf("resource deleted before the incremental backup was restored")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+	data := randomBytes(t, 2*fsObjectThreshold)
+	id, hash, err := database.CreateResourceFromReader("big", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	result, err := database.Snapshot(dir)
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if result.Linked != 1 {
+		t.Errorf("Snapshot() = %+v, want the object file linked", result)
+	}
+	snapshot, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase(snapshot) error = %v", err)
+	}
+	defer snapshot.Close()
+	original, _ := os.Stat(database.objectFilePath(hash))
+	linked, err := os.Stat(snapshot.objectFilePath(hash))
+	if err != nil || !os.SameFile(original, linked) {
+		t.Errorf("snapshot object file is not a hard link to the original: %v", err)
+	}
+	if got := readResource(t, snapshot, id); !bytes.Equal(got, data) {
+		t.Errorf("snapshot resource has %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func readResource(t *testing.T, d Database, id string) []byte {
+	t.Helper()
+	r, err := d.GetResource(id)
+	if err != nil || r == nil {
+		t.Fatalf("GetResource(%s) = %v, %v", id, r, err)
+	}
+	f, err := d.OpenObject(r.ObjectHash)
+	if err != nil {
+		t.Fatalf("OpenObject(%s) error = %v", r.ObjectHash, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
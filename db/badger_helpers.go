@@ -135,6 +135,17 @@ func prefixCount(txn *badger.Txn, prefix []byte) (int64, error) {
 	return count, nil
 }
 
+// prefixExists reports whether any key has the given prefix.
+func prefixExists(txn *badger.Txn, prefix []byte) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Seek(prefix)
+	return it.ValidForPrefix(prefix)
+}
+
 // keyExists checks if a key exists in the transaction.
 func keyExists(txn *badger.Txn, key []byte) bool {
 	_, err := txn.Get(key)
@@ -178,6 +189,16 @@ func putEntity(txn *badger.Txn, key []byte, v interface{}) error {
 	return txn.Set(key, data)
 }
 
+// ulidTime recovers the creation time embedded in a resource or task ULID.
+// Returns the zero time if id is not a valid ULID.
+func ulidTime(id string) time.Time {
+	u, err := ulid.Parse(id)
+	if err != nil {
+		return time.Time{}
+	}
+	return ulid.Time(u.Time())
+}
+
 // nowTimestamp returns the current time as an RFC3339 string.
 func nowTimestamp() string {
 	return time.Now().UTC().Format(time.RFC3339)
@@ -1,6 +1,9 @@
 package db
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+)
 
 // Primary entity key prefixes
 const (
@@ -9,6 +12,17 @@ const (
 	prefixResource = "r:"
 	prefixObject   = "o:"
 	prefixMeta     = "m:"
+	// prefixTaskLog holds the captured stdout/stderr of a task, keyed by
+	// task ULID.
+	prefixTaskLog = "lg:"
+	// prefixRun holds run records, keyed by run ULID.
+	prefixRun = "rn:"
+	// prefixObjectTime holds when each object was last written, keyed like
+	// prefixObject. gc leaves objects younger than its grace period alone.
+	prefixObjectTime = "ot:"
+	// metaObjectStorePrefix holds the object stores in use, keyed by store
+	// name.
+	metaObjectStorePrefix = prefixMeta + "objectstore:"
 )
 
 // Index key prefixes.
@@ -32,16 +46,38 @@ const (
 	// Key: ix:tsp:{step_ulid}\x00{task_ulid}
 	idxTaskByStepProc = "ix:tsp:"
 
+	// idxTaskByStepSkipped lists processed tasks whose script asked to be
+	// skipped. A subset of idxTaskByStepProc, kept for progress counts.
+	// Key: ix:tsk:{step_ulid}\x00{task_ulid}
+	idxTaskByStepSkipped = "ix:tsk:"
+
+	// idxTaskByStepFailed lists processed tasks that finished with an error.
+	// A subset of idxTaskByStepProc, kept for progress counts and failure
+	// listings.
+	// Key: ix:tsf:{step_ulid}\x00{task_ulid}
+	idxTaskByStepFailed = "ix:tsf:"
+
 	// idxTaskByStepAll covers every task for a step regardless of status.
 	// Used for counts and bulk operations (e.g. MarkStepUndone).
 	// Key: ix:tsa:{step_ulid}\x00{task_ulid}
 	idxTaskByStepAll = "ix:tsa:"
 
+	// idxTaskBySeedParam lists the runs of a seed step per seed parameter, in
+	// creation order. Seeds without parameters use the empty parameter.
+	// Key: ix:tss:{step_ulid}\x00{param}\x00{task_ulid}
+	idxTaskBySeedParam = "ix:tss:"
+
 	// idxTaskUnique enforces the constraint that a step processes each input
-	// resource at most once.  Value is the task ULID.
+	// resource at most once.  Value is the task ULID, or empty when the
+	// resource was filtered out by the step's `when` condition.
 	// Key: ix:tu:{step_ulid}\x00{resource_ulid}  →  task_ulid
 	idxTaskUnique = "ix:tu:"
 
+	// idxTaskInput lists the input set of a reduce task, which can be far
+	// too large to store in the task itself.
+	// Key: ix:ti:{task_ulid}\x00{resource_ulid}
+	idxTaskInput = "ix:ti:"
+
 	// idxResourceByName lists all resources with a given name ordered by ULID
 	// (creation time).  This is the index ScheduleTasksForStep pages through.
 	// Key: ix:rn:{name}\x00{resource_ulid}
@@ -52,14 +88,37 @@ const (
 	// Key: ix:rh:{name}\x00{object_hash}  →  resource_ulid
 	idxResourceHash = "ix:rh:"
 
+	// idxNameProducer records which steps have produced resources of a given
+	// name. Keyed by step name rather than ULID so every version of a step
+	// counts as the same producer. Used to decide when a name is complete.
+	// Key: ix:np:{resource_name}\x00{step_name}
+	idxNameProducer = "ix:np:"
+
+	// idxResourceByRun lists the resources each run created.
+	// Key: ix:rr:{run_ulid}\x00{resource_ulid}
+	idxResourceByRun = "ix:rr:"
+
+	// idxResourceByObject lists the resources that refer to each object. An
+	// object with no entries is garbage.
+	// Key: ix:ro:{object_hash}\x00{resource_ulid}
+	idxResourceByObject = "ix:ro:"
+
+	// idxObjectByChunk lists the chunked objects that refer to each chunk. A
+	// chunk is garbage once it has no entries here and none in
+	// idxResourceByObject.
+	// Key: ix:oc:{chunk_hash}\x00{object_hash}
+	idxObjectByChunk = "ix:oc:"
 )
 
 // --- Primary key builders ---
 
-func stepKey(id string) []byte     { return []byte(prefixStep + id) }
-func taskKey(id string) []byte     { return []byte(prefixTask + id) }
-func resourceKey(id string) []byte { return []byte(prefixResource + id) }
-func objectKey(hash []byte) []byte { return append([]byte(prefixObject), hash...) }
+func stepKey(id string) []byte         { return []byte(prefixStep + id) }
+func taskKey(id string) []byte         { return []byte(prefixTask + id) }
+func resourceKey(id string) []byte     { return []byte(prefixResource + id) }
+func objectKey(hash []byte) []byte     { return append([]byte(prefixObject), hash...) }
+func objectTimeKey(hash []byte) []byte { return append([]byte(prefixObjectTime), hash...) }
+func taskLogKey(id string) []byte      { return []byte(prefixTaskLog + id) }
+func runKey(id string) []byte          { return []byte(prefixRun + id) }
 
 // --- Index key builders ---
 
@@ -75,6 +134,18 @@ func idxTaskByStepProcKey(stepID, taskID string) []byte {
 	return []byte(idxTaskByStepProc + stepID + "\x00" + taskID)
 }
 
+func idxTaskBySeedParamKey(stepID, param, taskID string) []byte {
+	return []byte(idxTaskBySeedParam + stepID + "\x00" + param + "\x00" + taskID)
+}
+
+func idxTaskByStepSkippedKey(stepID, taskID string) []byte {
+	return []byte(idxTaskByStepSkipped + stepID + "\x00" + taskID)
+}
+
+func idxTaskByStepFailedKey(stepID, taskID string) []byte {
+	return []byte(idxTaskByStepFailed + stepID + "\x00" + taskID)
+}
+
 func idxTaskByStepAllKey(stepID, taskID string) []byte {
 	return []byte(idxTaskByStepAll + stepID + "\x00" + taskID)
 }
@@ -83,6 +154,10 @@ func idxTaskUniqueKey(stepID, resourceID string) []byte {
 	return []byte(idxTaskUnique + stepID + "\x00" + resourceID)
 }
 
+func idxTaskInputKey(taskID, resourceID string) []byte {
+	return []byte(idxTaskInput + taskID + "\x00" + resourceID)
+}
+
 func idxResourceByNameKey(name, id string) []byte {
 	return []byte(idxResourceByName + name + "\x00" + id)
 }
@@ -91,6 +166,22 @@ func idxResourceHashKey(name, objectHash string) []byte {
 	return []byte(idxResourceHash + name + "\x00" + objectHash)
 }
 
+func idxResourceByRunKey(runID, id string) []byte {
+	return []byte(idxResourceByRun + runID + "\x00" + id)
+}
+
+func idxResourceByObjectKey(objectHash, id string) []byte {
+	return []byte(idxResourceByObject + objectHash + "\x00" + id)
+}
+
+func idxObjectByChunkKey(chunkHash, objectHash string) []byte {
+	return []byte(idxObjectByChunk + chunkHash + "\x00" + objectHash)
+}
+
+func idxNameProducerKey(name, stepName string) []byte {
+	return []byte(idxNameProducer + name + "\x00" + stepName)
+}
+
 // --- Prefix builders for scans ---
 
 func idxStepByNamePrefix(name string) []byte {
@@ -101,14 +192,50 @@ func idxTaskByStepUnprocPrefix(stepID string) []byte {
 	return []byte(idxTaskByStepUnproc + stepID + "\x00")
 }
 
+func idxTaskBySeedParamPrefix(stepID, param string) []byte {
+	return []byte(idxTaskBySeedParam + stepID + "\x00" + param + "\x00")
+}
+
+func idxTaskByStepSkippedPrefix(stepID string) []byte {
+	return []byte(idxTaskByStepSkipped + stepID + "\x00")
+}
+
+func idxTaskByStepFailedPrefix(stepID string) []byte {
+	return []byte(idxTaskByStepFailed + stepID + "\x00")
+}
+
 func idxTaskByStepAllPrefix(stepID string) []byte {
 	return []byte(idxTaskByStepAll + stepID + "\x00")
 }
 
+func idxTaskInputPrefix(taskID string) []byte {
+	return []byte(idxTaskInput + taskID + "\x00")
+}
+
 func idxResourceByNamePrefix(name string) []byte {
 	return []byte(idxResourceByName + name + "\x00")
 }
 
+func idxResourceHashPrefix(name string) []byte {
+	return []byte(idxResourceHash + name + "\x00")
+}
+
+func idxResourceByRunPrefix(runID string) []byte {
+	return []byte(idxResourceByRun + runID + "\x00")
+}
+
+func idxResourceByObjectPrefix(objectHash string) []byte {
+	return []byte(idxResourceByObject + objectHash + "\x00")
+}
+
+func idxObjectByChunkPrefix(chunkHash string) []byte {
+	return []byte(idxObjectByChunk + chunkHash + "\x00")
+}
+
+func idxNameProducerPrefix(name string) []byte {
+	return []byte(idxNameProducer + name + "\x00")
+}
+
 // --- Meta key builders ---
 
 func metaCsvHashKey(path string) []byte {
@@ -118,3 +245,42 @@ func metaCsvHashKey(path string) []byte {
 func metaCsvOffsetKey(path string) []byte {
 	return []byte(prefixMeta + "csvoffset:" + path)
 }
+
+// metaScheduleWatermarkKey holds the last input resource ID the scheduler has
+// swept past for a step. Every resource at or before it has been consumed.
+func metaScheduleWatermarkKey(stepID string) []byte {
+	return []byte(prefixMeta + "watermark:" + stepID)
+}
+
+func metaReduceFingerprintKey(stepID string) []byte {
+	return []byte(prefixMeta + "reducefp:" + stepID)
+}
+
+// metaObjectStoreKey holds the URI of the store of a kind that objects
+// have been put in; metaObjectStoreCurrentKey names the kind new objects go
+// to. See UseObjectStore.
+func metaObjectStoreKey(name string) []byte {
+	return []byte(metaObjectStorePrefix + name)
+}
+
+func metaObjectStoreCurrentKey() []byte {
+	return []byte(prefixMeta + "objectstore")
+}
+
+// metaObjectLayoutKey holds the storage.object_layout the repo was created
+// with.
+func metaObjectLayoutKey() []byte {
+	return []byte(prefixMeta + "config:object_layout")
+}
+
+// metaSchemaVersionKey holds the schema version, in decimal, the database
+// has been migrated to.
+func metaSchemaVersionKey() []byte {
+	return []byte(prefixMeta + "schema")
+}
+
+// metaMigrationCursorKey holds the key an interrupted migration resumes
+// from.
+func metaMigrationCursorKey(version int) []byte {
+	return []byte(prefixMeta + "migrate:" + strconv.Itoa(version))
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestBatchedScheduling(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	var rows []string
+	addRows := func(n int) {
+		for range n {
+			id, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte(fmt.Sprint(len(rows)))))
+			if err != nil {
+				t.Fatalf("CreateResourceFromReader() error = %v", err)
+			}
+			rows = append(rows, id)
+		}
+		slices.Sort(rows)
+	}
+	stepID, err := database.CreateStep(Step{Name: "score", Script: "true", Input: "row", BatchSize: 2, BatchTimeout: time.Hour})
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	schedule := func(flush bool) int64 {
+		t.Helper()
+		var n int64
+		var err error
+		if flush {
+			n, err = database.FlushBatchesForStep(stepID)
+		} else {
+			n, err = database.ScheduleTasksForStep(stepID)
+		}
+		if err != nil {
+			t.Fatalf("scheduling error = %v", err)
+		}
+		return n
+	}
+	watermark := func() string {
+		t.Helper()
+		var val []byte
+		err := database.badgerDB.View(func(txn *badger.Txn) error {
+			var err error
+			val, err = getVal(txn, metaScheduleWatermarkKey(stepID))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(val)
+	}
+
+	// Two full batches are written and the fifth row is held, with the
+	// watermark stopping just ahead of it.
+	addRows(5)
+	if n := schedule(false); n != 2 {
+		t.Fatalf("first pass scheduled %d tasks, want 2", n)
+	}
+	if got := watermark(); got != rows[3] {
+		t.Fatalf("watermark with a held batch = %s, want %s", got, rows[3])
+	}
+
+	// The next pass picks up from the held row and batches it with a new one.
+	addRows(2)
+	if n := schedule(false); n != 1 {
+		t.Fatalf("second pass scheduled %d tasks, want 1", n)
+	}
+	if got := watermark(); got != rows[5] {
+		t.Fatalf("watermark after second pass = %s, want %s", got, rows[5])
+	}
+
+	// Flushing writes the held remainder.
+	if n := schedule(true); n != 1 {
+		t.Fatalf("flush scheduled %d tasks, want 1", n)
+	}
+	if got := watermark(); got != rows[6] {
+		t.Fatalf("watermark after flush = %s, want %s", got, rows[6])
+	}
+	if n := schedule(false); n != 0 {
+		t.Fatalf("pass after flush scheduled %d tasks, want 0", n)
+	}
+
+	var members []string
+	for task := range database.GetTasksForStep(stepID) {
+		if len(task.InputResourceIDs) > 2 {
+			t.Errorf("task %s has %d inputs, want at most 2", task.ID, len(task.InputResourceIDs))
+		}
+		members = append(members, task.InputResourceIDs...)
+	}
+	slices.Sort(members)
+	if !slices.Equal(members, rows) {
+		t.Errorf("batched tasks cover %v, want every row once: %v", members, rows)
+	}
+}
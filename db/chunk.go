@@ -0,0 +1,313 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"grit/chunker"
+	"grit/objstore"
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// chunkMinObjectSize is the smallest output a chunking step stores as
+// chunks. Smaller outputs are stored whole.
+const chunkMinObjectSize = 1 << 20
+
+// chunkRef is one entry of a chunk list: the hash and length of a chunk.
+type chunkRef struct {
+	hash [sha256.Size]byte
+	size int64
+}
+
+// encodeChunkList returns a chunked object as stored: an object header with
+// codecChunked and the total size, then each chunk's hash and uvarint length.
+func encodeChunkList(refs []chunkRef, total int64) []byte {
+	out := make([]byte, 1, maxObjectHeaderLen+len(refs)*(sha256.Size+3))
+	out[0] = codecChunked
+	out = binary.AppendUvarint(out, uint64(total))
+	for _, ref := range refs {
+		out = append(out, ref.hash[:]...)
+		out = binary.AppendUvarint(out, uint64(ref.size))
+	}
+	return out
+}
+
+func parseChunkList(body []byte) ([]chunkRef, error) {
+	var refs []chunkRef
+	for len(body) > 0 {
+		if len(body) < sha256.Size {
+			return nil, errors.New("chunk list is truncated")
+		}
+		var ref chunkRef
+		copy(ref.hash[:], body)
+		size, n := binary.Uvarint(body[sha256.Size:])
+		if n <= 0 {
+			return nil, errors.New("chunk list is corrupt")
+		}
+		ref.size = int64(size)
+		refs = append(refs, ref)
+		body = body[sha256.Size+n:]
+	}
+	return refs, nil
+}
+
+// storeChunkedFile stores the file at path as content-defined chunks, each a
+// separate object, and a chunk list under the hash of the whole file. Chunks
+// already stored are not written again. It returns the file's hash and size
+// and the backend the chunk list went to.
+func (d Database) storeChunkedFile(path, compress string) (hash, backend string, size int64, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer f.Close()
+
+	whole := sha256.New()
+	c := chunker.New(io.TeeReader(f, whole))
+	var refs []chunkRef
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", "", 0, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		ref := chunkRef{hash: sha256.Sum256(chunk), size: int64(len(chunk))}
+		chunkHash := hex.EncodeToString(ref.hash[:])
+		if !d.ObjectExists(chunkHash) {
+			if _, err := d.storeObject(chunkHash, chunk, compress); err != nil {
+				return "", "", 0, fmt.Errorf("failed to store chunk: %w", err)
+			}
+		}
+		refs = append(refs, ref)
+		size += ref.size
+	}
+	hash = hex.EncodeToString(whole.Sum(nil))
+
+	// Reference the chunks before the list exists, so gc never sees them
+	// unreferenced.
+	wb := d.badgerDB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, ref := range refs {
+		if err := wb.Set(idxObjectByChunkKey(hex.EncodeToString(ref.hash[:]), hash), nil); err != nil {
+			return "", "", 0, err
+		}
+	}
+	if err := wb.Flush(); err != nil {
+		return "", "", 0, err
+	}
+
+	list := encodeChunkList(refs, size)
+	backend = types.StorageBackendInline
+	if len(list) >= int(d.config.Storage.InlineMax) {
+		backend, err = d.storeObjectExternal(hash, list)
+	} else {
+		err = d.storeObjectBadger(hash, list)
+	}
+	if err != nil {
+		return "", "", 0, err
+	}
+	objectBytesStored.Add(float64(len(list)), backend)
+	return hash, backend, size, nil
+}
+
+// chunkRefs returns the chunks of a chunked object given its Badger value and
+// user meta, or nil if the object is not chunked.
+func (d Database) chunkRefs(hash string, val []byte, meta byte) ([]chunkRef, error) {
+	if meta&objectMetaEncoded == 0 {
+		return nil, nil
+	}
+	store, err := d.storeFor(val)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		f, err := store.Open(hash)
+		if err != nil {
+			return nil, err
+		}
+		codec := make([]byte, 1)
+		if _, err := io.ReadFull(f, codec); err != nil || codec[0] != codecChunked {
+			f.Close()
+			return nil, err
+		}
+		if val, err = readAllFrom(f); err != nil {
+			return nil, err
+		}
+	}
+	codec, _, body, err := parseObjectHeader(val)
+	if err != nil || codec != codecChunked {
+		return nil, err
+	}
+	return parseChunkList(body)
+}
+
+// chunkFreed counts what releaseChunks removed.
+type chunkFreed struct {
+	objects, files, bytes int64
+}
+
+// releaseChunks drops objectHash's references to its chunks and deletes the
+// chunks nothing else refers to. With dryRun it only counts them.
+func (d Database) releaseChunks(objectHash string, refs []chunkRef, dryRun bool) (chunkFreed, error) {
+	var freed chunkFreed
+	seen := make(map[[sha256.Size]byte]bool)
+	type orphan struct {
+		store objstore.Store
+		hash  string
+	}
+	var orphans []orphan
+	for i := 0; i < len(refs); i += writeBatchSize {
+		chunk := refs[i:min(i+writeBatchSize, len(refs))]
+		update := d.badgerDB.Update
+		if dryRun {
+			update = d.badgerDB.View
+		}
+		err := update(func(txn *badger.Txn) error {
+			for _, ref := range chunk {
+				if seen[ref.hash] {
+					continue
+				}
+				seen[ref.hash] = true
+				chunkHash := hex.EncodeToString(ref.hash[:])
+				if !dryRun {
+					if err := txn.Delete(idxObjectByChunkKey(chunkHash, objectHash)); err != nil {
+						return err
+					}
+				}
+
+				refCount, err := prefixCount(txn, idxObjectByChunkPrefix(chunkHash))
+				if err != nil {
+					return err
+				}
+				if dryRun {
+					refCount--
+				}
+				if refCount > 0 || prefixExists(txn, idxResourceByObjectPrefix(chunkHash)) {
+					continue
+				}
+
+				val, err := getVal(txn, objectKey(ref.hash[:]))
+				if err != nil || val == nil {
+					return err
+				}
+				store, err := d.storeFor(val)
+				if err != nil {
+					return err
+				}
+				freed.objects++
+				if store != nil {
+					freed.files++
+					if info, err := store.Stat(chunkHash); err == nil {
+						freed.bytes += info.Size
+					}
+					orphans = append(orphans, orphan{store, chunkHash})
+				} else {
+					freed.bytes += int64(len(val))
+				}
+				if dryRun {
+					continue
+				}
+				if err := txn.Delete(objectKey(ref.hash[:])); err != nil {
+					return err
+				}
+				if err := txn.Delete(objectTimeKey(ref.hash[:])); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return freed, err
+		}
+	}
+	if dryRun {
+		return freed, nil
+	}
+	for _, o := range orphans {
+		if err := o.store.Delete(o.hash); err != nil {
+			return freed, err
+		}
+	}
+	return freed, nil
+}
+
+// chunkReader reads a chunked object, loading one chunk at a time.
+type chunkReader struct {
+	d      Database
+	refs   []chunkRef
+	starts []int64 // offset of each chunk in the object
+	size   int64
+
+	pos   int64
+	index int    // chunk loaded in data, or -1
+	data  []byte // content of chunk index
+}
+
+func (d Database) newChunkReader(refs []chunkRef, size int64) *chunkReader {
+	starts := make([]int64, len(refs))
+	var offset int64
+	for i, ref := range refs {
+		starts[i] = offset
+		offset += ref.size
+	}
+	return &chunkReader{d: d, refs: refs, starts: starts, size: size, index: -1}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	// The chunk holding pos is the last one starting at or before it.
+	i := sort.Search(len(r.starts), func(i int) bool { return r.starts[i] > r.pos }) - 1
+	if i != r.index {
+		ref := r.refs[i]
+		data, err := r.d.GetObject(hex.EncodeToString(ref.hash[:]))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read chunk %x: %w", ref.hash, err)
+		}
+		if int64(len(data)) != ref.size {
+			return 0, fmt.Errorf("chunk %x is %d bytes, expected %d", ref.hash, len(data), ref.size)
+		}
+		r.index, r.data = i, data
+	}
+	offset := r.pos - r.starts[i]
+	if offset >= int64(len(r.data)) {
+		// The chunks add up to less than the header says.
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, r.data[offset:])
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *chunkReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *chunkReader) Close() error {
+	r.data = nil
+	return nil
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestChunkedObject(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	store := func(data []byte) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "out")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, _, size, err := database.storeChunkedFile(path, types.CompressAuto)
+		if err != nil {
+			t.Fatalf("storeChunkedFile() error = %v", err)
+		}
+		sum := sha256.Sum256(data)
+		if hash != hex.EncodeToString(sum[:]) || size != int64(len(data)) {
+			t.Fatalf("storeChunkedFile() = %s, %d; want the SHA-256 and size of the content", hash, size)
+		}
+		return hash
+	}
+	refs := func(hash string) []chunkRef {
+		t.Helper()
+		val, meta, err := database.objectValue(hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs, err := database.chunkRefs(hash, val, meta)
+		if err != nil || len(refs) == 0 {
+			t.Fatalf("chunkRefs(%s) = %d chunks, %v; want a chunk list", hash[:8], len(refs), err)
+		}
+		return refs
+	}
+
+	base := randomBytes(t, 2*chunkMinObjectSize)
+	edited := slices.Concat(base[:len(base)/2], []byte("inserted"), base[len(base)/2:])
+	baseHash, editedHash := store(base), store(edited)
+
+	got, err := database.GetObject(baseHash)
+	if err != nil || !bytes.Equal(got, base) {
+		t.Fatalf("GetObject() = %d bytes, %v; want the %d stored", len(got), err, len(base))
+	}
+	size, err := database.ObjectSize(editedHash)
+	if err != nil || size != int64(len(edited)) {
+		t.Errorf("ObjectSize() = %d, %v; want %d", size, err, len(edited))
+	}
+
+	obj, err := database.OpenObject(editedHash)
+	if err != nil {
+		t.Fatalf("OpenObject() error = %v", err)
+	}
+	offset := int64(len(edited) - 100000)
+	if _, err := obj.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("Seek() error = %v", err)
+	}
+	tail, err := io.ReadAll(obj)
+	obj.Close()
+	if err != nil || !bytes.Equal(tail, edited[offset:]) {
+		t.Errorf("read after Seek() = %d bytes, %v; want the last %d", len(tail), err, len(edited)-int(offset))
+	}
+
+	baseRefs, editedRefs := refs(baseHash), refs(editedHash)
+	var shared int
+	for _, ref := range editedRefs {
+		if slices.ContainsFunc(baseRefs, func(r chunkRef) bool { return r.hash == ref.hash }) {
+			shared++
+		}
+	}
+	if unique := len(editedRefs) - shared; unique == 0 || unique > 3 {
+		t.Errorf("insertion changed %d of %d chunks, want 1 to 3", unique, len(editedRefs))
+	}
+
+	report, err := database.Fsck(false)
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("Fsck() = %+v, %v; want no issues", report.Issues, err)
+	}
+
+	// Keep base; gc takes edited and the chunks only it uses.
+	err = database.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(idxResourceByObjectKey(baseHash, "resource"), nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := database.GC(GCOptions{})
+	if err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if want := int64(1 + len(editedRefs) - shared); res.ObjectsDeleted != want {
+		t.Errorf("GC() deleted %d objects, want %d", res.ObjectsDeleted, want)
+	}
+	if database.ObjectExists(editedHash) {
+		t.Errorf("GC() kept the unreferenced chunked object")
+	}
+	got, err = database.GetObject(baseHash)
+	if err != nil || !bytes.Equal(got, base) {
+		t.Errorf("GetObject() after GC() = %d bytes, %v; want the %d stored", len(got), err, len(base))
+	}
+}
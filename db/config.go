@@ -0,0 +1,309 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/pelletier/go-toml"
+)
+
+// ConfigFile is the name of the repo configuration inside the repo
+// directory. It is written with the defaults the first time a repo is
+// opened.
+const ConfigFile = "grit.toml"
+
+// defaultObjectLayout fans objects/ out into three levels of three hex
+// characters, the layout used before it was configurable.
+const defaultObjectLayout = "3/3/3"
+
+// Config is the repo configuration read from grit.toml.
+type Config struct {
+	Badger  BadgerConfig  `toml:"badger" comment:"BadgerDB tuning. Changes take effect the next time the repo is opened."`
+	Storage StorageConfig `toml:"storage" comment:"Where objects are kept."`
+	Run     RunConfig     `toml:"run" comment:"Process settings for long-running commands."`
+}
+
+// BadgerConfig holds the BadgerDB options grit exposes. The defaults keep
+// memory low for a write-heavy pipeline.
+type BadgerConfig struct {
+	SyncWrites              bool     `toml:"sync_writes" comment:"fsync every write; slower, but survives power loss"`
+	BlockCache              ByteSize `toml:"block_cache" comment:"read cache; badger's default is 256MiB"`
+	MemTableSize            ByteSize `toml:"memtable_size"`
+	NumMemtables            int      `toml:"num_memtables"`
+	ValueLogFileSize        ByteSize `toml:"value_log_file_size" comment:"smaller files let dead space be reclaimed sooner"`
+	ValueThreshold          ByteSize `toml:"value_threshold" comment:"values at least this large go to the value log"`
+	NumLevelZeroTables      int      `toml:"num_level_zero_tables"`
+	NumLevelZeroTablesStall int      `toml:"num_level_zero_tables_stall"`
+	NumCompactors           int      `toml:"num_compactors" comment:"badger's minimum is 2"`
+	BaseTableSize           ByteSize `toml:"base_table_size" comment:"smaller tables keep compaction's peak memory lower"`
+}
+
+// StorageConfig controls where object content is kept.
+type StorageConfig struct {
+	InlineMax    ByteSize `toml:"inline_max" comment:"objects at least this large after compression go to the object store instead of badger"`
+	ObjectLayout string   `toml:"object_layout" comment:"directory fan-out of objects/, in hex characters per level; fixed when the repo is created"`
+}
+
+// RunConfig holds process settings for long-running commands.
+type RunConfig struct {
+	MemoryLimit        ByteSize `toml:"memory_limit" comment:"soft limit for the Go heap of grit run, so idle memory goes back to the OS"`
+	ValueLogGCInterval Duration `toml:"value_log_gc_interval" comment:"how often grit run and grit serve garbage collect badger's value log"`
+}
+
+// DefaultConfig returns the settings of a new repo.
+func DefaultConfig() Config {
+	return Config{
+		Badger: BadgerConfig{
+			BlockCache:              32 << 20,
+			MemTableSize:            32 << 20,
+			NumMemtables:            2,
+			ValueLogFileSize:        64 << 20,
+			ValueThreshold:          1 << 10,
+			NumLevelZeroTables:      5,
+			NumLevelZeroTablesStall: 10,
+			NumCompactors:           2,
+			BaseTableSize:           512 << 10,
+		},
+		Storage: StorageConfig{
+			InlineMax:    fsObjectThreshold,
+			ObjectLayout: defaultObjectLayout,
+		},
+		Run: RunConfig{
+			MemoryLimit:        512 << 20,
+			ValueLogGCInterval: Duration(30 * time.Second),
+		},
+	}
+}
+
+// loadConfig reads grit.toml from the repo, writing it with the defaults if
+// it does not exist, and applies overrides over it.
+func loadConfig(repoPath string, overrides []string) (Config, error) {
+	return readConfig(repoPath, overrides, true)
+}
+
+// readConfig is loadConfig that only writes the defaults out if create is
+// set. Otherwise a missing grit.toml leaves every setting at its default.
+func readConfig(repoPath string, overrides []string, create bool) (Config, error) {
+	path := filepath.Join(repoPath, ConfigFile)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !create {
+		data, err = nil, nil
+	} else if errors.Is(err, os.ErrNotExist) {
+		var buf bytes.Buffer
+		buf.WriteString("# grit repo configuration. Override a setting for one command with\n# -set section.key=value, e.g. -set run.memory_limit=1GiB.\n")
+		if err := toml.NewEncoder(&buf).Order(toml.OrderPreserve).Encode(DefaultConfig()); err != nil {
+			return Config{}, err
+		}
+		data = buf.Bytes()
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return Config{}, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+	} else if err != nil {
+		return Config{}, err
+	}
+
+	// Settings missing from the file keep their defaults.
+	cfg := DefaultConfig()
+	if err := toml.NewDecoder(bytes.NewReader(data)).Strict(true).Decode(&cfg); err != nil {
+		return Config{}, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if err := cfg.apply(overrides); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// apply sets key=value overrides. Values are TOML, except that a bare word
+// such as 256MiB is taken as a string.
+func (c *Config) apply(overrides []string) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(*c); err != nil {
+		return err
+	}
+	tree, err := toml.LoadBytes(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	for _, override := range overrides {
+		key, value, ok := strings.Cut(override, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q: want key=value", override)
+		}
+		key = strings.TrimSpace(key)
+		if _, isTable := tree.Get(key).(*toml.Tree); !tree.Has(key) || isTable {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+		var v any = value
+		if parsed, err := toml.Load("v = " + value); err == nil {
+			v = parsed.Get("v")
+		}
+		tree.Set(key, v)
+	}
+	if err := tree.Unmarshal(c); err != nil {
+		return fmt.Errorf("invalid setting: %w", err)
+	}
+	return nil
+}
+
+func (c Config) validate() error {
+	if _, err := parseObjectLayout(c.Storage.ObjectLayout); err != nil {
+		return err
+	}
+	if c.Badger.NumCompactors < 2 {
+		return errors.New("badger.num_compactors must be at least 2")
+	}
+	if c.Badger.NumMemtables < 1 {
+		return errors.New("badger.num_memtables must be at least 1")
+	}
+	if c.Storage.InlineMax < 1 {
+		return errors.New("storage.inline_max must be positive")
+	}
+	return nil
+}
+
+// badgerOptions returns the options BadgerDB is opened with.
+func (c Config) badgerOptions(dir string) badger.Options {
+	opts := badger.DefaultOptions(dir)
+	opts.Logger = nil
+	opts.NumVersionsToKeep = 1
+	opts.CompactL0OnClose = false
+
+	opts.SyncWrites = c.Badger.SyncWrites
+	opts.BlockCacheSize = int64(c.Badger.BlockCache)
+	opts.MemTableSize = int64(c.Badger.MemTableSize)
+	opts.NumMemtables = c.Badger.NumMemtables
+	opts.ValueLogFileSize = int64(c.Badger.ValueLogFileSize)
+	opts.ValueThreshold = int64(c.Badger.ValueThreshold)
+	opts.NumLevelZeroTables = c.Badger.NumLevelZeroTables
+	opts.NumLevelZeroTablesStall = c.Badger.NumLevelZeroTablesStall
+	opts.NumCompactors = c.Badger.NumCompactors
+	opts.BaseTableSize = int64(c.Badger.BaseTableSize)
+	return opts
+}
+
+// parseObjectLayout parses a fan-out such as 3/3/3 into the widths of each
+// directory level.
+func parseObjectLayout(layout string) ([]int, error) {
+	var widths []int
+	total := 0
+	for _, field := range strings.Split(layout, "/") {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 1 || n > 8 {
+			return nil, fmt.Errorf("invalid object layout %q: want levels of 1 to 8 hex characters, e.g. %s", layout, defaultObjectLayout)
+		}
+		widths = append(widths, n)
+		total += n
+	}
+	if total >= 32 {
+		return nil, fmt.Errorf("invalid object layout %q: too deep", layout)
+	}
+	return widths, nil
+}
+
+// checkFixedConfig records the settings that cannot change once objects
+// exist, and refuses to open the repo if the configuration disagrees with
+// them. A read-only database is only checked against what was recorded.
+func (d Database) checkFixedConfig(readOnly bool) error {
+	run := d.badgerDB.Update
+	if readOnly {
+		run = d.badgerDB.View
+	}
+	return run(func(txn *badger.Txn) error {
+		recorded, err := getVal(txn, metaObjectLayoutKey())
+		if err != nil || (recorded == nil && readOnly) {
+			return err
+		}
+		if recorded == nil {
+			// Repos created before the layout was recorded used the default.
+			layout := d.config.Storage.ObjectLayout
+			if prefixExists(txn, []byte(prefixObject)) {
+				layout = defaultObjectLayout
+			}
+			if err := txn.Set(metaObjectLayoutKey(), []byte(layout)); err != nil {
+				return err
+			}
+			recorded = []byte(layout)
+		}
+		if string(recorded) != d.config.Storage.ObjectLayout {
+			return fmt.Errorf("storage.object_layout is %s but the repo was created with %s, which cannot be changed",
+				d.config.Storage.ObjectLayout, recorded)
+		}
+		return nil
+	})
+}
+
+// Config returns the configuration the database was opened with.
+func (d Database) Config() Config {
+	return d.config
+}
+
+// ByteSize is a size in bytes, written with a binary unit such as 64KiB.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"GiB", 1 << 30},
+	{"MiB", 1 << 20},
+	{"KiB", 1 << 10},
+	{"B", 1},
+}
+
+func (s ByteSize) MarshalText() ([]byte, error) {
+	for _, unit := range byteUnits {
+		if s != 0 && int64(s)%unit.size == 0 {
+			return []byte(strconv.FormatInt(int64(s)/unit.size, 10) + unit.suffix), nil
+		}
+	}
+	return []byte("0B"), nil
+}
+
+func (s *ByteSize) UnmarshalText(text []byte) error {
+	str := strings.TrimSpace(string(text))
+	for _, unit := range byteUnits {
+		if num, ok := strings.CutSuffix(str, unit.suffix); ok {
+			n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+			if err != nil || n < 0 {
+				break
+			}
+			*s = ByteSize(n * unit.size)
+			return nil
+		}
+	}
+	n, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size %q: want a number of bytes or e.g. 64KiB, 32MiB", text)
+	}
+	*s = ByteSize(n)
+	return nil
+}
+
+// Duration is a time.Duration written like 30s or 5m.
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	database.Close()
+	if database.Config() != DefaultConfig() {
+		t.Errorf("Config() = %+v, want the defaults", database.Config())
+	}
+	written, err := loadConfig(dir, nil)
+	if err != nil || written != DefaultConfig() {
+		t.Errorf("loadConfig() = %+v, %v; want the defaults written to %s", written, err, ConfigFile)
+	}
+
+	cfg, err := loadConfig(dir, []string{"badger.block_cache=1024", "run.memory_limit=1GiB", "badger.sync_writes=true"})
+	if err != nil {
+		t.Fatalf("loadConfig(overrides) error = %v", err)
+	}
+	if cfg.Badger.BlockCache != 1024 || cfg.Run.MemoryLimit != 1<<30 || !cfg.Badger.SyncWrites {
+		t.Errorf("loadConfig(overrides) = %+v, want the overrides applied", cfg)
+	}
+	for _, bad := range []string{"badger.nope=1", "badger.block_cache", "badger=1", "run.memory_limit=lots", "badger.num_compactors=1"} {
+		if _, err := loadConfig(dir, []string{bad}); err == nil {
+			t.Errorf("loadConfig(%s) succeeded, want an error", bad)
+		}
+	}
+
+	// The object layout is fixed once the repo exists.
+	if _, err := Open(dir, Options{Overrides: []string{"storage.object_layout=2/2"}}); err == nil {
+		t.Fatalf("Open(changed layout) succeeded, want an error")
+	}
+
+	// A new repo uses the layout in its grit.toml.
+	dir = t.TempDir()
+	config := []byte("[storage]\nobject_layout = \"2/2\"\ninline_max = \"1KiB\"\n")
+	if err := os.WriteFile(filepath.Join(dir, ConfigFile), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+	database, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase(2/2) error = %v", err)
+	}
+	defer database.Close()
+	_, hash, err := database.CreateResourceFromReader("big", bytes.NewReader(randomBytes(t, 4096)))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects", hash[:2], hash[2:4], hash[4:])); err != nil {
+		t.Errorf("object not stored in the 2/2 layout: %v", err)
+	}
+}
+
+func TestByteSize(t *testing.T) {
+	for text, want := range map[string]ByteSize{"64KiB": 64 << 10, "3GiB": 3 << 30, "1000": 1000, "12B": 12} {
+		var got ByteSize
+		if err := got.UnmarshalText([]byte(text)); err != nil || got != want {
+			t.Errorf("UnmarshalText(%q) = %d, %v; want %d", text, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "5MB", "-1KiB", "KiB"} {
+		var got ByteSize
+		if err := got.UnmarshalText([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalText(%q) = %d, want an error", bad, got)
+		}
+	}
+	if text, _ := ByteSize(1536).MarshalText(); string(text) != "1536B" {
+		t.Errorf("MarshalText(1536) = %s, want 1536B", text)
+	}
+}
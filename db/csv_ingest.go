@@ -11,6 +11,9 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
+
+	"grit/types"
 
 	badger "github.com/dgraph-io/badger/v4"
 )
@@ -95,7 +98,8 @@ func hashFile(path string) (string, error) {
 // Crash recovery: a byte offset is checkpointed after each batch so a restart
 // can resume mid-file without re-processing rows.
 func (d *Database) IngestCsvFile(path string, outputName string, columns []string) (int64, error) {
-	dbLogger.Printf("Ingesting CSV file: %s → output name: %s\n", path, outputName)
+	start := time.Now()
+	dbLogger.Info("Ingesting CSV file", "path", path, "name", outputName)
 
 	filtering := len(columns) > 0
 
@@ -109,7 +113,7 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 		return 0, fmt.Errorf("failed to check stored CSV hash: %w", err)
 	}
 	if storedHash == fileHash {
-		dbLogger.Printf("CSV file %s unchanged (hash %s), skipping\n", path, fileHash[:16])
+		dbLogger.Info("CSV file unchanged, skipping", "path", path, "hash", fileHash[:16])
 		return 0, nil
 	}
 
@@ -118,9 +122,9 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 		return 0, fmt.Errorf("failed to read CSV offset: %w", err)
 	}
 	if startOffset > 0 {
-		dbLogger.Printf("CSV file %s: resuming from byte offset %d\n", path, startOffset)
+		dbLogger.Info("Resuming CSV ingest", "path", path, "offset", startOffset)
 	} else {
-		dbLogger.Printf("CSV file %s changed or new, ingesting rows...\n", path)
+		dbLogger.Info("CSV file changed or new, ingesting rows", "path", path)
 	}
 
 	f, err := os.Open(path)
@@ -154,11 +158,11 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 			return nil
 		}
 		for _, item := range objectBatch {
-			if err := d.StoreObject(item.hash, item.data); err != nil {
+			backend, err := d.storeObject(item.hash, item.data, types.CompressAuto)
+			if err != nil {
 				return fmt.Errorf("failed to store object: %w", err)
 			}
-			backend := d.StorageBackendForSize(len(item.data))
-			if err := d.insertResource(outputName, item.hash, "", backend); err != nil {
+			if err := d.insertResource(outputName, item.hash, "", backend, int64(len(item.data)), nil); err != nil {
 				return fmt.Errorf("failed to create resource: %w", err)
 			}
 		}
@@ -251,7 +255,7 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 							if err := flushBatch(); err != nil {
 								return count, err
 							}
-							dbLogger.Verbosef("CSV ingest: %d rows processed so far\n", count)
+							dbLogger.Debug("CSV ingest progress", "path", path, "rows", count)
 						}
 					}
 				}
@@ -264,7 +268,7 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 			}
 		}
 		if skipped > 0 {
-			dbLogger.Printf("CSV ingest: skipped %d rows with missing column values\n", skipped)
+			dbLogger.Warn("Skipped CSV rows with missing column values", "path", path, "rows", skipped)
 		}
 	} else {
 		// Raw line-by-line path (no column filtering).
@@ -287,7 +291,7 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 					if err := flushBatch(); err != nil {
 						return count, err
 					}
-					dbLogger.Verbosef("CSV ingest: %d rows processed so far\n", count)
+					dbLogger.Debug("CSV ingest progress", "path", path, "rows", count)
 				}
 			}
 			if err == io.EOF {
@@ -310,6 +314,6 @@ func (d *Database) IngestCsvFile(path string, outputName string, columns []strin
 		return count, fmt.Errorf("failed to clear CSV offset: %w", err)
 	}
 
-	dbLogger.Printf("CSV ingest complete: %d rows from %s\n", count, path)
+	dbLogger.Info("CSV ingest complete", "path", path, "name", outputName, "rows", count, "duration", time.Since(start))
 	return count, nil
 }
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"grit/broadcast"
 	"fmt"
 	"grit/log"
 	"os"
@@ -11,52 +12,104 @@ import (
 
 var dbLogger = log.NewLogger("DB")
 
+// Options adjusts how a repo is opened. The zero value uses the repo's
+// grit.toml as is.
+type Options struct {
+	// Overrides are section.key=value settings applied over grit.toml for
+	// as long as the database is open, e.g. badger.block_cache=256MiB.
+	Overrides []string
+	// ReadOnly opens an existing repo without writing to it: Badger takes a
+	// shared lock, grit.toml is not created, and the schema must already be
+	// current.
+	ReadOnly bool
+}
+
+// NewDatabase opens the repo at repo_path, creating it if it does not exist,
+// and brings its schema up to date.
 func NewDatabase(repo_path string) (Database, error) {
-	err := os.MkdirAll(repo_path, 0755)
-	if err != nil {
+	return Open(repo_path, Options{})
+}
+
+// Open is NewDatabase with options.
+func Open(repo_path string, opts Options) (Database, error) {
+	return openDatabase(repo_path, opts, true)
+}
+
+// OpenUnmigrated opens the repo at repo_path without applying pending
+// migrations, for grit migrate. Only Migrate and PendingMigrations may be
+// relied on until the schema is current.
+func OpenUnmigrated(repo_path string, opts Options) (Database, error) {
+	return openDatabase(repo_path, opts, false)
+}
+
+func openDatabase(repo_path string, opts Options, migrate bool) (Database, error) {
+	if err := checkRepoFormat(repo_path); err != nil {
 		return Database{}, err
 	}
 
-	if err := os.MkdirAll(repo_path+"/objects", 0755); err != nil {
+	if !opts.ReadOnly {
+		err := os.MkdirAll(repo_path, 0755)
+		if err != nil {
+			return Database{}, err
+		}
+
+		if err := os.MkdirAll(repo_path+"/objects", 0755); err != nil {
+			return Database{}, err
+		}
+	}
+
+	config, err := readConfig(repo_path, opts.Overrides, !opts.ReadOnly)
+	if err != nil {
 		return Database{}, err
 	}
 
-	dbLogger.Verbosef("Opening BadgerDB at %s\n", repo_path)
-	badgerOpts := badger.DefaultOptions(repo_path + "/db")
-	badgerOpts.Logger = nil
-
-	// Keep writes durable but light on memory.
-	badgerOpts.SyncWrites = false
-	badgerOpts.NumVersionsToKeep = 1
-	badgerOpts.CompactL0OnClose = false
-
-	// Memory footprint: default block cache is 256 MB (z.Calloc off-heap).
-	// Reduce to 32 MB; we don't need a large read cache for a write-heavy pipeline.
-	badgerOpts.BlockCacheSize = 32 << 20
-
-	// Memtable: default is 64 MB. Keep it at 32 MB × 2 = 64 MB max instead of
-	// the previous 128 MB × 3 = 384 MB.
-	badgerOpts.MemTableSize = 32 << 20
-	badgerOpts.NumMemtables = 2
-
-	// Vlog: limit file size so dead space is reclaimed more frequently.
-	badgerOpts.ValueLogFileSize = 64 << 20
-	badgerOpts.NumLevelZeroTables = 5
-	badgerOpts.NumLevelZeroTablesStall = 10
-	badgerOpts.ValueThreshold = 1024
-	// Minimum allowed by badger is 2; keep at minimum to halve parallelism vs default.
-	badgerOpts.NumCompactors = 2
-	// Smaller SST target size → each table.Builder holds smaller z.Allocator buffers.
-	// Default is 2MB; 512KB keeps compaction peak memory proportionally lower.
-	badgerOpts.BaseTableSize = 512 << 10
+	dbLogger.Debug("Opening BadgerDB", "path", repo_path, "read_only", opts.ReadOnly)
+	badgerOpts := config.badgerOptions(repo_path + "/db")
+	badgerOpts.ReadOnly = opts.ReadOnly
 
 	badgerDB, err := badger.Open(badgerOpts)
 	if err != nil {
 		return Database{}, fmt.Errorf("failed to open BadgerDB: %w", err)
 	}
 
-	dbLogger.Println("Database ready")
-	return Database{repo_path, badgerDB}, nil
+	d := Database{repo_path, badgerDB, broadcast.NewBroadcaster[Event](), &runningTasks{byStep: make(map[string]int64)}, &currentRun{}, &objectStores{}, config}
+	if err := d.checkFixedConfig(opts.ReadOnly); err != nil {
+		badgerDB.Close()
+		return Database{}, err
+	}
+	if err := d.openObjectStores(); err != nil {
+		badgerDB.Close()
+		return Database{}, fmt.Errorf("failed to open object stores: %w", err)
+	}
+	if opts.ReadOnly {
+		version, err := d.checkSchemaVersion()
+		if err == nil && version < SchemaVersion {
+			err = fmt.Errorf("the repo has schema version %d but needs %d; run grit migrate", version, SchemaVersion)
+		}
+		if err != nil {
+			badgerDB.Close()
+			return Database{}, err
+		}
+		dbLogger.Debug("Database ready", "path", repo_path)
+		return d, nil
+	}
+	if err := d.initSchemaVersion(); err != nil {
+		badgerDB.Close()
+		return Database{}, fmt.Errorf("failed to record schema version: %w", err)
+	}
+	if _, err := d.checkSchemaVersion(); err != nil {
+		badgerDB.Close()
+		return Database{}, err
+	}
+	if migrate {
+		if _, err := d.Migrate(false); err != nil {
+			badgerDB.Close()
+			return Database{}, err
+		}
+	}
+
+	dbLogger.Debug("Database ready", "path", repo_path)
+	return d, nil
 }
 
 func (d Database) Close() error {
@@ -86,11 +139,13 @@ func (d Database) StartValueLogGC(interval time.Duration, stop <-chan struct{})
 			case <-ticker.C:
 				// Reclaim vlogs where >30% of space is dead.
 				for {
-					if err := d.badgerDB.RunValueLogGC(0.3); err != nil {
+					err := d.badgerDB.RunValueLogGC(0.3)
+					recordVlogGC(err)
+					if err != nil {
 						break // ErrNoRewrite means nothing left to reclaim
 					}
 				}
-				dbLogger.Verbosef("Value log GC complete\n")
+				dbLogger.Debug("Value log GC complete")
 			case <-stop:
 				return
 			}
@@ -0,0 +1,35 @@
+package db
+
+// Event kinds published by a Database.
+const (
+	EventTaskFinished    = "task.finished"
+	EventResourceCreated = "resource.created"
+)
+
+// Event reports a change made through this Database handle. Other processes'
+// writes are not seen.
+type Event struct {
+	Kind     string    `json:"kind"`
+	Time     string    `json:"time"`
+	Task     *Task     `json:"task,omitempty"`
+	Resource *Resource `json:"resource,omitempty"`
+}
+
+// Subscribe returns a channel of events. Events are dropped rather than
+// blocking writers when the channel's buffer is full.
+func (d Database) Subscribe(buffer int) chan Event {
+	return d.events.Subscribe(buffer)
+}
+
+// Unsubscribe stops and closes a channel returned by Subscribe.
+func (d Database) Unsubscribe(ch chan Event) {
+	d.events.Unsubscribe(ch)
+}
+
+func (d Database) publish(e Event) {
+	if e.Kind == EventResourceCreated {
+		resourcesCreated.Inc(e.Resource.Name)
+	}
+	e.Time = nowTimestamp()
+	d.events.Broadcast(e)
+}
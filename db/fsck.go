@@ -0,0 +1,489 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+
+	"grit/objstore"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Kinds of problem Fsck reports.
+const (
+	// FsckCorruptObject is an object whose content no longer hashes to its
+	// address.
+	FsckCorruptObject = "corrupt_object"
+	// FsckMissingObjectFile is an fs object whose file is gone.
+	FsckMissingObjectFile = "missing_object_file"
+	// FsckMissingObject is a resource whose object does not exist.
+	FsckMissingObject = "missing_object"
+	// FsckOrphanFile is a file in an object store that no object points at.
+	FsckOrphanFile = "orphan_file"
+	// FsckTempFile is a temporary file left by an interrupted object write.
+	FsckTempFile = "temp_file"
+	// FsckDanglingIndex is an index entry whose step, task or resource is
+	// gone.
+	FsckDanglingIndex = "dangling_index"
+	// FsckDanglingLog is captured output of a task that no longer exists.
+	FsckDanglingLog = "dangling_log"
+	// FsckMissingInput is a task whose input resource was deleted.
+	FsckMissingInput = "missing_input"
+	// FsckMissingIndex is a resource missing from the object reference
+	// index, which would let gc delete its object.
+	FsckMissingIndex = "missing_index"
+)
+
+// FsckIssue is one problem found by Fsck.
+type FsckIssue struct {
+	Kind string `json:"kind"`
+	// Subject is the object hash, file path, key or ID the issue is about.
+	Subject string `json:"subject"`
+	Detail  string `json:"detail,omitempty"`
+	// Expected marks what normal use leaves behind, such as finished tasks
+	// whose inputs were deleted by prune. It is reported but not an error.
+	Expected bool `json:"expected,omitempty"`
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+// FsckReport is the result of Fsck.
+type FsckReport struct {
+	Objects      int64       `json:"objects"`
+	Files        int64       `json:"files"`
+	Resources    int64       `json:"resources"`
+	Tasks        int64       `json:"tasks"`
+	IndexEntries int64       `json:"index_entries"`
+	Issues       []FsckIssue `json:"issues"`
+}
+
+// Unresolved counts the issues that are neither expected nor repaired.
+func (r FsckReport) Unresolved() int {
+	n := 0
+	for _, issue := range r.Issues {
+		if !issue.Expected && !issue.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+// Fsck checks the database for corrupt or missing objects and for records
+// that point at things that no longer exist. With repair it also fixes what
+// can be fixed without losing data:
+//
+//   - temporary files and orphan files are removed, except that an orphan
+//     file that hashes to its name gets its object record back;
+//   - dangling index entries and logs are deleted, and missing object
+//     references are added;
+//   - unprocessed tasks whose inputs were deleted are deleted, since they
+//     can never run.
+//
+// Corrupt and missing objects are only reported. The database must not be in
+// use by another process.
+func (d Database) Fsck(repair bool) (FsckReport, error) {
+	var report FsckReport
+	checks := []func(*FsckReport, bool) error{
+		d.fsckObjects,
+		d.fsckFiles,
+		d.fsckResources,
+		d.fsckTasks,
+		d.fsckIndexes,
+		d.fsckLogs,
+	}
+	for _, check := range checks {
+		if err := check(&report, repair); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// fsckObjects re-hashes the content of every object, inline and on the
+// filesystem. Objects are streamed through the hash, a batch at a time
+// outside the transaction that listed them.
+func (d Database) fsckObjects(report *FsckReport, _ bool) error {
+	type object struct {
+		hash string
+		val  []byte
+		meta byte
+	}
+	prefix := []byte(prefixObject)
+	cursor := prefix
+	for {
+		var batch []object
+		err := d.badgerDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Seek(cursor); it.ValidForPrefix(prefix) && len(batch) < scanBatchSize; it.Next() {
+				item := it.Item()
+				val, err := item.ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				hash := hex.EncodeToString(item.Key()[len(prefix):])
+				batch = append(batch, object{hash, val, item.UserMeta()})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, obj := range batch {
+			report.Objects++
+			d.fsckObject(report, obj.hash, obj.val, obj.meta)
+		}
+		hashBytes, _ := hex.DecodeString(batch[len(batch)-1].hash)
+		cursor = append(objectKey(hashBytes), 0)
+	}
+}
+
+// fsckObject re-hashes one object and reports it if it is missing or corrupt.
+func (d Database) fsckObject(report *FsckReport, hash string, val []byte, meta byte) {
+	where := "inline"
+	if store, err := d.storeFor(val); err != nil {
+		where = err.Error()
+	} else if store != nil {
+		where = objectLocation(store, hash)
+	}
+
+	h := sha256.New()
+	obj, err := d.openObject(hash, val, meta)
+	if err == nil {
+		_, err = io.Copy(h, obj)
+		obj.Close()
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		report.Issues = append(report.Issues, FsckIssue{
+			Kind: FsckMissingObjectFile, Subject: hash, Detail: where,
+		})
+	case err != nil:
+		report.Issues = append(report.Issues, FsckIssue{
+			Kind: FsckCorruptObject, Subject: hash,
+			Detail: fmt.Sprintf("%s: %v", where, err),
+		})
+	default:
+		if sum := h.Sum(nil); hex.EncodeToString(sum) != hash {
+			report.Issues = append(report.Issues, FsckIssue{
+				Kind: FsckCorruptObject, Subject: hash,
+				Detail: fmt.Sprintf("%s: content hashes to %x", where, sum),
+			})
+		}
+	}
+}
+
+// fsckFiles looks for stored objects and files that no object record points
+// at, in every object store.
+func (d Database) fsckFiles(report *FsckReport, repair bool) error {
+	for _, store := range d.allStores() {
+		if err := d.fsckStore(store, report, repair); err != nil {
+			return fmt.Errorf("failed to scan %s object store: %w", store.Name(), err)
+		}
+	}
+	return nil
+}
+
+func (d Database) fsckStore(store objstore.Store, report *FsckReport, repair bool) error {
+	return store.List(func(info objstore.Info) error {
+		report.Files++
+		subject := objectLocation(store, info.Key)
+
+		if info.Partial {
+			issue := FsckIssue{Kind: FsckTempFile, Subject: subject}
+			if repair {
+				if err := store.Delete(info.Key); err != nil {
+					return err
+				}
+				issue.Repaired = true
+			}
+			report.Issues = append(report.Issues, issue)
+			return nil
+		}
+		if info.Hash == "" {
+			issue := FsckIssue{Kind: FsckOrphanFile, Subject: subject, Detail: "not an object file"}
+			report.Issues = append(report.Issues, issue)
+			return nil
+		}
+
+		hashBytes, err := hex.DecodeString(info.Hash)
+		if err != nil {
+			return err
+		}
+		var val []byte
+		err = d.badgerDB.View(func(txn *badger.Txn) error {
+			var err error
+			val, err = getVal(txn, objectKey(hashBytes))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if string(val) == store.Name() {
+			return nil
+		}
+
+		issue := FsckIssue{Kind: FsckOrphanFile, Subject: subject}
+		if val == nil {
+			issue.Detail = "no object record"
+		} else if other, _ := d.storeFor(val); other != nil {
+			issue.Detail = "object is stored in " + other.Name()
+		} else {
+			issue.Detail = "object is stored inline"
+		}
+		if repair {
+			if err := d.repairOrphanFile(store, info.Hash, hashBytes, val == nil); err != nil {
+				return err
+			}
+			issue.Repaired = true
+		}
+		report.Issues = append(report.Issues, issue)
+		return nil
+	})
+}
+
+// repairOrphanFile restores the object record of an intact orphan, which is
+// what an object write interrupted after its put leaves behind, and removes
+// any other orphan.
+func (d Database) repairOrphanFile(store objstore.Store, hash string, hashBytes []byte, unrecorded bool) error {
+	if unrecorded {
+		f, err := store.Open(hash)
+		if err != nil {
+			return err
+		}
+		stored, err := readAllFrom(f)
+		if err != nil {
+			return err
+		}
+		// The file is either written with an object header or, from before
+		// compression, as is.
+		for _, meta := range []byte{objectMetaEncoded, 0} {
+			data, err := d.readObject(hash, stored, meta)
+			if err != nil {
+				continue
+			}
+			if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) == hash {
+				return d.badgerDB.Update(func(txn *badger.Txn) error {
+					return txn.SetEntry(badger.NewEntry(objectKey(hashBytes), []byte(store.Name())).WithMeta(meta))
+				})
+			}
+		}
+	}
+	return store.Delete(hash)
+}
+
+// fsckResources finds resources whose object does not exist or that are
+// missing from the object reference index.
+func (d Database) fsckResources(report *FsckReport, repair bool) error {
+	var missing [][]byte
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		return prefixScan(txn, []byte(prefixResource), func(_, val []byte) (bool, error) {
+			report.Resources++
+			var r Resource
+			if err := decode(val, &r); err != nil {
+				return false, err
+			}
+			hashBytes, err := hex.DecodeString(r.ObjectHash)
+			if err != nil || !keyExists(txn, objectKey(hashBytes)) {
+				report.Issues = append(report.Issues, FsckIssue{
+					Kind: FsckMissingObject, Subject: r.ID,
+					Detail: fmt.Sprintf("%s has no object %s", r.Name, r.ObjectHash),
+				})
+			}
+			if key := idxResourceByObjectKey(r.ObjectHash, r.ID); !keyExists(txn, key) {
+				missing = append(missing, key)
+				report.Issues = append(report.Issues, FsckIssue{
+					Kind: FsckMissingIndex, Subject: r.ID,
+					Detail: "no reference to object " + r.ObjectHash, Repaired: repair,
+				})
+			}
+			return true, nil
+		})
+	})
+	if err != nil || !repair {
+		return err
+	}
+	wb := d.badgerDB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range missing {
+		if err := wb.Set(key, nil); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// fsckTasks finds tasks whose input resources were deleted.
+func (d Database) fsckTasks(report *FsckReport, repair bool) error {
+	var stale []string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		return prefixScan(txn, []byte(prefixTask), func(_, val []byte) (bool, error) {
+			report.Tasks++
+			var t Task
+			if err := decode(val, &t); err != nil {
+				return false, err
+			}
+			var missing []string
+			for _, id := range t.InputIDs() {
+				if !keyExists(txn, resourceKey(id)) {
+					missing = append(missing, id)
+				}
+			}
+			if len(missing) == 0 {
+				return true, nil
+			}
+			report.Issues = append(report.Issues, FsckIssue{
+				Kind: FsckMissingInput, Subject: t.ID,
+				Detail:   "input " + strings.Join(missing, ", ") + " deleted",
+				Expected: t.Processed,
+			})
+			if !t.Processed {
+				stale = append(stale, t.ID)
+			}
+			return true, nil
+		})
+	})
+	if err != nil || !repair {
+		return err
+	}
+
+	for _, id := range stale {
+		if err := d.DeleteTask(id); err != nil {
+			return fmt.Errorf("failed to delete task %s: %w", id, err)
+		}
+	}
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		if issue.Kind == FsckMissingInput && !issue.Expected {
+			issue.Repaired = true
+		}
+	}
+	return nil
+}
+
+// fsckIndexes finds index entries whose step, task or resource is gone.
+func (d Database) fsckIndexes(report *FsckReport, repair bool) error {
+	var dangling [][]byte
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		return prefixScan(txn, []byte("ix:"), func(key, val []byte) (bool, error) {
+			report.IndexEntries++
+			target := indexTarget(key, val)
+			if target == nil || keyExists(txn, target) {
+				return true, nil
+			}
+			dangling = append(dangling, key)
+			report.Issues = append(report.Issues, FsckIssue{
+				Kind: FsckDanglingIndex, Subject: printableKey(key),
+				Detail: "points at missing " + printableKey(target), Repaired: repair,
+			})
+			return true, nil
+		})
+	})
+	if err != nil || !repair {
+		return err
+	}
+	return d.deleteKeys(dangling)
+}
+
+// indexTarget returns the key of the record an index entry refers to, or nil
+// when the entry does not refer to one.
+func indexTarget(key, val []byte) []byte {
+	s := string(key)
+	fields := func(prefix string) []string {
+		return strings.Split(strings.TrimPrefix(s, prefix), "\x00")
+	}
+	last := func(prefix string) string {
+		f := fields(prefix)
+		return f[len(f)-1]
+	}
+
+	switch {
+	case strings.HasPrefix(s, idxStepByName):
+		return stepKey(last(idxStepByName))
+	case strings.HasPrefix(s, idxTaskByStepUnproc):
+		return taskKey(last(idxTaskByStepUnproc))
+	case strings.HasPrefix(s, idxTaskByStepProc):
+		return taskKey(last(idxTaskByStepProc))
+	case strings.HasPrefix(s, idxTaskByStepSkipped):
+		return taskKey(last(idxTaskByStepSkipped))
+	case strings.HasPrefix(s, idxTaskByStepFailed):
+		return taskKey(last(idxTaskByStepFailed))
+	case strings.HasPrefix(s, idxTaskByStepAll):
+		return taskKey(last(idxTaskByStepAll))
+	case strings.HasPrefix(s, idxTaskBySeedParam):
+		return taskKey(last(idxTaskBySeedParam))
+	case strings.HasPrefix(s, idxTaskUnique):
+		// An empty value marks an input the step's when condition filtered
+		// out; there is no task to point at.
+		if len(val) == 0 {
+			return nil
+		}
+		return taskKey(string(val))
+	case strings.HasPrefix(s, idxTaskInput):
+		// Left behind if scheduling a reduce task was interrupted.
+		return taskKey(fields(idxTaskInput)[0])
+	case strings.HasPrefix(s, idxResourceByName):
+		return resourceKey(last(idxResourceByName))
+	case strings.HasPrefix(s, idxResourceHash):
+		return resourceKey(string(val))
+	case strings.HasPrefix(s, idxResourceByRun):
+		return resourceKey(last(idxResourceByRun))
+	case strings.HasPrefix(s, idxResourceByObject):
+		return resourceKey(last(idxResourceByObject))
+	case strings.HasPrefix(s, idxObjectByChunk):
+		hash, err := hex.DecodeString(last(idxObjectByChunk))
+		if err != nil {
+			return nil
+		}
+		return objectKey(hash)
+	}
+	return nil
+}
+
+// fsckLogs finds captured output of tasks that no longer exist.
+func (d Database) fsckLogs(report *FsckReport, repair bool) error {
+	var dangling [][]byte
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := []byte(prefixTaskLog)
+		return prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+			id := string(key[len(prefix):])
+			if !keyExists(txn, taskKey(id)) {
+				dangling = append(dangling, key)
+				report.Issues = append(report.Issues, FsckIssue{
+					Kind: FsckDanglingLog, Subject: id, Repaired: repair,
+				})
+			}
+			return true, nil
+		})
+	})
+	if err != nil || !repair {
+		return err
+	}
+	return d.deleteKeys(dangling)
+}
+
+func (d Database) deleteKeys(keys [][]byte) error {
+	wb := d.badgerDB.NewWriteBatch()
+	defer wb.Cancel()
+	for _, key := range keys {
+		if err := wb.Delete(key); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// printableKey shows a key with its \x00 separators as slashes.
+func printableKey(key []byte) string {
+	return string(bytes.ReplaceAll(key, []byte{0}, []byte{'/'}))
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// randomBytes returns n bytes that do not compress.
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestFsck(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	// A large object whose file gets truncated.
+	big := randomBytes(t, fsObjectThreshold)
+	bigID, bigHash, err := database.CreateResourceFromReader("big", bytes.NewReader(big))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader(big) error = %v", err)
+	}
+	if err := os.Chmod(database.objectFilePath(bigHash), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(database.objectFilePath(bigHash), big[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resource is missing from the object reference index.
+	if err := database.deleteKeys([][]byte{idxResourceByObjectKey(bigHash, bigID)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An object file written without its record, plus a leftover temp file.
+	lost := randomBytes(t, fsObjectThreshold)
+	sum := sha256.Sum256(lost)
+	lostHash := hex.EncodeToString(sum[:])
+	if _, err := database.storeObjectExternal(lostHash, encodeObject(lost, types.CompressNone)); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.deleteKeys([][]byte{objectKey(sum[:])}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(database.objectFilePath(lostHash)+".tmp", lost, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pending task whose input is deleted, and a resource removed without
+	// its indexes.
+	input, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("a")))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader(row) error = %v", err)
+	}
+	gone, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("b")))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader(row) error = %v", err)
+	}
+	stepID, err := database.CreateStep(Step{Name: "up", Script: "true", Input: "row"})
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	if _, err := database.ScheduleTasksForStep(stepID); err != nil {
+		t.Fatalf("ScheduleTasksForStep() error = %v", err)
+	}
+	if err := database.DeleteResource(input); err != nil {
+		t.Fatalf("DeleteResource() error = %v", err)
+	}
+	err = database.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(resourceKey(gone))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int{
+		FsckCorruptObject: 1,
+		FsckOrphanFile:    1,
+		FsckTempFile:      1,
+		FsckMissingInput:  2,
+		FsckDanglingIndex: 3,
+		FsckMissingIndex:  1,
+	}
+	report, err := database.Fsck(false)
+	if err != nil {
+		t.Fatalf("Fsck(false) error = %v", err)
+	}
+	got := make(map[string]int)
+	for _, issue := range report.Issues {
+		got[issue.Kind]++
+	}
+	for kind, n := range want {
+		if got[kind] != n {
+			t.Errorf("Fsck(false) found %d %s, want %d (%+v)", got[kind], kind, n, report.Issues)
+		}
+	}
+	if report.Unresolved() != len(report.Issues) {
+		t.Errorf("Unresolved() = %d, want %d", report.Unresolved(), len(report.Issues))
+	}
+
+	if _, err := database.Fsck(true); err != nil {
+		t.Fatalf("Fsck(true) error = %v", err)
+	}
+	report, err = database.Fsck(false)
+	if err != nil {
+		t.Fatalf("Fsck(false) error = %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Kind != FsckCorruptObject {
+		t.Errorf("after repair Fsck(false) = %+v, want only the corrupt object", report.Issues)
+	}
+	if _, err := database.GetObject(lostHash); err != nil {
+		t.Errorf("GetObject(lost) error = %v, want the orphan file adopted", err)
+	}
+}
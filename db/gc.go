@@ -0,0 +1,251 @@
+package db
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"grit/objstore"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// GCOptions controls GC.
+type GCOptions struct {
+	// DryRun reports what would be removed without removing it.
+	DryRun bool
+	// Grace keeps unreferenced objects and files written less than this long
+	// ago, so that a run's objects are not collected before its resources
+	// are recorded.
+	Grace time.Duration
+}
+
+// GCResult is what GC removed, or would remove on a dry run.
+type GCResult struct {
+	ObjectsScanned      int64 `json:"objects_scanned"`
+	ObjectsReferenced   int64 `json:"objects_referenced"`
+	ObjectsInGrace      int64 `json:"objects_in_grace"`
+	ObjectsDeleted      int64 `json:"objects_deleted"`
+	FilesDeleted        int64 `json:"files_deleted"`
+	BytesReclaimed      int64 `json:"bytes_reclaimed"`
+	IndexEntriesDeleted int64 `json:"index_entries_deleted"`
+	LogsDeleted         int64 `json:"logs_deleted"`
+}
+
+// gcVictim is an unreferenced object GC removes.
+type gcVictim struct {
+	hash []byte
+	// store holds the object, or is nil for inline objects.
+	store objstore.Store
+	// chunks are the chunks of a chunked object, released after it is gone.
+	chunks []chunkRef
+}
+
+// GC removes objects no resource refers to, stray files in object stores and
+// index entries and logs whose records are gone. An object is referenced
+// while idxResourceByObject or, for chunks, idxObjectByChunk has entries for
+// it; everything else is swept, except what is younger than opts.Grace. The
+// chunks of a swept chunked object go with it unless another object shares
+// them. The database must not be in use by another process.
+func (d Database) GC(opts GCOptions) (GCResult, error) {
+	var res GCResult
+	cutoff := time.Now().Add(-opts.Grace)
+
+	victims, err := d.sweepObjects(cutoff, &res)
+	if err != nil {
+		return res, fmt.Errorf("failed to sweep objects: %w", err)
+	}
+	if !opts.DryRun {
+		if err := d.deleteObjects(victims); err != nil {
+			return res, err
+		}
+	}
+	for _, v := range victims {
+		if len(v.chunks) == 0 {
+			continue
+		}
+		freed, err := d.releaseChunks(hex.EncodeToString(v.hash), v.chunks, opts.DryRun)
+		if err != nil {
+			return res, fmt.Errorf("failed to release chunks: %w", err)
+		}
+		res.ObjectsDeleted += freed.objects
+		res.FilesDeleted += freed.files
+		res.BytesReclaimed += freed.bytes
+	}
+
+	for _, store := range d.allStores() {
+		if err := d.sweepStore(store, cutoff, opts.DryRun, &res); err != nil {
+			return res, fmt.Errorf("failed to sweep %s object store: %w", store.Name(), err)
+		}
+	}
+
+	// Dangling index entries and logs are found the same way fsck finds them.
+	var report FsckReport
+	if err := d.fsckIndexes(&report, !opts.DryRun); err != nil {
+		return res, err
+	}
+	if err := d.fsckLogs(&report, !opts.DryRun); err != nil {
+		return res, err
+	}
+	for _, issue := range report.Issues {
+		switch issue.Kind {
+		case FsckDanglingIndex:
+			res.IndexEntriesDeleted++
+		case FsckDanglingLog:
+			res.LogsDeleted++
+		}
+	}
+	return res, nil
+}
+
+// sweepObjects finds the unreferenced objects written before cutoff and
+// counts the bytes they take up.
+func (d Database) sweepObjects(cutoff time.Time, res *GCResult) ([]gcVictim, error) {
+	var victims []gcVictim
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := []byte(prefixObject)
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			res.ObjectsScanned++
+			hash := item.KeyCopy(nil)[len(prefix):]
+			hexHash := hex.EncodeToString(hash)
+			if prefixExists(txn, idxResourceByObjectPrefix(hexHash)) || prefixExists(txn, idxObjectByChunkPrefix(hexHash)) {
+				res.ObjectsReferenced++
+				continue
+			}
+
+			written, err := objectWrittenAt(txn, hash)
+			if err != nil {
+				return err
+			}
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			store, err := d.storeFor(val)
+			if err != nil {
+				return err
+			}
+			victim := gcVictim{hash: hash, store: store}
+			size := int64(len(val))
+			if store != nil {
+				info, err := store.Stat(hexHash)
+				switch {
+				case err == nil:
+					size = info.Size
+					if info.ModTime.After(written) {
+						written = info.ModTime
+					}
+				case errors.Is(err, fs.ErrNotExist):
+					size = 0
+				default:
+					return err
+				}
+			}
+			if written.After(cutoff) {
+				res.ObjectsInGrace++
+				continue
+			}
+			if size > 0 {
+				if victim.chunks, err = d.chunkRefs(hexHash, val, item.UserMeta()); err != nil {
+					return fmt.Errorf("failed to read chunk list of %s: %w", hexHash, err)
+				}
+			}
+
+			victims = append(victims, victim)
+			res.ObjectsDeleted++
+			res.BytesReclaimed += size
+			if store != nil && size > 0 {
+				res.FilesDeleted++
+			}
+		}
+		return nil
+	})
+	return victims, err
+}
+
+// objectWrittenAt returns when an object was last stored. Objects stored
+// before write times were recorded count as written at the zero time.
+func objectWrittenAt(txn *badger.Txn, hash []byte) (time.Time, error) {
+	val, err := getVal(txn, objectTimeKey(hash))
+	if err != nil || val == nil {
+		return time.Time{}, err
+	}
+	written, err := time.Parse(time.RFC3339, string(val))
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return written, nil
+}
+
+// deleteObjects removes victims' records, then their stored content.
+func (d Database) deleteObjects(victims []gcVictim) error {
+	for i := 0; i < len(victims); i += writeBatchSize {
+		chunk := victims[i:min(i+writeBatchSize, len(victims))]
+		keys := make([][]byte, 0, 2*len(chunk))
+		for _, v := range chunk {
+			keys = append(keys, objectKey(v.hash), objectTimeKey(v.hash))
+		}
+		if err := d.deleteKeys(keys); err != nil {
+			return fmt.Errorf("failed to delete objects: %w", err)
+		}
+		for _, v := range chunk {
+			if v.store == nil {
+				continue
+			}
+			if err := v.store.Delete(hex.EncodeToString(v.hash)); err != nil {
+				return fmt.Errorf("failed to delete object file: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+// sweepStore removes what no object record points at from a store: partial
+// writes left by interrupted puts, and objects whose record is gone or names
+// another store. A stored object that is still referenced is kept for fsck
+// to restore.
+func (d Database) sweepStore(store objstore.Store, cutoff time.Time, dryRun bool, res *GCResult) error {
+	return store.List(func(info objstore.Info) error {
+		if info.ModTime.After(cutoff) {
+			return nil
+		}
+		if info.Hash == "" && !info.Partial {
+			// Not something grit wrote; leave it alone.
+			return nil
+		}
+
+		if info.Hash != "" {
+			hash, err := hex.DecodeString(info.Hash)
+			if err != nil {
+				return err
+			}
+			var keep bool
+			err = d.badgerDB.View(func(txn *badger.Txn) error {
+				val, err := getVal(txn, objectKey(hash))
+				// On a dry run the objects swept above still have their
+				// records and were already counted.
+				keep = string(val) == store.Name() || prefixExists(txn, idxResourceByObjectPrefix(info.Hash)) ||
+					prefixExists(txn, idxObjectByChunkPrefix(info.Hash))
+				return err
+			})
+			if err != nil || keep {
+				return err
+			}
+		}
+
+		res.FilesDeleted++
+		res.BytesReclaimed += info.Size
+		if dryRun {
+			return nil
+		}
+		return store.Delete(info.Key)
+	})
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"grit/types"
+)
+
+func TestGC(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	_, kept, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("kept")))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	small, err := database.StoreObjectAndGetHash([]byte("unreferenced"))
+	if err != nil {
+		t.Fatalf("StoreObjectAndGetHash(small) error = %v", err)
+	}
+	big, err := database.StoreObjectAndGetHash(randomBytes(t, fsObjectThreshold))
+	if err != nil {
+		t.Fatalf("StoreObjectAndGetHash(big) error = %v", err)
+	}
+
+	res, err := database.GC(GCOptions{Grace: time.Hour})
+	if err != nil {
+		t.Fatalf("GC(grace) error = %v", err)
+	}
+	if res.ObjectsInGrace != 2 || res.ObjectsDeleted != 0 {
+		t.Errorf("GC(grace) = %+v, want 2 objects kept in grace", res)
+	}
+
+	res, err = database.GC(GCOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("GC(dry run) error = %v", err)
+	}
+	want := int64(len(encodeObject([]byte("unreferenced"), types.CompressAuto)) + len(encodeObject(randomBytes(t, fsObjectThreshold), types.CompressAuto)))
+	if res.ObjectsDeleted != 2 || res.FilesDeleted != 1 || res.BytesReclaimed != want {
+		t.Errorf("GC(dry run) = %+v, want 2 objects, 1 file and %d bytes", res, want)
+	}
+	if !database.ObjectExists(small) || !database.ObjectExists(big) {
+		t.Fatalf("GC(dry run) deleted objects")
+	}
+
+	if _, err := database.GC(GCOptions{}); err != nil {
+		t.Fatalf("GC() error = %v", err)
+	}
+	if database.ObjectExists(small) || database.ObjectExists(big) {
+		t.Errorf("GC() kept unreferenced objects")
+	}
+	if _, err := os.Stat(database.objectFilePath(big)); !os.IsNotExist(err) {
+		t.Errorf("GC() kept the object file: %v", err)
+	}
+	if !database.ObjectExists(kept) {
+		t.Errorf("GC() deleted a referenced object")
+	}
+}
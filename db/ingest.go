@@ -9,10 +9,26 @@ import (
 	badger "github.com/dgraph-io/badger/v4"
 )
 
-// IngestFile reads a file from disk, hashes it, routes blob storage by size,
-// and creates a Resource record in BadgerDB. Idempotent: duplicate (name, hash)
-// pairs are silently skipped.
-func (d *Database) IngestFile(path, name, taskID string) error {
+// IngestFile reads a file from disk, hashes it, compresses and chunks it as
+// the task's step asks, routes blob storage by size, and creates a Resource
+// record in BadgerDB. Idempotent: duplicate (name, hash)
+// pairs are silently skipped, and keep the labels they were first created with.
+func (d *Database) IngestFile(path, name, taskID string, labels map[string]string) error {
+	step := d.stepForTask(taskID)
+	if step.Chunk {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed to read output file %s: %w", path, err)
+		}
+		if info.Size() >= chunkMinObjectSize {
+			hash, backend, size, err := d.storeChunkedFile(path, step.Compress)
+			if err != nil {
+				return fmt.Errorf("failed to store object for %s: %w", name, err)
+			}
+			return d.insertResource(name, hash, taskID, backend, size, labels)
+		}
+	}
+
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return fmt.Errorf("failed to read output file %s: %w", path, err)
@@ -21,21 +37,46 @@ func (d *Database) IngestFile(path, name, taskID string) error {
 	h := sha256.Sum256(data)
 	hash := hex.EncodeToString(h[:])
 
-	if err := d.StoreObject(hash, data); err != nil {
+	backend, err := d.storeObject(hash, data, step.Compress)
+	if err != nil {
 		return fmt.Errorf("failed to store object for %s: %w", name, err)
 	}
 
-	backend := d.StorageBackendForSize(len(data))
-	return d.insertResource(name, hash, taskID, backend)
+	return d.insertResource(name, hash, taskID, backend, int64(len(data)), labels)
+}
+
+// stepForTask returns the step a task belongs to, for its storage settings.
+// The zero Step, which stores with the defaults, stands in when it cannot be
+// found.
+func (d *Database) stepForTask(taskID string) Step {
+	var step Step
+	_ = d.badgerDB.View(func(txn *badger.Txn) error {
+		t, err := getEntity[Task](txn, taskKey(taskID))
+		if err != nil || t == nil {
+			return err
+		}
+		s, err := getEntity[Step](txn, stepKey(t.StepID))
+		if err != nil || s == nil {
+			return err
+		}
+		step = *s
+		return nil
+	})
+	return step
 }
 
-func (d *Database) insertResource(name, hash, taskID, backend string) error {
-	return d.badgerDB.Update(func(txn *badger.Txn) error {
+func (d *Database) insertResource(name, hash, taskID, backend string, size int64, labels map[string]string) error {
+	var created *Resource
+	runID := d.currentRunID()
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
 		hashIdxKey := idxResourceHashKey(name, hash)
 		existing, err := getVal(txn, hashIdxKey)
 		if err != nil {
 			return err
 		}
+		if err := recordProducerTxn(txn, name, taskID); err != nil {
+			return err
+		}
 		if existing != nil {
 			return nil // already exists, idempotent
 		}
@@ -48,6 +89,9 @@ func (d *Database) insertResource(name, hash, taskID, backend string) error {
 			CreatedAt:       nowTimestamp(),
 			CreatedByTaskID: &taskID,
 			StorageBackend:  backend,
+			Size:            size,
+			Labels:          labels,
+			RunID:           runID,
 		}
 
 		if err := putEntity(txn, resourceKey(id), &res); err != nil {
@@ -59,6 +103,19 @@ func (d *Database) insertResource(name, hash, taskID, backend string) error {
 		if err := txn.Set(hashIdxKey, []byte(id)); err != nil {
 			return err
 		}
+		if err := txn.Set(idxResourceByObjectKey(hash, id), nil); err != nil {
+			return err
+		}
+		if runID != "" {
+			if err := txn.Set(idxResourceByRunKey(runID, id), nil); err != nil {
+				return err
+			}
+		}
+		created = &res
 		return nil
 	})
+	if err == nil && created != nil {
+		d.publish(Event{Kind: EventResourceCreated, Resource: created})
+	}
+	return err
 }
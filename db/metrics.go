@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+
+	"grit/metrics"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+var (
+	resourcesCreated  = metrics.NewCounterVec("grit_resources_created_total", "Resources created, by name.", "name")
+	objectBytesStored = metrics.NewCounterVec("grit_object_bytes_stored_total", "Object bytes written, by storage backend (inline or fs).", "backend")
+	vlogGCRuns        = metrics.NewCounterVec("grit_badger_vlog_gc_runs_total", "Value log GC runs, by result (rewritten, nothing or error).", "result")
+
+	queueDepth = metrics.NewGaugeFunc("grit_queue_depth", "Unprocessed tasks of the latest version of each step.", "step")
+	badgerSize = metrics.NewGaugeFunc("grit_badger_size_bytes", "On-disk size of Badger's LSM tree and value log.", "part")
+)
+
+// ExportMetrics makes the queue depth and Badger size gauges read from this
+// database.
+func (d Database) ExportMetrics() {
+	queueDepth.Set(func() []metrics.Sample {
+		steps, err := d.ListLatestSteps()
+		if err != nil {
+			dbLogger.Error("Failed to list steps for metrics", "error", err)
+			return nil
+		}
+		samples := make([]metrics.Sample, 0, len(steps))
+		for _, step := range steps {
+			pending, err := d.CountUnprocessedTasksForStep(step.ID)
+			if err != nil {
+				dbLogger.Error("Failed to count tasks for metrics", "step", step.Name, "error", err)
+				continue
+			}
+			samples = append(samples, metrics.Sample{Labels: []string{step.Name}, Value: float64(pending)})
+		}
+		return samples
+	})
+
+	badgerSize.Set(func() []metrics.Sample {
+		lsm, vlog := d.badgerDB.Size()
+		return []metrics.Sample{
+			{Labels: []string{"lsm"}, Value: float64(lsm)},
+			{Labels: []string{"vlog"}, Value: float64(vlog)},
+		}
+	})
+}
+
+// recordVlogGC counts one RunValueLogGC call.
+func recordVlogGC(err error) {
+	switch {
+	case err == nil:
+		vlogGCRuns.Inc("rewritten")
+	case errors.Is(err, badger.ErrNoRewrite):
+		vlogGCRuns.Inc("nothing")
+	default:
+		vlogGCRuns.Inc("error")
+	}
+}
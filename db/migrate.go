@@ -0,0 +1,266 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// migration brings the database from version-1 to version by visiting
+// every key under prefix.
+type migration struct {
+	version     int
+	description string
+	prefix      string
+	// apply migrates one record, writing through txn. It reports whether it
+	// changed anything and must be safe to run again on a record it has
+	// already migrated.
+	apply func(txn *badger.Txn, key, val []byte) (bool, error)
+}
+
+// migrations are applied in order. Append new ones with the next version;
+// never change one that has shipped.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "index failed tasks by step",
+		prefix:      idxTaskByStepProc,
+		apply:       migrateFailedIndex,
+	},
+	{
+		version:     2,
+		description: "index resources by object",
+		prefix:      prefixResource,
+		apply:       migrateObjectRefIndex,
+	},
+}
+
+// SchemaVersion is the version of the key layout and entity encoding this
+// build of grit reads and writes.
+var SchemaVersion = migrations[len(migrations)-1].version
+
+// migrationBatchSize is how many records a migration handles per
+// transaction. Progress is committed with each batch.
+const migrationBatchSize = writeBatchSize
+
+// MigrationResult is what one migration did, or would do on a dry run.
+type MigrationResult struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Scanned     int64  `json:"scanned"`
+	Changed     int64  `json:"changed"`
+	// Resumed marks a migration that continued from an earlier, interrupted
+	// attempt; Scanned and Changed cover only this attempt.
+	Resumed bool `json:"resumed,omitempty"`
+}
+
+// checkRepoFormat refuses repos written before the BadgerDB-only layout,
+// which cannot be migrated.
+func checkRepoFormat(repoPath string) error {
+	for _, dir := range []string{"sqlite", "objects_db"} {
+		if _, err := os.Stat(filepath.Join(repoPath, dir)); err == nil {
+			return fmt.Errorf("%s was written by a version of grit that used SQLite (it has a %s/ directory); that format cannot be migrated, re-run the pipeline into a new repo", repoPath, dir)
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the schema version the database is at.
+func (d Database) SchemaVersion() (int, error) {
+	var version int
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		val, err := getVal(txn, metaSchemaVersionKey())
+		if err != nil || val == nil {
+			return err
+		}
+		version, err = strconv.Atoi(string(val))
+		return err
+	})
+	return version, err
+}
+
+// initSchemaVersion records the schema version of a database opened for the
+// first time since versions were recorded. A new database is current; one
+// that already holds data is at version 0.
+func (d Database) initSchemaVersion() error {
+	return d.badgerDB.Update(func(txn *badger.Txn) error {
+		if keyExists(txn, metaSchemaVersionKey()) {
+			return nil
+		}
+		version := SchemaVersion
+		for _, prefix := range []string{prefixStep, prefixTask, prefixResource, prefixObject} {
+			if prefixExists(txn, []byte(prefix)) {
+				version = 0
+				break
+			}
+		}
+		return txn.Set(metaSchemaVersionKey(), []byte(strconv.Itoa(version)))
+	})
+}
+
+// checkSchemaVersion refuses databases written by a newer grit.
+func (d Database) checkSchemaVersion() (int, error) {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("the repo has schema version %d but this grit only understands up to %d; upgrade grit", version, SchemaVersion)
+	}
+	return version, nil
+}
+
+// PendingMigrations returns the migrations the database still needs.
+func (d Database) PendingMigrations() ([]MigrationResult, error) {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	var pending []MigrationResult
+	for _, m := range migrations {
+		if m.version > version {
+			pending = append(pending, MigrationResult{Version: m.version, Description: m.description})
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies the pending migrations in order. Each walks its key range
+// in batches and commits its position with every batch, so one that is
+// interrupted resumes where it stopped the next time. With dryRun nothing is
+// written and the results count what would change.
+func (d Database) Migrate(dryRun bool) ([]MigrationResult, error) {
+	version, err := d.checkSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	var results []MigrationResult
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		res, err := d.runMigration(m, dryRun)
+		results = append(results, res)
+		if err != nil {
+			return results, fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		if !dryRun {
+			dbLogger.Info("Migrated database", "version", m.version, "migration", m.description,
+				"scanned", res.Scanned, "changed", res.Changed)
+		}
+	}
+	return results, nil
+}
+
+func (d Database) runMigration(m migration, dryRun bool) (MigrationResult, error) {
+	res := MigrationResult{Version: m.version, Description: m.description}
+	prefix := []byte(m.prefix)
+	cursorKey := metaMigrationCursorKey(m.version)
+
+	seek := prefix
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		cursor, err := getVal(txn, cursorKey)
+		if cursor != nil {
+			seek, res.Resumed = cursor, true
+		}
+		return err
+	})
+	if err != nil {
+		return res, err
+	}
+
+	for {
+		txn := d.badgerDB.NewTransaction(true)
+		n, next, err := migrateBatch(txn, m, prefix, seek, &res)
+		if err == nil && n > 0 && !dryRun {
+			if err = txn.Set(cursorKey, next); err == nil {
+				err = txn.Commit()
+			}
+		}
+		txn.Discard()
+		if err != nil {
+			return res, err
+		}
+		if n == 0 {
+			break
+		}
+		seek = next
+	}
+
+	if dryRun {
+		return res, nil
+	}
+	return res, d.badgerDB.Update(func(txn *badger.Txn) error {
+		if err := txn.Delete(cursorKey); err != nil {
+			return err
+		}
+		return txn.Set(metaSchemaVersionKey(), []byte(strconv.Itoa(m.version)))
+	})
+}
+
+// migrateBatch applies m to up to migrationBatchSize records from seek on
+// and returns how many it visited and where the next batch starts.
+func migrateBatch(txn *badger.Txn, m migration, prefix, seek []byte, res *MigrationResult) (int, []byte, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	var n int
+	var next []byte
+	for it.Seek(seek); it.ValidForPrefix(prefix) && n < migrationBatchSize; it.Next() {
+		item := it.Item()
+		key := item.KeyCopy(nil)
+		val, err := item.ValueCopy(nil)
+		if err != nil {
+			return n, nil, err
+		}
+		changed, err := m.apply(txn, key, val)
+		if err != nil {
+			return n, nil, fmt.Errorf("%s: %w", printableKey(key), err)
+		}
+		n++
+		res.Scanned++
+		if changed {
+			res.Changed++
+		}
+		next = append(key, 0)
+	}
+	return n, next, nil
+}
+
+// migrateFailedIndex adds a task that failed before idxTaskByStepFailed
+// existed to it.
+func migrateFailedIndex(txn *badger.Txn, key, _ []byte) (bool, error) {
+	_, taskID, ok := strings.Cut(string(key[len(idxTaskByStepProc):]), "\x00")
+	if !ok {
+		return false, nil
+	}
+	t, err := getEntity[Task](txn, taskKey(taskID))
+	if err != nil || t == nil || t.Error == nil {
+		return false, err
+	}
+	failedKey := idxTaskByStepFailedKey(t.StepID, t.ID)
+	if keyExists(txn, failedKey) {
+		return false, nil
+	}
+	return true, txn.Set(failedKey, nil)
+}
+
+// migrateObjectRefIndex adds a resource created before idxResourceByObject
+// existed to it.
+func migrateObjectRefIndex(txn *badger.Txn, _, val []byte) (bool, error) {
+	var r Resource
+	if err := decode(val, &r); err != nil {
+		return false, err
+	}
+	refKey := idxResourceByObjectKey(r.ObjectHash, r.ID)
+	if keyExists(txn, refKey) {
+		return false, nil
+	}
+	return true, txn.Set(refKey, nil)
+}
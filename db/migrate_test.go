@@ -0,0 +1,160 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	if version, err := database.SchemaVersion(); err != nil || version != SchemaVersion {
+		t.Fatalf("SchemaVersion() = %d, %v; want a new repo at %d", version, err, SchemaVersion)
+	}
+
+	// Make the database look like it predates the last migration.
+	var ids []string
+	var unindexed [][]byte
+	for _, name := range []string{"a", "b", "c"} {
+		id, hash, err := database.CreateResourceFromReader(name, bytes.NewReader([]byte("content of "+name)))
+		if err != nil {
+			t.Fatalf("CreateResourceFromReader(%s) error = %v", name, err)
+		}
+		ids = append(ids, id)
+		unindexed = append(unindexed, idxResourceByObjectKey(hash, id))
+	}
+	sort.Strings(ids)
+	if err := database.deleteKeys(unindexed); err != nil {
+		t.Fatal(err)
+	}
+	setSchemaVersion(t, database, SchemaVersion-1)
+	database.Close()
+
+	database, err = OpenUnmigrated(dir, Options{})
+	if err != nil {
+		t.Fatalf("OpenUnmigrated() error = %v", err)
+	}
+	pending, err := database.PendingMigrations()
+	if err != nil || len(pending) != 1 || pending[0].Version != SchemaVersion {
+		t.Fatalf("PendingMigrations() = %+v, %v; want migration %d", pending, err, SchemaVersion)
+	}
+
+	results, err := database.Migrate(true)
+	if err != nil || len(results) != 1 || results[0].Changed != 3 {
+		t.Fatalf("Migrate(dry run) = %+v, %v; want 3 changes", results, err)
+	}
+	if version, _ := database.SchemaVersion(); version != SchemaVersion-1 || countKeys(t, database, unindexed) != 0 {
+		t.Fatalf("Migrate(dry run) wrote to the database")
+	}
+
+	// Resume as if a run had been interrupted after the first resource.
+	err = database.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaMigrationCursorKey(SchemaVersion), append(resourceKey(ids[0]), 0))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = database.Migrate(false)
+	if err != nil || len(results) != 1 || !results[0].Resumed || results[0].Scanned != 2 {
+		t.Fatalf("Migrate(resumed) = %+v, %v; want the last 2 resources scanned", results, err)
+	}
+	if version, _ := database.SchemaVersion(); version != SchemaVersion {
+		t.Errorf("SchemaVersion() after Migrate = %d, want %d", version, SchemaVersion)
+	}
+	if n := countKeys(t, database, unindexed); n != 2 {
+		t.Errorf("Migrate(resumed) indexed %d resources, want 2", n)
+	}
+
+	// A repo written by a newer grit is refused.
+	setSchemaVersion(t, database, SchemaVersion+1)
+	database.Close()
+	if _, err := NewDatabase(dir); err == nil {
+		t.Errorf("NewDatabase(newer schema) succeeded, want an error")
+	}
+
+	dir = t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sqlite"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewDatabase(dir); err == nil {
+		t.Errorf("NewDatabase(sqlite repo) succeeded, want an error")
+	}
+}
+
+func TestMigrateObjectRefIndex(t *testing.T) {
+	tmp := t.TempDir()
+	database, err := NewDatabase(tmp)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+
+	payload := []byte("indexed later")
+	resourceA, hash, err := database.CreateResourceFromReader("name-a", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader(name-a) error = %v", err)
+	}
+	resourceB, _, err := database.CreateResourceFromReader("name-b", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader(name-b) error = %v", err)
+	}
+	// Make the database look like it predates the index.
+	err = database.deleteKeys([][]byte{
+		idxResourceByObjectKey(hash, resourceA),
+		idxResourceByObjectKey(hash, resourceB),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSchemaVersion(t, database, 1)
+	database.Close()
+
+	database, err = NewDatabase(tmp)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	result, err := database.DeleteResourceHard(resourceA)
+	if err != nil {
+		t.Fatalf("DeleteResourceHard(resourceA) error = %v", err)
+	}
+	if result.ObjectDeleted || result.RemainingObjectRefs != 1 {
+		t.Fatalf("DeleteResourceHard(resourceA) = %+v, want the object kept with 1 reference", result)
+	}
+}
+
+func setSchemaVersion(t *testing.T, d Database, version int) {
+	t.Helper()
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(metaSchemaVersionKey(), []byte(strconv.Itoa(version)))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func countKeys(t *testing.T, d Database, keys [][]byte) int {
+	t.Helper()
+	var n int
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		for _, key := range keys {
+			if keyExists(txn, key) {
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
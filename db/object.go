@@ -1,32 +1,90 @@
 package db
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"sync"
+
+	"grit/objstore"
+	"grit/types"
 
 	badger "github.com/dgraph-io/badger/v4"
+	"github.com/klauspost/compress/zstd"
 )
 
-// fsObjectThreshold is the size above which blobs are stored on the filesystem
+// fsObjectThreshold is the default storage.inline_max, the size above which
+// blobs are stored in an object store (objects/ unless configured otherwise)
 // rather than inline in BadgerDB. 64 KiB keeps small values (domain names,
-// short text) in the LSM tree while shunting large blobs (HTML, images) to disk.
+// short text) in the LSM tree while shunting large blobs (HTML, images) to
+// disk. It applies to the stored, possibly compressed, size.
 const fsObjectThreshold = 64 * 1024
 
-// fsSentinel is stored as the BadgerDB value for objects routed to the filesystem.
-const fsSentinel = "fs"
+// compressMinSize is the smallest object types.CompressAuto compresses.
+// Below it the zstd frame overhead eats most of the gain.
+const compressMinSize = 512
 
-func (d Database) objectFilePath(hash string) string {
-	return filepath.Join(d.repo_path, "objects", hash[0:3], hash[3:6], hash[6:9], hash[9:])
-}
+// objectMetaEncoded is set as the Badger user meta of objects whose content
+// (the value, or the file for fs objects) starts with an object header.
+// Objects stored before compression existed have no header.
+const objectMetaEncoded byte = 1
+
+// Codecs recorded in the object header.
+const (
+	codecNone byte = 0
+	codecZstd byte = 1
+	// codecChunked objects hold a chunk list; see encodeChunkList.
+	codecChunked byte = 2
+)
+
+// maxObjectHeaderLen is the codec byte plus the uncompressed size as a
+// uvarint.
+const maxObjectHeaderLen = 1 + binary.MaxVarintLen64
 
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			panic(err)
+		}
+		return enc
+	})
+	zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, err := zstd.NewReader(nil)
+		if err != nil {
+			panic(err)
+		}
+		return dec
+	})
+)
+
+// StoreObject stores data under hash, compressing it if that is worthwhile.
 func (d Database) StoreObject(hash string, data []byte) error {
-	if len(data) >= fsObjectThreshold {
-		return d.storeObjectFS(hash, data)
+	_, err := d.storeObject(hash, data, types.CompressAuto)
+	return err
+}
+
+// storeObject stores data under hash with the given compression policy and
+// returns the storage backend it went to.
+func (d Database) storeObject(hash string, data []byte, compress string) (string, error) {
+	encoded := encodeObject(data, compress)
+	if len(encoded) >= int(d.config.Storage.InlineMax) {
+		backend, err := d.storeObjectExternal(hash, encoded)
+		if err != nil {
+			return "", err
+		}
+		objectBytesStored.Add(float64(len(encoded)), backend)
+		return backend, nil
 	}
-	return d.storeObjectBadger(hash, data)
+	if err := d.storeObjectBadger(hash, encoded); err != nil {
+		return "", err
+	}
+	objectBytesStored.Add(float64(len(encoded)), types.StorageBackendInline)
+	return types.StorageBackendInline, nil
 }
 
 func (d Database) storeObjectBadger(hash string, data []byte) error {
@@ -36,43 +94,100 @@ func (d Database) storeObjectBadger(hash string, data []byte) error {
 	}
 	wb := d.badgerDB.NewWriteBatch()
 	defer wb.Cancel()
-	if err := wb.Set(objectKey(hashBytes), data); err != nil {
+	if err := wb.SetEntry(badger.NewEntry(objectKey(hashBytes), data).WithMeta(objectMetaEncoded)); err != nil {
+		return err
+	}
+	if err := wb.Set(objectTimeKey(hashBytes), []byte(nowTimestamp())); err != nil {
 		return err
 	}
 	return wb.Flush()
 }
 
-func (d Database) storeObjectFS(hash string, data []byte) error {
-	path := d.objectFilePath(hash)
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
-		return fmt.Errorf("failed to create object dir: %w", err)
-	}
-	// Write to temp file then rename for atomicity.
-	tmp := path + ".tmp"
-	if err := os.WriteFile(tmp, data, 0444); err != nil {
-		return fmt.Errorf("failed to write object file: %w", err)
-	}
-	if err := os.Rename(tmp, path); err != nil {
-		return fmt.Errorf("failed to rename object file: %w", err)
+// storeObjectExternal puts data in the object store new objects go to and
+// returns the store's name.
+func (d Database) storeObjectExternal(hash string, data []byte) (string, error) {
+	store := d.putStore()
+	if err := store.Put(hash, data); err != nil {
+		return "", err
 	}
-	// Record sentinel in BadgerDB so we know where to fetch from.
+	// Record the store's name in BadgerDB so we know where to fetch from.
 	hashBytes, err := hex.DecodeString(hash)
 	if err != nil {
-		return err
+		return "", err
 	}
 	wb := d.badgerDB.NewWriteBatch()
 	defer wb.Cancel()
-	if err := wb.Set(objectKey(hashBytes), []byte(fsSentinel)); err != nil {
-		return err
+	if err := wb.SetEntry(badger.NewEntry(objectKey(hashBytes), []byte(store.Name())).WithMeta(objectMetaEncoded)); err != nil {
+		return "", err
 	}
-	return wb.Flush()
+	if err := wb.Set(objectTimeKey(hashBytes), []byte(nowTimestamp())); err != nil {
+		return "", err
+	}
+	return store.Name(), wb.Flush()
 }
 
+// encodeObject prefixes data with an object header, compressing it first
+// when the policy asks for it and it comes out smaller.
+func encodeObject(data []byte, compress string) []byte {
+	header := make([]byte, 1, maxObjectHeaderLen)
+	header = binary.AppendUvarint(header, uint64(len(data)))
+
+	if compress == types.CompressZstd || (compress == types.CompressAuto && len(data) >= compressMinSize) {
+		header[0] = codecZstd
+		out := zstdEncoder().EncodeAll(data, header)
+		if len(out) < len(header)+len(data) {
+			return out
+		}
+	}
+	header[0] = codecNone
+	return append(header, data...)
+}
+
+// decodeObject returns the content of a stored object. Objects without
+// objectMetaEncoded are stored as is. Chunked objects are read with
+// readObject instead.
+func decodeObject(stored []byte, meta byte) ([]byte, error) {
+	if meta&objectMetaEncoded == 0 {
+		return stored, nil
+	}
+	codec, size, body, err := parseObjectHeader(stored)
+	if err != nil {
+		return nil, err
+	}
+	switch codec {
+	case codecNone:
+		return body, nil
+	case codecZstd:
+		data, err := zstdDecoder().DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress object: %w", err)
+		}
+		if uint64(len(data)) != size {
+			return nil, fmt.Errorf("object decompressed to %d bytes, header says %d", len(data), size)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("unknown object codec %d", codec)
+}
+
+func parseObjectHeader(stored []byte) (codec byte, size uint64, body []byte, err error) {
+	if len(stored) == 0 {
+		return 0, 0, nil, errors.New("object header is missing")
+	}
+	size, n := binary.Uvarint(stored[1:])
+	if n <= 0 {
+		return 0, 0, nil, errors.New("object header is corrupt")
+	}
+	return stored[0], size, stored[1+n:], nil
+}
+
+// StorageBackendForSize returns where an object of size bytes would be stored
+// if it were not compressed.
 func (d Database) StorageBackendForSize(size int) string {
-	if size >= fsObjectThreshold {
-		return "fs"
+	if size >= int(d.config.Storage.InlineMax) {
+		return d.putStore().Name()
 	}
-	return "inline"
+	return types.StorageBackendInline
 }
 
 func (d Database) StoreObjectAndGetHash(data []byte) (string, error) {
@@ -84,29 +199,248 @@ func (d Database) StoreObjectAndGetHash(data []byte) (string, error) {
 	return hashStr, nil
 }
 
+// GetObject returns the content of an object, decompressed and, for chunked
+// objects, reassembled.
 func (d Database) GetObject(hash string) ([]byte, error) {
-	hashBytes, err := hex.DecodeString(hash)
+	val, meta, err := d.objectValue(hash)
+	if err != nil {
+		return nil, err
+	}
+	return d.readObject(hash, val, meta)
+}
+
+// OpenObject returns a reader over the content of an object. Chunked objects
+// and files in an object store, compressed or not, are streamed rather than
+// read into memory. Every reader it returns also implements io.Seeker.
+func (d Database) OpenObject(hash string) (io.ReadCloser, error) {
+	val, meta, err := d.objectValue(hash)
+	if err != nil {
+		return nil, err
+	}
+	return d.openObject(hash, val, meta)
+}
+
+// openObject is OpenObject given the object's Badger value and user meta.
+func (d Database) openObject(hash string, val []byte, meta byte) (io.ReadCloser, error) {
+	store, err := d.storeFor(val)
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return d.openStored(hash, val, meta)
+	}
+
+	f, err := store.Open(hash)
+	if err != nil {
+		return nil, err
+	}
+	if meta&objectMetaEncoded == 0 {
+		return f, nil
+	}
+	header := make([]byte, maxObjectHeaderLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		f.Close()
+		return nil, err
+	}
+	codec, size, body, err := parseObjectHeader(header[:n])
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	bodyStart := int64(n - len(body))
+	if ra, ok := f.(io.ReaderAt); ok && codec == codecNone {
+		return sectionFile{io.NewSectionReader(ra, bodyStart, int64(size)), f}, nil
+	}
+	if codec == codecZstd {
+		return &zstdFileReader{f: f, start: bodyStart, size: int64(size)}, nil
+	}
+
+	stored, err := readAllFrom(f)
+	if err != nil {
+		return nil, err
+	}
+	return d.openStored(hash, stored, meta)
+}
+
+// openStored returns a reader over an object's content given its stored
+// bytes.
+func (d Database) openStored(hash string, stored []byte, meta byte) (io.ReadCloser, error) {
+	refs, err := d.chunkRefs(hash, stored, meta)
+	if err != nil {
+		return nil, err
+	}
+	if refs != nil {
+		_, size, _, _ := parseObjectHeader(stored)
+		return d.newChunkReader(refs, int64(size)), nil
+	}
+	data, err := decodeObject(stored, meta)
 	if err != nil {
 		return nil, err
 	}
+	return bytesReader{bytes.NewReader(data)}, nil
+}
+
+// objectValue returns the Badger value and user meta of an object.
+func (d Database) objectValue(hash string) ([]byte, byte, error) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, 0, err
+	}
 	var val []byte
+	var meta byte
 	err = d.badgerDB.View(func(txn *badger.Txn) error {
 		item, err := txn.Get(objectKey(hashBytes))
 		if err != nil {
 			return err
 		}
+		meta = item.UserMeta()
 		val, err = item.ValueCopy(nil)
 		return err
 	})
+	return val, meta, err
+}
+
+// readObject returns the content of an object given its Badger value and
+// user meta.
+func (d Database) readObject(hash string, val []byte, meta byte) ([]byte, error) {
+	store, err := d.storeFor(val)
 	if err != nil {
 		return nil, err
 	}
-	if string(val) == fsSentinel {
-		return os.ReadFile(d.objectFilePath(hash))
+	if store != nil {
+		f, err := store.Open(hash)
+		if err != nil {
+			return nil, err
+		}
+		if val, err = readAllFrom(f); err != nil {
+			return nil, err
+		}
+	}
+	if meta&objectMetaEncoded != 0 && len(val) > 0 && val[0] == codecChunked {
+		r, err := d.openStored(hash, val, meta)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	return decodeObject(val, meta)
+}
+
+// readAllFrom reads a stored object from the start and closes it.
+func readAllFrom(f io.ReadSeekCloser) ([]byte, error) {
+	defer f.Close()
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
+
+// sectionFile reads part of an open file and closes the file.
+type sectionFile struct {
+	*io.SectionReader
+	io.Closer
+}
+
+// zstdFileReader decompresses a zstd object from a stored file as it is read.
+// Seeking is done by decompressing forward, from the start of the object when
+// the new position is behind the current one.
+type zstdFileReader struct {
+	f     io.ReadSeekCloser
+	start int64 // offset of the compressed body in f
+	size  int64
+
+	dec    *zstd.Decoder
+	pos    int64 // position of dec in the content
+	target int64 // position the next Read starts at
+}
+
+func (r *zstdFileReader) Read(p []byte) (int, error) {
+	if r.target >= r.size {
+		return 0, io.EOF
+	}
+	if r.dec == nil || r.target < r.pos {
+		if err := r.rewind(); err != nil {
+			return 0, err
+		}
 	}
-	return val, nil
+	if r.target > r.pos {
+		skipped, err := io.CopyN(io.Discard, r.dec, r.target-r.pos)
+		r.pos += skipped
+		if err != nil {
+			return 0, r.decodeErr(err)
+		}
+	}
+	if rest := r.size - r.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := r.dec.Read(p)
+	r.pos += int64(n)
+	r.target = r.pos
+	if err != nil {
+		return n, r.decodeErr(err)
+	}
+	return n, nil
 }
 
+// rewind restarts decompression at the start of the object.
+func (r *zstdFileReader) rewind() error {
+	if _, err := r.f.Seek(r.start, io.SeekStart); err != nil {
+		return err
+	}
+	var err error
+	if r.dec == nil {
+		r.dec, err = zstd.NewReader(r.f, zstd.WithDecoderConcurrency(1))
+	} else {
+		err = r.dec.Reset(r.f)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decompress object: %w", err)
+	}
+	r.pos = 0
+	return nil
+}
+
+// decodeErr reports an object that ends before the size in its header.
+func (r *zstdFileReader) decodeErr(err error) error {
+	if err == io.EOF {
+		if r.pos == r.size {
+			return io.EOF
+		}
+		return fmt.Errorf("object decompressed to %d bytes, header says %d", r.pos, r.size)
+	}
+	return fmt.Errorf("failed to decompress object: %w", err)
+}
+
+func (r *zstdFileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.target
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.target = offset
+	return offset, nil
+}
+
+func (r *zstdFileReader) Close() error {
+	if r.dec != nil {
+		r.dec.Close()
+	}
+	return r.f.Close()
+}
+
+type bytesReader struct{ *bytes.Reader }
+
+func (bytesReader) Close() error { return nil }
+
 func (d Database) ObjectExists(hash string) bool {
 	hashBytes, err := hex.DecodeString(hash)
 	if err != nil {
@@ -119,10 +453,72 @@ func (d Database) ObjectExists(hash string) bool {
 	return err == nil
 }
 
-func removeObjectFileIfExists(path string) error {
-	err := os.Remove(path)
-	if err == nil || os.IsNotExist(err) {
-		return nil
+// ObjectSize returns the size of an object's content in bytes.
+func (d Database) ObjectSize(hash string) (int64, error) {
+	var size int64
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		size, err = d.objectSizeTxn(txn, hash)
+		return err
+	})
+	return size, err
+}
+
+func (d Database) objectSizeTxn(txn *badger.Txn, hash string) (int64, error) {
+	hashBytes, err := hex.DecodeString(hash)
+	if err != nil {
+		return 0, err
 	}
-	return err
+	item, err := txn.Get(objectKey(hashBytes))
+	if err != nil {
+		return 0, err
+	}
+	encoded := item.UserMeta()&objectMetaEncoded != 0
+	var size int64
+	var store objstore.Store
+	err = item.Value(func(v []byte) error {
+		var err error
+		if store, err = d.storeFor(v); err != nil || store != nil {
+			return err
+		}
+		if !encoded {
+			size = int64(len(v))
+			return nil
+		}
+		_, contentSize, _, err := parseObjectHeader(v)
+		size = int64(contentSize)
+		return err
+	})
+	if err != nil || store == nil {
+		return size, err
+	}
+
+	if !encoded {
+		info, err := store.Stat(hash)
+		if err != nil {
+			return 0, err
+		}
+		return info.Size, nil
+	}
+	f, err := store.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	header := make([]byte, maxObjectHeaderLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	_, contentSize, _, err := parseObjectHeader(header[:n])
+	return int64(contentSize), err
+}
+
+// ResourceSize returns r.Size, falling back to the object size for resources
+// recorded before sizes were stored.
+func (d Database) ResourceSize(r Resource) (int64, error) {
+	if r.Size > 0 {
+		return r.Size, nil
+	}
+	return d.ObjectSize(r.ObjectHash)
 }
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"grit/objstore"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// objectStores are the stores large objects live in, by Name, and the one
+// new objects go to. The repo's objects/ directory is always one of them.
+type objectStores struct {
+	mu     sync.RWMutex
+	byName map[string]objstore.Store
+	put    objstore.Store
+}
+
+// openObjectStores opens objects/ and every store recorded by
+// UseObjectStore.
+func (d Database) openObjectStores() error {
+	layout, err := parseObjectLayout(d.config.Storage.ObjectLayout)
+	if err != nil {
+		return err
+	}
+	local := objstore.NewFS(d.repo_path+"/objects", layout)
+	d.stores.byName = map[string]objstore.Store{local.Name(): local}
+	d.stores.put = local
+
+	return d.badgerDB.View(func(txn *badger.Txn) error {
+		err := prefixScan(txn, []byte(metaObjectStorePrefix), func(key, val []byte) (bool, error) {
+			store, err := objstore.Open(string(val))
+			if err != nil {
+				return false, err
+			}
+			d.stores.byName[store.Name()] = store
+			return true, nil
+		})
+		if err != nil {
+			return err
+		}
+		current, err := getVal(txn, metaObjectStoreCurrentKey())
+		if err != nil || current == nil {
+			return err
+		}
+		if store := d.stores.byName[string(current)]; store != nil {
+			d.stores.put = store
+		}
+		return nil
+	})
+}
+
+// UseObjectStore sends new large objects to the store uri describes, such
+// as s3://bucket/prefix, or back to objects/ for "fs". The choice is
+// recorded, so later opens of the database write there too and can still
+// read objects stored before. Only one store of each kind can be used: once
+// objects went to one bucket, switching to another is refused.
+func (d Database) UseObjectStore(uri string) error {
+	var store objstore.Store
+	if uri == "fs" {
+		store = d.fsStore()
+	} else {
+		var err error
+		if store, err = objstore.Open(uri); err != nil {
+			return err
+		}
+	}
+
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		if store != d.fsStore() {
+			key := metaObjectStoreKey(store.Name())
+			recorded, err := getVal(txn, key)
+			if err != nil {
+				return err
+			}
+			if recorded != nil && string(recorded) != uri {
+				return fmt.Errorf("objects are already stored in %s; cannot switch to %s", recorded, uri)
+			}
+			if err := txn.Set(key, []byte(uri)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(metaObjectStoreCurrentKey(), []byte(store.Name()))
+	})
+	if err != nil {
+		return err
+	}
+
+	d.stores.mu.Lock()
+	defer d.stores.mu.Unlock()
+	if existing := d.stores.byName[store.Name()]; existing != nil {
+		store = existing
+	}
+	d.stores.byName[store.Name()] = store
+	d.stores.put = store
+	return nil
+}
+
+// ObjectStore returns the Name of the store new large objects go to.
+func (d Database) ObjectStore() string {
+	return d.putStore().Name()
+}
+
+func (d Database) putStore() objstore.Store {
+	d.stores.mu.RLock()
+	defer d.stores.mu.RUnlock()
+	return d.stores.put
+}
+
+func (d Database) fsStore() *objstore.FS {
+	d.stores.mu.RLock()
+	defer d.stores.mu.RUnlock()
+	return d.stores.byName["fs"].(*objstore.FS)
+}
+
+// allStores returns every open store, objects/ first.
+func (d Database) allStores() []objstore.Store {
+	d.stores.mu.RLock()
+	defer d.stores.mu.RUnlock()
+	stores := []objstore.Store{d.stores.byName["fs"]}
+	for name, store := range d.stores.byName {
+		if name != "fs" {
+			stores = append(stores, store)
+		}
+	}
+	return stores
+}
+
+// storeFor returns the store an object lives in given its Badger value, or
+// nil if the object is stored inline.
+func (d Database) storeFor(val []byte) (objstore.Store, error) {
+	if len(val) > 8 {
+		return nil, nil
+	}
+	d.stores.mu.RLock()
+	store := d.stores.byName[string(val)]
+	d.stores.mu.RUnlock()
+	if store == nil && slices.Contains(objstore.Names, string(val)) {
+		return nil, fmt.Errorf("object is in an %s store that is not configured", val)
+	}
+	return store, nil
+}
+
+// objectFilePath returns the file an object in objects/ is kept in.
+func (d Database) objectFilePath(hash string) string {
+	return d.fsStore().Path(hash)
+}
+
+// objectLocation describes where an object is kept, for messages.
+func objectLocation(store objstore.Store, key string) string {
+	if fs, ok := store.(*objstore.FS); ok {
+		if !strings.ContainsRune(key, '/') {
+			return fs.Path(key)
+		}
+		return key
+	}
+	return store.Name() + ":" + key
+}
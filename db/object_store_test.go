@@ -0,0 +1,82 @@
+package db
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"grit/objstore/s3test"
+)
+
+func TestObjectStoreS3(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "testsecret")
+
+	dir := t.TempDir()
+	database, err := NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	if err := database.UseObjectStore(server.URI("grit", "repo")); err != nil {
+		t.Fatalf("UseObjectStore(s3) error = %v", err)
+	}
+	if err := database.UseObjectStore(server.URI("other", "repo")); err == nil {
+		t.Errorf("UseObjectStore(another bucket) succeeded, want an error")
+	}
+
+	remote := randomBytes(t, 2*fsObjectThreshold)
+	remoteID, remoteHash, err := database.CreateResourceFromReader("big", bytes.NewReader(remote))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	if server.Len() != 1 {
+		t.Errorf("s3 holds %d objects, want 1", server.Len())
+	}
+	if _, err := os.Stat(database.objectFilePath(remoteHash)); !os.IsNotExist(err) {
+		t.Errorf("object also written to objects/: %v", err)
+	}
+	database.Close()
+
+	// The store is remembered, and objects stay readable after switching
+	// back to objects/.
+	database, err = NewDatabase(dir)
+	if err != nil {
+		t.Fatalf("NewDatabase(reopen) error = %v", err)
+	}
+	defer database.Close()
+	if database.ObjectStore() != "s3" {
+		t.Errorf("ObjectStore() = %q after reopening, want s3", database.ObjectStore())
+	}
+	if err := database.UseObjectStore("fs"); err != nil {
+		t.Fatalf("UseObjectStore(fs) error = %v", err)
+	}
+	local := randomBytes(t, 2*fsObjectThreshold)
+	_, localHash, err := database.CreateResourceFromReader("big", bytes.NewReader(local))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	for hash, want := range map[string][]byte{remoteHash: remote, localHash: local} {
+		got, err := database.GetObject(hash)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("GetObject(%s) = %d bytes, %v; want the %d stored", hash[:8], len(got), err, len(want))
+		}
+		size, err := database.ObjectSize(hash)
+		if err != nil || size != int64(len(want)) {
+			t.Errorf("ObjectSize(%s) = %d, %v; want %d", hash[:8], size, err, len(want))
+		}
+	}
+
+	report, err := database.Fsck(false)
+	if err != nil || len(report.Issues) != 0 || report.Files != 2 {
+		t.Errorf("Fsck() = %+v, %v; want 2 files and no issues", report, err)
+	}
+
+	if _, err := database.DeleteResourceHard(remoteID); err != nil {
+		t.Fatalf("DeleteResourceHard() error = %v", err)
+	}
+	if server.Len() != 0 {
+		t.Errorf("s3 holds %d objects after delete, want 0", server.Len())
+	}
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestObjectCompression(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	text := bytes.Repeat([]byte("id,name,value\n1,grit,42\n"), 4096)
+	// Rows of random numbers compress, but not enough to be kept inline.
+	var big []byte
+	for i, b := range randomBytes(t, 4*fsObjectThreshold) {
+		big = fmt.Appendf(big, "%d,grit,%d\n", i, b)
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		compress string
+		smaller  bool
+		streamed bool
+	}{
+		{"auto compresses text", text, types.CompressAuto, true, false},
+		{"auto leaves small objects", []byte("tiny"), types.CompressAuto, false, false},
+		{"none", text[:2048], types.CompressNone, false, false},
+		{"zstd skips what does not shrink", randomBytes(t, 4096), types.CompressZstd, false, false},
+		{"zstd in the object store", big, types.CompressZstd, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := sha256.Sum256(tt.data)
+			hash := hex.EncodeToString(sum[:])
+			if _, err := database.storeObject(hash, tt.data, tt.compress); err != nil {
+				t.Fatalf("storeObject() error = %v", err)
+			}
+
+			got, err := database.GetObject(hash)
+			if err != nil || !bytes.Equal(got, tt.data) {
+				t.Fatalf("GetObject() = %d bytes, %v; want the %d stored", len(got), err, len(tt.data))
+			}
+			obj, err := database.OpenObject(hash)
+			if err != nil {
+				t.Fatalf("OpenObject() error = %v", err)
+			}
+			defer obj.Close()
+			if _, streamed := obj.(*zstdFileReader); streamed != tt.streamed {
+				t.Errorf("OpenObject() returned a %T, want streamed = %v", obj, tt.streamed)
+			}
+			// Seek forward, then back behind what has been read.
+			for _, offset := range []int64{int64(len(tt.data) / 2), 1} {
+				if _, err := obj.(io.Seeker).Seek(offset, io.SeekStart); err != nil {
+					t.Fatalf("Seek(%d) error = %v", offset, err)
+				}
+				got, err := io.ReadAll(obj)
+				if err != nil || !bytes.Equal(got, tt.data[offset:]) {
+					t.Fatalf("read from %d = %d bytes, %v; want %d", offset, len(got), err, len(tt.data)-int(offset))
+				}
+			}
+
+			size, err := database.ObjectSize(hash)
+			if err != nil || size != int64(len(tt.data)) {
+				t.Errorf("ObjectSize() = %d, %v; want %d", size, err, len(tt.data))
+			}
+
+			encoded := encodeObject(tt.data, tt.compress)
+			if smaller := len(encoded) < len(tt.data); smaller != tt.smaller {
+				t.Errorf("stored %d bytes for %d, want compressed = %v", len(encoded), len(tt.data), tt.smaller)
+			}
+		})
+	}
+
+	// Objects stored before compression have no header.
+	legacy := []byte("stored as is")
+	sum := sha256.Sum256(legacy)
+	err = database.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(objectKey(sum[:]), legacy)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := database.GetObject(hex.EncodeToString(sum[:]))
+	if err != nil || !bytes.Equal(got, legacy) {
+		t.Errorf("GetObject(legacy) = %q, %v; want %q", got, err, legacy)
+	}
+
+	report, err := database.Fsck(false)
+	if err != nil || len(report.Issues) != 0 {
+		t.Errorf("Fsck() = %+v, %v; want no issues", report.Issues, err)
+	}
+}
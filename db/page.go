@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// Task status filters for ListTasksPage.
+const (
+	TaskStatusAll     = ""
+	TaskStatusPending = "pending"
+	TaskStatusDone    = "done"
+	TaskStatusFailed  = "failed"
+	TaskStatusSkipped = "skipped"
+)
+
+// pageKeysTxn calls fn with the ID suffix of each key under prefix that sorts
+// after the cursor, until fn has accepted limit IDs. It returns the last
+// accepted ID as the next cursor when the page filled up, or "" at the end.
+func pageKeysTxn(txn *badger.Txn, prefix []byte, after string, limit int, fn func(id string) (bool, error)) (string, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	start := append(append([]byte{}, prefix...), after...)
+	accepted := 0
+	for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+		id := string(it.Item().Key()[len(prefix):])
+		if id <= after {
+			continue
+		}
+		ok, err := fn(id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		accepted++
+		if accepted == limit {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// ListResourcesPage returns up to limit resources created after the resource
+// ID cursor, oldest first. An empty name lists every resource. The returned
+// cursor is empty once there is nothing more to read.
+func (d Database) ListResourcesPage(name, after string, limit int) ([]Resource, string, error) {
+	prefix := []byte(prefixResource)
+	if name != "" {
+		prefix = idxResourceByNamePrefix(name)
+	}
+
+	var resources []Resource
+	var next string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		next, err = pageKeysTxn(txn, prefix, after, limit, func(id string) (bool, error) {
+			r, err := getEntity[Resource](txn, resourceKey(id))
+			if err != nil || r == nil {
+				return false, err
+			}
+			resources = append(resources, *r)
+			return true, nil
+		})
+		return err
+	})
+	return resources, next, err
+}
+
+// ListTasksPage returns up to limit tasks of a step created after the task ID
+// cursor, filtered by one of the TaskStatus values.
+func (d Database) ListTasksPage(stepID, status, after string, limit int) ([]Task, string, error) {
+	var prefix []byte
+	switch status {
+	case TaskStatusAll:
+		prefix = idxTaskByStepAllPrefix(stepID)
+	case TaskStatusPending:
+		prefix = idxTaskByStepUnprocPrefix(stepID)
+	case TaskStatusDone:
+		prefix = idxTaskByStepProcPrefix(stepID)
+	case TaskStatusFailed:
+		prefix = idxTaskByStepFailedPrefix(stepID)
+	case TaskStatusSkipped:
+		prefix = idxTaskByStepSkippedPrefix(stepID)
+	default:
+		return nil, "", fmt.Errorf("unknown task status %q", status)
+	}
+
+	var tasks []Task
+	var next string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		next, err = pageKeysTxn(txn, prefix, after, limit, func(id string) (bool, error) {
+			t, err := getEntity[Task](txn, taskKey(id))
+			if err != nil || t == nil {
+				return false, err
+			}
+			tasks = append(tasks, *t)
+			return true, nil
+		})
+		return err
+	})
+	return tasks, next, err
+}
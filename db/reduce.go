@@ -0,0 +1,364 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"slices"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// IsNameComplete reports whether no more resources of name can appear without
+// new outside input: every step that has produced the name or lists it in its
+// outputs is quiescent, and so is everything upstream of those steps.
+func (d Database) IsNameComplete(name string) (bool, error) {
+	var complete bool
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		complete, err = nameCompleteTxn(txn, name, make(map[string]bool))
+		return err
+	})
+	return complete, err
+}
+
+// IsStepQuiescent reports whether a step has nothing left to do: no
+// unprocessed tasks, no unscheduled input, and a complete upstream, including
+// the steps it is ordered after.
+func (d Database) IsStepQuiescent(step Step) (bool, error) {
+	var quiescent bool
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		quiescent, err = stepQuiescentTxn(txn, step, map[string]bool{step.Name: true})
+		return err
+	})
+	return quiescent, err
+}
+
+// AreStepsQuiescent reports whether the latest version of every named step is
+// quiescent, and returns the first one that is not. Names with no registered
+// step are treated as quiescent.
+func (d Database) AreStepsQuiescent(names []string) (bool, string, error) {
+	quiescent := true
+	var blocking string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		visited := make(map[string]bool)
+		for _, name := range names {
+			ok, err := namedStepQuiescentTxn(txn, name, visited)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				quiescent, blocking = false, name
+				return nil
+			}
+		}
+		return nil
+	})
+	return quiescent, blocking, err
+}
+
+// namedStepQuiescentTxn checks the latest version of the named step, skipping
+// names already in visited.
+func namedStepQuiescentTxn(txn *badger.Txn, name string, visited map[string]bool) (bool, error) {
+	if visited[name] {
+		return true, nil
+	}
+	visited[name] = true
+
+	step, err := latestStepByNameTxn(txn, name)
+	if err != nil || step == nil {
+		return true, err
+	}
+	return stepQuiescentTxn(txn, *step, visited)
+}
+
+// nameCompleteTxn walks the producers of name: the steps recorded as having
+// produced it, and those declaring it in their outputs, which may not have
+// produced anything yet. visited holds step names that have already been
+// checked (or are being checked further up the stack) so cycles terminate.
+func nameCompleteTxn(txn *badger.Txn, name string, visited map[string]bool) (bool, error) {
+	prefix := idxNameProducerPrefix(name)
+	var producers []string
+	err := prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+		producers = append(producers, string(key[len(prefix):]))
+		return true, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	steps, err := latestStepsTxn(txn)
+	if err != nil {
+		return false, err
+	}
+	for _, step := range steps {
+		if slices.Contains(step.Outputs, name) && !slices.Contains(producers, step.Name) {
+			producers = append(producers, step.Name)
+		}
+	}
+
+	for _, stepName := range producers {
+		quiescent, err := namedStepQuiescentTxn(txn, stepName, visited)
+		if err != nil || !quiescent {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func stepQuiescentTxn(txn *badger.Txn, step Step, visited map[string]bool) (bool, error) {
+	var pending bool
+	err := prefixScanKeys(txn, idxTaskByStepUnprocPrefix(step.ID), func(key []byte) (bool, error) {
+		pending = true
+		return false, nil
+	})
+	if err != nil || pending {
+		return false, err
+	}
+	for _, name := range step.After {
+		quiescent, err := namedStepQuiescentTxn(txn, name, visited)
+		if err != nil || !quiescent {
+			return false, err
+		}
+	}
+	if step.Input == "" {
+		// A seed is quiescent once it has run at least once.
+		var ran bool
+		err := prefixScanKeys(txn, idxTaskByStepAllPrefix(step.ID), func(key []byte) (bool, error) {
+			ran = true
+			return false, nil
+		})
+		return ran, err
+	}
+
+	upstreamComplete, err := nameCompleteTxn(txn, step.Input, visited)
+	if err != nil || !upstreamComplete {
+		return false, err
+	}
+
+	if step.Reduce() {
+		fingerprint, count, err := fingerprintNameTxn(txn, step.Input)
+		if err != nil {
+			return false, err
+		}
+		last, err := getVal(txn, metaReduceFingerprintKey(step.ID))
+		if err != nil {
+			return false, err
+		}
+		return count == 0 || string(last) == fingerprint, nil
+	}
+
+	unscheduled, err := hasUnscheduledInputTxn(txn, step)
+	if err != nil {
+		return false, err
+	}
+	return !unscheduled, nil
+}
+
+// hasUnscheduledInputTxn reports whether any resource of the step's input sits
+// past the step's scheduling watermark. This may report input that was
+// already consumed out of order (by a sampled run) until the next scheduling
+// pass moves the watermark past it, which only delays quiescence.
+func hasUnscheduledInputTxn(txn *badger.Txn, step Step) (bool, error) {
+	cursor, err := scheduleCursorTxn(txn, step)
+	if err != nil {
+		return false, err
+	}
+
+	prefix := idxResourceByNamePrefix(step.Input)
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	opts.PrefetchValues = false
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	it.Seek(cursor)
+	return it.ValidForPrefix(prefix), nil
+}
+
+// fingerprintNameTxn summarises the set of object hashes currently stored
+// under name. Hashes are XOR-folded so the result is independent of order and
+// needs constant memory; the count is mixed in so that adding or removing a
+// resource always changes the fingerprint.
+func fingerprintNameTxn(txn *badger.Txn, name string) (string, int64, error) {
+	prefix := idxResourceHashPrefix(name)
+	var folded [sha256.Size]byte
+	var count int64
+	err := prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+		hash, err := hex.DecodeString(string(key[len(prefix):]))
+		if err != nil {
+			return false, fmt.Errorf("invalid object hash in index key %q: %w", key, err)
+		}
+		for i := 0; i < len(folded) && i < len(hash); i++ {
+			folded[i] ^= hash[i]
+		}
+		count++
+		return true, nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	h := sha256.New()
+	h.Write(folded[:])
+	binary.Write(h, binary.BigEndian, count)
+	return hex.EncodeToString(h.Sum(nil)), count, nil
+}
+
+// FingerprintResourcesByName returns the fingerprint of the current set of
+// resources with the given name, and how many there are.
+func (d Database) FingerprintResourcesByName(name string) (string, int64, error) {
+	var fingerprint string
+	var count int64
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		fingerprint, count, err = fingerprintNameTxn(txn, name)
+		return err
+	})
+	return fingerprint, count, err
+}
+
+// scheduleReduceTaskForStep is ScheduleTasksForStep for reduce steps. Once the
+// input name is complete it creates a single task covering every resource of
+// that name, unless the input set is unchanged since the last reduce task. The
+// task's inputs are recorded under idxTaskInput from the same snapshot it was
+// fingerprinted over, so it runs on exactly that set even if more arrive
+// before it is executed.
+func (d Database) scheduleReduceTaskForStep(step Step) (int64, error) {
+	complete, err := d.IsNameComplete(step.Input)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check completeness of %s: %w", step.Input, err)
+	}
+	if !complete {
+		dbLogger.Debug("Reduce input still being produced", "step", step.Name, "input", step.Input)
+		return 0, nil
+	}
+
+	// The input set can exceed what one transaction may write, so the index
+	// entries go through a write batch while a read transaction holds the
+	// snapshot. The task itself is only created once they are all written.
+	id := newULID()
+	var fingerprint string
+	var last []byte
+	var count int64
+	err = d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		fingerprint, count, err = fingerprintNameTxn(txn, step.Input)
+		if err != nil || count == 0 {
+			return err
+		}
+		last, err = getVal(txn, metaReduceFingerprintKey(step.ID))
+		if err != nil || string(last) == fingerprint {
+			return err
+		}
+
+		wb := d.badgerDB.NewWriteBatch()
+		defer wb.Cancel()
+		prefix := idxResourceByNamePrefix(step.Input)
+		err = prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+			return true, wb.Set(idxTaskInputKey(id, string(key[len(prefix):])), nil)
+		})
+		if err != nil {
+			return err
+		}
+		return wb.Flush()
+	})
+	if err != nil {
+		d.deleteTaskInputs(id)
+		return 0, err
+	}
+	if count == 0 || string(last) == fingerprint {
+		return 0, nil
+	}
+
+	err = d.badgerDB.Update(func(txn *badger.Txn) error {
+		current, err := getVal(txn, metaReduceFingerprintKey(step.ID))
+		if err != nil {
+			return err
+		}
+		if string(current) != string(last) {
+			// Another pass scheduled the step since the snapshot.
+			return badger.ErrConflict
+		}
+
+		task := Task{
+			ID:          id,
+			StepID:      step.ID,
+			Fingerprint: fingerprint,
+			CreatedAt:   nowTimestamp(),
+			RunID:       d.currentRunID(),
+		}
+		if err := putEntity(txn, taskKey(id), &task); err != nil {
+			return err
+		}
+		if err := txn.Set(idxTaskByStepUnprocKey(step.ID, id), nil); err != nil {
+			return err
+		}
+		if err := txn.Set(idxTaskByStepAllKey(step.ID, id), nil); err != nil {
+			return err
+		}
+		return txn.Set(metaReduceFingerprintKey(step.ID), []byte(fingerprint))
+	})
+	if err != nil {
+		d.deleteTaskInputs(id)
+		return 0, err
+	}
+	dbLogger.Debug("Scheduled reduce task", "step", step.Name, "resources", count, "fingerprint", fingerprint[:16])
+	return 1, nil
+}
+
+// ListTaskInputs calls fn with the ID of every input resource of a task:
+// those it names itself, or for a reduce task, the set recorded when it was
+// scheduled. It stops at the first error, from the database or from fn.
+func (d Database) ListTaskInputs(task Task, fn func(resourceID string) error) error {
+	if ids := task.InputIDs(); len(ids) > 0 {
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	prefix := idxTaskInputPrefix(task.ID)
+	after := ""
+	for {
+		var ids []string
+		var next string
+		err := d.badgerDB.View(func(txn *badger.Txn) error {
+			var err error
+			next, err = pageKeysTxn(txn, prefix, after, scanBatchSize, func(id string) (bool, error) {
+				ids = append(ids, id)
+				return true, nil
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := fn(id); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		after = next
+	}
+}
+
+// deleteTaskInputs removes the recorded input set of a reduce task.
+func (d Database) deleteTaskInputs(taskID string) error {
+	var keys [][]byte
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		return prefixScanKeys(txn, idxTaskInputPrefix(taskID), func(key []byte) (bool, error) {
+			keys = append(keys, key)
+			return true, nil
+		})
+	})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	return d.deleteKeys(keys)
+}
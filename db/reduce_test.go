@@ -0,0 +1,142 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+
+	"grit/types"
+)
+
+func TestReduceWaitsForDeclaredProducers(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	for i := range 2 {
+		if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte(fmt.Sprint(i)))); err != nil {
+			t.Fatalf("CreateResourceFromReader(%d) error = %v", i, err)
+		}
+	}
+	scoreID, err := database.CreateStep(Step{Name: "score", Script: "true", Input: "row", Outputs: []string{"score"}})
+	if err != nil {
+		t.Fatalf("CreateStep(score) error = %v", err)
+	}
+	sumID, err := database.CreateStep(Step{Name: "sum", Script: "true", Input: "score", Mode: types.StepModeReduce})
+	if err != nil {
+		t.Fatalf("CreateStep(sum) error = %v", err)
+	}
+	scheduleReduce := func() int64 {
+		t.Helper()
+		n, err := database.ScheduleTasksForStep(sumID)
+		if err != nil {
+			t.Fatalf("ScheduleTasksForStep(sum) error = %v", err)
+		}
+		return n
+	}
+
+	// A score made elsewhere must not let the reduce run while the declared
+	// producer has not even been scheduled.
+	if _, err := database.CreateResource("score", fmt.Sprintf("%064x", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if n := scheduleReduce(); n != 0 {
+		t.Fatalf("reduce scheduled %d tasks before its producer ran, want 0", n)
+	}
+
+	if _, err := database.ScheduleTasksForStep(scoreID); err != nil {
+		t.Fatalf("ScheduleTasksForStep(score) error = %v", err)
+	}
+	if n := scheduleReduce(); n != 0 {
+		t.Fatalf("reduce scheduled %d tasks while its producer had pending tasks, want 0", n)
+	}
+
+	var want []string
+	for task := range database.GetTasksForStep(scoreID) {
+		id, err := database.CreateResourceWithTask("score", fmt.Sprintf("%064x", 2+len(want)), &task.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id)
+		if err := database.UpdateTaskStatus(task.ID, true, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := scheduleReduce(); n != 1 {
+		t.Fatalf("reduce scheduled %d tasks once its producer finished, want 1", n)
+	}
+
+	// The task keeps the set it was fingerprinted over.
+	if _, err := database.CreateResource("score", fmt.Sprintf("%064x", 9)); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []Task
+	for task := range database.GetTasksForStep(sumID) {
+		tasks = append(tasks, task)
+	}
+	if len(tasks) != 1 {
+		t.Fatalf("reduce step has %d tasks, want 1", len(tasks))
+	}
+	var got []string
+	err = database.ListTaskInputs(tasks[0], func(id string) error {
+		got = append(got, id)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListTaskInputs() error = %v", err)
+	}
+	if len(got) != 3 || !slices.Contains(got, want[0]) || !slices.Contains(got, want[1]) {
+		t.Errorf("reduce task inputs = %v, want the 3 scores present when it was scheduled", got)
+	}
+}
+
+func TestReduceInputsExceedValueLimit(t *testing.T) {
+	database, err := Open(t.TempDir(), Options{Overrides: []string{"badger.value_log_file_size=1MiB"}})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer database.Close()
+
+	// 50k resource IDs are more than one value may hold with 1MiB value log
+	// files.
+	const rows = 50_000
+	for i := range rows {
+		if _, err := database.CreateResource("row", fmt.Sprintf("%064x", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stepID, err := database.CreateStep(Step{Name: "sum", Script: "true", Input: "row", Mode: types.StepModeReduce})
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	if n, err := database.ScheduleTasksForStep(stepID); err != nil || n != 1 {
+		t.Fatalf("ScheduleTasksForStep() = %d, %v, want 1 task", n, err)
+	}
+
+	var task Task
+	for task = range database.GetTasksForStep(stepID) {
+	}
+	var inputs int
+	err = database.ListTaskInputs(task, func(string) error {
+		inputs++
+		return nil
+	})
+	if err != nil || inputs != rows {
+		t.Fatalf("ListTaskInputs() listed %d inputs, %v, want %d", inputs, err, rows)
+	}
+
+	if err := database.DeleteTask(task.ID); err != nil {
+		t.Fatalf("DeleteTask() error = %v", err)
+	}
+	inputs = 0
+	database.ListTaskInputs(task, func(string) error {
+		inputs++
+		return nil
+	})
+	if inputs != 0 {
+		t.Errorf("DeleteTask() left %d recorded inputs, want 0", inputs)
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 
+	"grit/objstore"
+
 	badger "github.com/dgraph-io/badger/v4"
 )
 
@@ -24,6 +26,8 @@ func (d Database) CreateResource(name string, objectHash string) (string, error)
 
 func (d Database) CreateResourceWithTask(name string, objectHash string, createdByTaskID *string) (string, error) {
 	var resultID string
+	var created *Resource
+	runID := d.currentRunID()
 	err := d.badgerDB.Update(func(txn *badger.Txn) error {
 		// Check unique constraint: (name, object_hash)
 		hashKey := idxResourceHashKey(name, objectHash)
@@ -31,6 +35,11 @@ func (d Database) CreateResourceWithTask(name string, objectHash string, created
 		if err != nil {
 			return err
 		}
+		if createdByTaskID != nil {
+			if err := recordProducerTxn(txn, name, *createdByTaskID); err != nil {
+				return err
+			}
+		}
 		if existing != nil {
 			resultID = string(existing)
 			return nil // already exists
@@ -43,6 +52,7 @@ func (d Database) CreateResourceWithTask(name string, objectHash string, created
 			ObjectHash:      objectHash,
 			CreatedAt:       nowTimestamp(),
 			CreatedByTaskID: createdByTaskID,
+			RunID:           runID,
 		}
 
 		if err := putEntity(txn, resourceKey(id), &res); err != nil {
@@ -56,13 +66,56 @@ func (d Database) CreateResourceWithTask(name string, objectHash string, created
 		if err := txn.Set(hashKey, []byte(id)); err != nil {
 			return err
 		}
+		if err := txn.Set(idxResourceByObjectKey(objectHash, id), nil); err != nil {
+			return err
+		}
+		if runID != "" {
+			if err := txn.Set(idxResourceByRunKey(runID, id), nil); err != nil {
+				return err
+			}
+		}
 
 		resultID = id
+		created = &res
 		return nil
 	})
+	if err == nil && created != nil {
+		d.publish(Event{Kind: EventResourceCreated, Resource: created})
+	}
 	return resultID, err
 }
 
+// recordProducerTxn marks the step that ran taskID as a producer of name.
+// Resources created outside a task (CSV ingest) have no producer.
+func recordProducerTxn(txn *badger.Txn, name, taskID string) error {
+	if taskID == "" {
+		return nil
+	}
+	task, err := getEntity[Task](txn, taskKey(taskID))
+	if err != nil || task == nil {
+		return err
+	}
+	step, err := getEntity[Step](txn, stepKey(task.StepID))
+	if err != nil || step == nil {
+		return err
+	}
+	return txn.Set(idxNameProducerKey(name, step.Name), nil)
+}
+
+// GetNameProducers returns the names of the steps that have produced at least
+// one resource called name.
+func (d Database) GetNameProducers(name string) ([]string, error) {
+	var producers []string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := idxNameProducerPrefix(name)
+		return prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+			producers = append(producers, string(key[len(prefix):]))
+			return true, nil
+		})
+	})
+	return producers, err
+}
+
 func (d Database) CreateResourceFromReader(name string, reader io.Reader) (string, string, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
@@ -102,62 +155,75 @@ func (d Database) GetResourcesByName(name string) chan Resource {
 	ch := make(chan Resource)
 	go func() {
 		defer close(ch)
-		prefix := idxResourceByNamePrefix(name)
-		// Reverse scan: ULIDs are time-sorted, reverse gives newest first.
-		// cursor starts at the top of the range; skipFirst skips the already-seen
-		// cursor key on each subsequent batch (Seek in reverse lands on the key itself).
-		cursor := append(append([]byte{}, prefix...), 0xFF)
-		skipFirst := false
-		for {
-			var resources []Resource
-			var lastKey []byte
-			exhausted := false
-			err := d.badgerDB.View(func(txn *badger.Txn) error {
-				opts := badger.DefaultIteratorOptions
-				opts.Prefix = prefix
-				opts.Reverse = true
-				opts.PrefetchValues = false
-				it := txn.NewIterator(opts)
-				defer it.Close()
-				it.Seek(cursor)
-				if skipFirst && it.ValidForPrefix(prefix) {
-					it.Next()
+		err := d.ListResourcesByName(name, func(r Resource) error {
+			ch <- r
+			return nil
+		})
+		if err != nil {
+			dbLogger.Error("Failed to query resources by name", "name", name, "error", err)
+		}
+	}()
+	return ch
+}
+
+// ListResourcesByName calls fn with every resource of the given name, newest
+// first. It stops at the first error, from the database or from fn.
+func (d Database) ListResourcesByName(name string, fn func(Resource) error) error {
+	prefix := idxResourceByNamePrefix(name)
+	// Reverse scan: ULIDs are time-sorted, reverse gives newest first.
+	// cursor starts at the top of the range; skipFirst skips the already-seen
+	// cursor key on each subsequent batch (Seek in reverse lands on the key itself).
+	cursor := append(append([]byte{}, prefix...), 0xFF)
+	skipFirst := false
+	for {
+		var resources []Resource
+		var lastKey []byte
+		exhausted := false
+		err := d.badgerDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			opts.Reverse = true
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			it.Seek(cursor)
+			if skipFirst && it.ValidForPrefix(prefix) {
+				it.Next()
+			}
+			var scanned int
+			for ; it.ValidForPrefix(prefix); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				lastKey = key
+				scanned++
+				resID := string(key[len(prefix):])
+				r, err := getEntity[Resource](txn, resourceKey(resID))
+				if err != nil {
+					return err
 				}
-				var scanned int
-				for ; it.ValidForPrefix(prefix); it.Next() {
-					key := it.Item().KeyCopy(nil)
-					lastKey = key
-					scanned++
-					resID := string(key[len(prefix):])
-					r, err := getEntity[Resource](txn, resourceKey(resID))
-					if err != nil {
-						return err
-					}
-					if r != nil {
-						resources = append(resources, *r)
-					}
-					if scanned >= scanBatchSize {
-						return nil
-					}
+				if r != nil {
+					resources = append(resources, *r)
+				}
+				if scanned >= scanBatchSize {
+					return nil
 				}
-				exhausted = true
-				return nil
-			})
-			if err != nil {
-				dbLogger.Verbosef("Error querying resources by name %s: %v\n", name, err)
-				break
-			}
-			for _, r := range resources {
-				ch <- r
 			}
-			if exhausted || lastKey == nil {
-				break
+			exhausted = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, r := range resources {
+			if err := fn(r); err != nil {
+				return err
 			}
-			cursor = lastKey
-			skipFirst = true
 		}
-	}()
-	return ch
+		if exhausted || lastKey == nil {
+			return nil
+		}
+		cursor = lastKey
+		skipFirst = true
+	}
 }
 
 func (d Database) GetAllResources() chan Resource {
@@ -190,7 +256,7 @@ func (d Database) GetAllResources() chan Resource {
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error querying all resources: %v\n", err)
+				dbLogger.Error("Failed to query resources", "error", err)
 				break
 			}
 			for _, r := range resources {
@@ -239,7 +305,7 @@ func (d Database) GetAllResourceNames() chan string {
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error querying resource names: %v\n", err)
+				dbLogger.Error("Failed to query resource names", "error", err)
 				break
 			}
 			for _, n := range names {
@@ -304,7 +370,7 @@ func (d Database) GetUnconsumedResourcesByName(name string, consumingStepID stri
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error querying unconsumed resources for name %s, step %s: %v\n", name, consumingStepID, err)
+				dbLogger.Error("Failed to query unconsumed resources", "name", name, "step_id", consumingStepID, "error", err)
 				break
 			}
 			for _, r := range resources {
@@ -339,13 +405,18 @@ func (d Database) DeleteResource(id string) error {
 		_ = txn.Delete(resourceKey(id))
 		_ = txn.Delete(idxResourceByNameKey(r.Name, id))
 		_ = txn.Delete(idxResourceHashKey(r.Name, r.ObjectHash))
+		_ = txn.Delete(idxResourceByObjectKey(r.ObjectHash, id))
+		if r.RunID != "" {
+			_ = txn.Delete(idxResourceByRunKey(r.RunID, id))
+		}
 		return nil
 	})
 }
 
 func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 	res := ResourceDeleteResult{ResourceID: id}
-	var deleteFSFile bool
+	var store objstore.Store
+	var chunks []chunkRef
 	err := d.badgerDB.Update(func(txn *badger.Txn) error {
 		r, err := getEntity[Resource](txn, resourceKey(id))
 		if err != nil {
@@ -367,6 +438,14 @@ func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 		if err := txn.Delete(idxResourceHashKey(r.Name, r.ObjectHash)); err != nil {
 			return err
 		}
+		if err := txn.Delete(idxResourceByObjectKey(r.ObjectHash, id)); err != nil {
+			return err
+		}
+		if r.RunID != "" {
+			if err := txn.Delete(idxResourceByRunKey(r.RunID, id)); err != nil {
+				return err
+			}
+		}
 		res.ResourceDeleted = true
 
 		remainingRefs, err := countResourcesByObjectHashTxn(txn, r.ObjectHash)
@@ -375,7 +454,8 @@ func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 		}
 		res.RemainingObjectRefs = remainingRefs
 
-		if remainingRefs > 0 {
+		// The object may also be a chunk of a chunked object.
+		if remainingRefs > 0 || prefixExists(txn, idxObjectByChunkPrefix(r.ObjectHash)) {
 			return nil
 		}
 
@@ -396,11 +476,19 @@ func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 		if err != nil {
 			return err
 		}
-		deleteFSFile = string(val) == fsSentinel
+		if store, err = d.storeFor(val); err != nil {
+			return err
+		}
+		if chunks, err = d.chunkRefs(r.ObjectHash, val, item.UserMeta()); err != nil {
+			return err
+		}
 
 		if err := txn.Delete(objectKey(hashBytes)); err != nil {
 			return err
 		}
+		if err := txn.Delete(objectTimeKey(hashBytes)); err != nil {
+			return err
+		}
 		res.ObjectDeleted = true
 		return nil
 	})
@@ -408,8 +496,13 @@ func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 		return res, err
 	}
 
-	if deleteFSFile {
-		if err := removeObjectFileIfExists(d.objectFilePath(res.ObjectHash)); err != nil {
+	if store != nil {
+		if err := store.Delete(res.ObjectHash); err != nil {
+			return res, err
+		}
+	}
+	if len(chunks) > 0 {
+		if _, err := d.releaseChunks(res.ObjectHash, chunks, false); err != nil {
 			return res, err
 		}
 	}
@@ -418,24 +511,5 @@ func (d Database) DeleteResourceHard(id string) (ResourceDeleteResult, error) {
 }
 
 func countResourcesByObjectHashTxn(txn *badger.Txn, objectHash string) (int64, error) {
-	var count int64
-	prefix := []byte(prefixResource)
-	opts := badger.DefaultIteratorOptions
-	opts.Prefix = prefix
-	opts.PrefetchValues = true
-	it := txn.NewIterator(opts)
-	defer it.Close()
-
-	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-		var r Resource
-		err := it.Item().Value(func(v []byte) error { return decode(v, &r) })
-		if err != nil {
-			return 0, err
-		}
-		if r.ObjectHash == objectHash {
-			count++
-		}
-	}
-
-	return count, nil
+	return prefixCount(txn, idxResourceByObjectPrefix(objectHash))
 }
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"sync"
+
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// currentRun is the run this process is executing. Tasks and resources
+// created while it is set are attributed to it.
+type currentRun struct {
+	mu sync.RWMutex
+	id string
+}
+
+func (d Database) currentRunID() string {
+	d.run.mu.RLock()
+	defer d.run.mu.RUnlock()
+	return d.run.id
+}
+
+// BeginRun fills in run's ID and start time, stores it as a new, running run
+// and attributes everything this database creates from now on to it.
+func (d Database) BeginRun(run *Run) error {
+	run.ID = newULID()
+	run.StartedAt = nowTimestamp()
+	run.Status = types.RunStatusRunning
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		return putEntity(txn, runKey(run.ID), run)
+	})
+	if err != nil {
+		return err
+	}
+
+	d.run.mu.Lock()
+	d.run.id = run.ID
+	d.run.mu.Unlock()
+	return nil
+}
+
+// FinishRun stores the final state of the current run and stops attributing
+// new tasks and resources to it.
+func (d Database) FinishRun(run Run) error {
+	d.run.mu.Lock()
+	if d.run.id == run.ID {
+		d.run.id = ""
+	}
+	d.run.mu.Unlock()
+
+	if run.FinishedAt == "" {
+		run.FinishedAt = nowTimestamp()
+	}
+	return d.badgerDB.Update(func(txn *badger.Txn) error {
+		return putEntity(txn, runKey(run.ID), &run)
+	})
+}
+
+func (d Database) GetRun(id string) (*Run, error) {
+	var run *Run
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		run, err = getEntity[Run](txn, runKey(id))
+		return err
+	})
+	return run, err
+}
+
+// ListRuns returns every run, oldest first.
+func (d Database) ListRuns() ([]Run, error) {
+	var runs []Run
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := []byte(prefixRun)
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			val, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			var run Run
+			if err := decode(val, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// GetResourcesForRun streams the resources a run created, oldest first.
+func (d Database) GetResourcesForRun(runID string) chan Resource {
+	ch := make(chan Resource)
+	go func() {
+		defer close(ch)
+		after := ""
+		for {
+			page, next, err := d.ListRunResourcesPage(runID, after, scanBatchSize)
+			if err != nil {
+				dbLogger.Error("Failed to query resources of run", "run_id", runID, "error", err)
+				return
+			}
+			for _, r := range page {
+				ch <- r
+			}
+			if next == "" {
+				return
+			}
+			after = next
+		}
+	}()
+	return ch
+}
+
+// ListRunResourcesPage returns up to limit resources created by a run after
+// the resource ID cursor, and the cursor for the next page.
+func (d Database) ListRunResourcesPage(runID, after string, limit int) ([]Resource, string, error) {
+	var resources []Resource
+	var next string
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		next, err = pageKeysTxn(txn, idxResourceByRunPrefix(runID), after, limit, func(id string) (bool, error) {
+			r, err := getEntity[Resource](txn, resourceKey(id))
+			if err != nil || r == nil {
+				return false, err
+			}
+			resources = append(resources, *r)
+			return true, nil
+		})
+		return err
+	})
+	return resources, next, err
+}
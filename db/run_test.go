@@ -0,0 +1,55 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	"grit/types"
+)
+
+func TestRunAttribution(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("before"))); err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+
+	run := Run{ManifestHash: "abc"}
+	if err := database.BeginRun(&run); err != nil {
+		t.Fatalf("BeginRun() error = %v", err)
+	}
+	if run.ID == "" || run.StartedAt == "" {
+		t.Fatalf("BeginRun() left run = %+v", run)
+	}
+	created, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("during")))
+	if err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+	run.Status = types.RunStatusSucceeded
+	if err := database.FinishRun(run); err != nil {
+		t.Fatalf("FinishRun() error = %v", err)
+	}
+	if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("after"))); err != nil {
+		t.Fatalf("CreateResourceFromReader() error = %v", err)
+	}
+
+	var ids []string
+	for resource := range database.GetResourcesForRun(run.ID) {
+		ids = append(ids, resource.ID)
+	}
+	if len(ids) != 1 || ids[0] != created {
+		t.Fatalf("GetResourcesForRun() = %v, want [%s]", ids, created)
+	}
+
+	got, err := database.GetRun(run.ID)
+	if err != nil || got == nil {
+		t.Fatalf("GetRun() = %v, %v", got, err)
+	}
+	if got.Status != types.RunStatusSucceeded || got.FinishedAt == "" || got.StartedAt != run.StartedAt {
+		t.Errorf("GetRun() = %+v", got)
+	}
+}
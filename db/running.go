@@ -0,0 +1,34 @@
+package db
+
+import "sync"
+
+// runningTasks counts the tasks executing right now, per step. It lives in
+// memory only: it describes this process, not the stored pipeline.
+type runningTasks struct {
+	mu     sync.Mutex
+	byStep map[string]int64
+}
+
+// TaskStarted records that a task of stepID began executing.
+func (d Database) TaskStarted(stepID string) {
+	d.running.mu.Lock()
+	d.running.byStep[stepID]++
+	d.running.mu.Unlock()
+}
+
+// TaskStopped records that a task of stepID is no longer executing.
+func (d Database) TaskStopped(stepID string) {
+	d.running.mu.Lock()
+	if d.running.byStep[stepID]--; d.running.byStep[stepID] <= 0 {
+		delete(d.running.byStep, stepID)
+	}
+	d.running.mu.Unlock()
+}
+
+// CountRunningTasksForStep returns how many tasks of stepID this process is
+// executing or, as a coordinator, has leased out.
+func (d Database) CountRunningTasksForStep(stepID string) (int64, error) {
+	d.running.mu.Lock()
+	defer d.running.mu.Unlock()
+	return d.running.byStep[stepID], nil
+}
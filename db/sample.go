@@ -0,0 +1,105 @@
+package db
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"slices"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// SampleResources picks n of the step's input resources that pass its `when`
+// condition. The choice depends only on seed and the set of candidates: each
+// resource gets a pseudo-random rank from hash(seed, id) and the n lowest
+// ranks win, so new inputs only enter the sample by outranking a member. The
+// result is sorted by resource ID.
+func (d Database) SampleResources(step Step, n int, seed int64) ([]string, error) {
+	if n <= 0 || step.Input == "" {
+		return nil, nil
+	}
+	filter, err := d.newInputFilter(step)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &sampleHeap{}
+	prefix := idxResourceByNamePrefix(step.Input)
+	cursor := append([]byte{}, prefix...)
+	for {
+		var lastKey []byte
+		exhausted := false
+		err := d.badgerDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			var scanned int
+			for it.Seek(cursor); it.ValidForPrefix(prefix); it.Next() {
+				lastKey = it.Item().KeyCopy(nil)
+				scanned++
+				resourceID := string(lastKey[len(prefix):])
+				rank := sampleRank(seed, resourceID)
+				if h.Len() < n || rank < (*h)[0].rank {
+					match, err := filter.matchTxn(txn, resourceID)
+					if err != nil {
+						return err
+					}
+					if match {
+						heap.Push(h, sampleEntry{rank, resourceID})
+						if h.Len() > n {
+							heap.Pop(h)
+						}
+					}
+				}
+				if scanned >= scanBatchSize {
+					return nil
+				}
+			}
+			exhausted = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to sample resources for step %s: %w", step.ID, err)
+		}
+		if exhausted || lastKey == nil {
+			break
+		}
+		cursor = append(lastKey, 0x00)
+	}
+
+	ids := make([]string, 0, h.Len())
+	for _, e := range *h {
+		ids = append(ids, e.id)
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func sampleRank(seed int64, id string) uint64 {
+	hasher := fnv.New64a()
+	binary.Write(hasher, binary.BigEndian, seed)
+	hasher.Write([]byte(id))
+	return hasher.Sum64()
+}
+
+type sampleEntry struct {
+	rank uint64
+	id   string
+}
+
+// sampleHeap is a max-heap on rank, so the root is the entry to evict.
+type sampleHeap []sampleEntry
+
+func (h sampleHeap) Len() int           { return len(h) }
+func (h sampleHeap) Less(i, j int) bool { return h[i].rank > h[j].rank }
+func (h sampleHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *sampleHeap) Push(x any)        { *h = append(*h, x.(sampleEntry)) }
+func (h *sampleHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
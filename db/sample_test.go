@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestSampledTasksDoNotHideUnscheduledInputs(t *testing.T) {
+	tmp := t.TempDir()
+	database, err := NewDatabase(tmp)
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	const total = 40
+	for i := 0; i < total; i++ {
+		payload := []byte(fmt.Sprintf("row %d", i))
+		if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader(payload)); err != nil {
+			t.Fatalf("CreateResourceFromReader(%d) error = %v", i, err)
+		}
+	}
+
+	stepID, err := database.CreateStep(Step{Name: "up", Script: "true", Input: "row"})
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	step, err := database.GetStep(stepID)
+	if err != nil {
+		t.Fatalf("GetStep() error = %v", err)
+	}
+
+	sample, err := database.SampleResources(*step, 5, 42)
+	if err != nil {
+		t.Fatalf("SampleResources() error = %v", err)
+	}
+	if len(sample) != 5 {
+		t.Fatalf("expected 5 sampled resources, got %d", len(sample))
+	}
+	again, err := database.SampleResources(*step, 5, 42)
+	if err != nil {
+		t.Fatalf("SampleResources() second call error = %v", err)
+	}
+	if !slices.Equal(sample, again) {
+		t.Fatalf("expected the same sample for the same seed, got %v and %v", sample, again)
+	}
+
+	if _, err := database.CreateTasksFromResources(stepID, sample); err != nil {
+		t.Fatalf("CreateTasksFromResources() error = %v", err)
+	}
+
+	// The sample usually includes late resources; scheduling must still
+	// pick up every earlier one.
+	scheduled, err := database.ScheduleTasksForStep(stepID)
+	if err != nil {
+		t.Fatalf("ScheduleTasksForStep() error = %v", err)
+	}
+	if scheduled != total-5 {
+		t.Fatalf("expected %d newly scheduled tasks, got %d", total-5, scheduled)
+	}
+
+	count, err := database.CountTasksForStep(stepID)
+	if err != nil {
+		t.Fatalf("CountTasksForStep() error = %v", err)
+	}
+	if count != total {
+		t.Fatalf("expected %d tasks in total, got %d", total, count)
+	}
+}
@@ -1,6 +1,7 @@
 package db
 
 import (
+	"grit/broadcast"
 	"grit/types"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -9,11 +10,17 @@ import (
 type Database struct {
 	repo_path string
 	badgerDB  *badger.DB
+	events    *broadcast.Broadcaster[Event]
+	running   *runningTasks
+	run       *currentRun
+	stores    *objectStores
+	config    Config
 }
 
 // Type aliases so existing db internals compile unchanged until rewrite.
 type Step = types.Step
 type Task = types.Task
 type Resource = types.Resource
+type Run = types.Run
 
 
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"grit/types"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// seedParamKey is the idxTaskBySeedParam component for a task. Seeds without
+// parameters use the empty string.
+func seedParamKey(t Task) string {
+	if t.SeedParam == nil {
+		return ""
+	}
+	return *t.SeedParam
+}
+
+// ScheduleSeedTasks is ScheduleTasksForStep for steps without an input. It
+// creates one task per seed parameter (or a single task when there are none)
+// whenever the step's seed policy says a new run is due. runStart is when the
+// current pipeline run began, used by SeedAlways. A parameter whose previous
+// run is still unprocessed is never scheduled again.
+func (d Database) ScheduleSeedTasks(step Step, runStart time.Time) (int64, error) {
+	params := []*string{nil}
+	if len(step.SeedParams) > 0 {
+		params = params[:0]
+		for i := range step.SeedParams {
+			params = append(params, &step.SeedParams[i])
+		}
+	}
+
+	var scheduled int64
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		for _, param := range params {
+			last, err := lastSeedTaskTxn(txn, step.ID, param)
+			if err != nil {
+				return err
+			}
+			if !seedDue(step, last, runStart) {
+				continue
+			}
+
+			id := newULID()
+			task := Task{
+				ID:        id,
+				StepID:    step.ID,
+				SeedParam: param,
+				CreatedAt: nowTimestamp(),
+				RunID:     d.currentRunID(),
+			}
+			if err := putEntity(txn, taskKey(id), &task); err != nil {
+				return err
+			}
+			if err := txn.Set(idxTaskByStepUnprocKey(step.ID, id), nil); err != nil {
+				return err
+			}
+			if err := txn.Set(idxTaskByStepAllKey(step.ID, id), nil); err != nil {
+				return err
+			}
+			if err := txn.Set(idxTaskBySeedParamKey(step.ID, seedParamKey(task), id), nil); err != nil {
+				return err
+			}
+			scheduled++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to schedule seed tasks for step %s: %w", step.ID, err)
+	}
+	return scheduled, nil
+}
+
+// seedDue applies the step's seed policy to its most recent run.
+func seedDue(step Step, last *Task, runStart time.Time) bool {
+	if last == nil {
+		return true
+	}
+	if !last.Processed {
+		return false
+	}
+	createdAt := ulidTime(last.ID)
+	switch step.Seed {
+	case types.SeedAlways:
+		return createdAt.Before(runStart)
+	case types.SeedEvery:
+		return time.Since(createdAt) >= step.SeedEvery
+	default:
+		return false
+	}
+}
+
+// lastSeedTaskTxn returns the most recent run of a seed step for param, or nil.
+func lastSeedTaskTxn(txn *badger.Txn, stepID string, param *string) (*Task, error) {
+	key := ""
+	if param != nil {
+		key = *param
+	}
+	prefix := idxTaskBySeedParamPrefix(stepID, key)
+	var lastID string
+	err := prefixScanReverse(txn, prefix, func(k, _ []byte) (bool, error) {
+		lastID = string(k[len(prefix):])
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lastID != "" {
+		return getEntity[Task](txn, taskKey(lastID))
+	}
+	if param != nil {
+		return nil, nil
+	}
+
+	// Seed tasks created before idxTaskBySeedParam existed are only in the
+	// all-tasks index.
+	var last *Task
+	err = prefixScanReverse(txn, idxTaskByStepAllPrefix(stepID), func(k, _ []byte) (bool, error) {
+		t, err := getEntity[Task](txn, taskKey(string(k[len(idxTaskByStepAllPrefix(stepID)):])))
+		if err != nil {
+			return false, err
+		}
+		if t != nil && t.SeedParam == nil {
+			last = t
+			return false, nil
+		}
+		return true, nil
+	})
+	return last, err
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -39,9 +40,19 @@ func (d Database) CreateStep(step Step) (string, error) {
 		}
 
 		// Check if latest version matches (same script and input)
-		if latestStep != nil && latestStep.Script == step.Script && latestStep.Input == step.Input {
-			// Just update parallel if needed
+		if latestStep != nil && sameStepDefinition(*latestStep, step) {
+			// Just update scheduling knobs and the display-only script path if needed
 			latestStep.Parallel = step.Parallel
+			latestStep.BatchSize = step.BatchSize
+			latestStep.BatchTimeout = step.BatchTimeout
+			latestStep.After = step.After
+			latestStep.Outputs = step.Outputs
+			latestStep.Seed = step.Seed
+			latestStep.SeedEvery = step.SeedEvery
+			latestStep.SeedParams = step.SeedParams
+			latestStep.Compress = step.Compress
+			latestStep.Chunk = step.Chunk
+			latestStep.ScriptFile = step.ScriptFile
 			if err := putEntity(txn, stepKey(latestStep.ID), latestStep); err != nil {
 				return err
 			}
@@ -75,6 +86,24 @@ func (d Database) CreateStep(step Step) (string, error) {
 	return resultID, err
 }
 
+// sameStepDefinition reports whether two step records would produce the same
+// outputs for the same input. Fields that only affect scheduling (parallel,
+// batch size, after, outputs, seed policy) are ignored; anything else bumps
+// the step version when it changes. Switching between batched and
+// single-input mode changes what the script sees as input, so that does
+// count.
+func sameStepDefinition(a, b Step) bool {
+	return a.Script == b.Script &&
+		a.Input == b.Input &&
+		a.Batched() == b.Batched() &&
+		a.Mode == b.Mode &&
+		a.InputFormat == b.InputFormat &&
+		a.When == b.When &&
+		a.Interpreter == b.Interpreter &&
+		slices.Equal(a.Shell, b.Shell) &&
+		slices.Equal(a.Command, b.Command)
+}
+
 func (d Database) GetStep(id string) (*Step, error) {
 	var step *Step
 	err := d.badgerDB.View(func(txn *badger.Txn) error {
@@ -88,29 +117,84 @@ func (d Database) GetStep(id string) (*Step, error) {
 func (d Database) GetStepByName(name string) (*Step, error) {
 	var result *Step
 	err := d.badgerDB.View(func(txn *badger.Txn) error {
-		// Reverse scan to get highest version first (keys are sorted, version is zero-padded)
-		prefix := idxStepByNamePrefix(name)
-		var lastKey []byte
-		err := prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
-			lastKey = key
-			return true, nil
-		})
-		if err != nil {
+		var err error
+		result, err = latestStepByNameTxn(txn, name)
+		return err
+	})
+	return result, err
+}
+
+// latestStepByNameTxn returns the highest version of the named step, or nil.
+func latestStepByNameTxn(txn *badger.Txn, name string) (*Step, error) {
+	// Keys are sorted and the version is zero-padded, so the last key under
+	// the prefix is the highest version.
+	prefix := idxStepByNamePrefix(name)
+	var lastKey []byte
+	err := prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+		lastKey = key
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if lastKey == nil {
+		return nil, nil
+	}
+	// Extract ULID from last key (highest version)
+	parts := strings.Split(string(lastKey[len(prefix):]), "\x00")
+	if len(parts) < 2 {
+		return nil, nil
+	}
+	stepULID := parts[len(parts)-1]
+	return getEntity[Step](txn, stepKey(stepULID))
+}
+
+// ListLatestSteps returns the highest version of every step, sorted by name.
+func (d Database) ListLatestSteps() ([]Step, error) {
+	var steps []Step
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		steps, err = latestStepsTxn(txn)
+		return err
+	})
+	return steps, err
+}
+
+func latestStepsTxn(txn *badger.Txn) ([]Step, error) {
+	// ix:sn keys sort by name then zero-padded version, so the last key
+	// seen for each name is its latest version.
+	var steps []Step
+	prefix := []byte(idxStepByName)
+	var lastName, lastID string
+	flush := func() error {
+		if lastID == "" {
+			return nil
+		}
+		s, err := getEntity[Step](txn, stepKey(lastID))
+		if err != nil || s == nil {
 			return err
 		}
-		if lastKey == nil {
-			return nil
+		steps = append(steps, *s)
+		return nil
+	}
+	err := prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+		parts := strings.Split(string(key[len(prefix):]), "\x00")
+		if len(parts) < 3 {
+			return true, nil
 		}
-		// Extract ULID from last key (highest version)
-		parts := strings.Split(string(lastKey[len(prefix):]), "\x00")
-		if len(parts) < 2 {
-			return nil
+		if parts[0] != lastName {
+			if err := flush(); err != nil {
+				return false, err
+			}
+			lastName = parts[0]
 		}
-		stepULID := parts[len(parts)-1]
-		result, err = getEntity[Step](txn, stepKey(stepULID))
-		return err
+		lastID = parts[len(parts)-1]
+		return true, nil
 	})
-	return result, err
+	if err != nil {
+		return nil, err
+	}
+	return steps, flush()
 }
 
 func (d Database) GetStepsWithZeroInputs() chan Step {
@@ -144,7 +228,7 @@ func (d Database) GetStepsWithZeroInputs() chan Step {
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error in GetStepsWithZeroInputs: %v\n", err)
+				dbLogger.Error("Failed to list steps with zero inputs", "error", err)
 				break
 			}
 			for _, s := range steps {
@@ -192,7 +276,7 @@ func (d Database) ListSteps() chan Step {
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error in ListSteps: %v\n", err)
+				dbLogger.Error("Failed to list steps", "error", err)
 				break
 			}
 			for _, s := range steps {
@@ -313,7 +397,7 @@ func (d Database) GetTaintedSteps() chan Step {
 				}
 			}
 			for _, s := range steps {
-				if s.Version < maxVersion && (s.Script != maxStep.Script || s.Input != maxStep.Input) {
+				if s.Version < maxVersion && !sameStepDefinition(s, maxStep) {
 					ch <- s
 				}
 			}
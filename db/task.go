@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
 )
@@ -11,6 +12,9 @@ func (d Database) CreateTask(task Task) (string, error) {
 	err := d.badgerDB.Update(func(txn *badger.Txn) error {
 		id := newULID()
 		task.ID = id
+		if task.CreatedAt == "" {
+			task.CreatedAt = nowTimestamp()
+		}
 
 		if err := putEntity(txn, taskKey(id), &task); err != nil {
 			return err
@@ -32,9 +36,9 @@ func (d Database) CreateTask(task Task) (string, error) {
 			return err
 		}
 
-// Unique constraint index
-			if task.InputResourceID != nil {
-				if err := txn.Set(idxTaskUniqueKey(task.StepID, *task.InputResourceID), []byte(id)); err != nil {
+		// Unique constraint index
+		for _, resourceID := range task.InputIDs() {
+			if err := txn.Set(idxTaskUniqueKey(task.StepID, resourceID), []byte(id)); err != nil {
 				return err
 			}
 		}
@@ -79,6 +83,8 @@ func (d Database) CreateTasksFromResources(stepID string, resourceIDs []string)
 					ID:              id,
 					StepID:          stepID,
 					InputResourceID: &resID,
+					CreatedAt:       nowTimestamp(),
+					RunID:           d.currentRunID(),
 				}
 
 				if err := putEntity(txn, taskKey(id), &task); err != nil {
@@ -166,6 +172,30 @@ func (d Database) GetTasksForStep(stepID string) chan Task {
 	return ch
 }
 
+// NextUnprocessedTasks returns up to n unprocessed tasks for a step in
+// creation order, passing over any for which skip returns true.
+func (d Database) NextUnprocessedTasks(stepID string, n int, skip func(taskID string) bool) ([]Task, error) {
+	var tasks []Task
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		prefix := idxTaskByStepUnprocPrefix(stepID)
+		return prefixScanKeys(txn, prefix, func(key []byte) (bool, error) {
+			taskID := string(key[len(prefix):])
+			if skip != nil && skip(taskID) {
+				return true, nil
+			}
+			t, err := getEntity[Task](txn, taskKey(taskID))
+			if err != nil {
+				return false, err
+			}
+			if t != nil {
+				tasks = append(tasks, *t)
+			}
+			return len(tasks) < n, nil
+		})
+	})
+	return tasks, err
+}
+
 func (d Database) GetUnprocessedTasks(stepID string) chan Task {
 	ch := make(chan Task)
 	go func() {
@@ -204,7 +234,7 @@ func (d Database) GetUnprocessedTasks(stepID string) chan Task {
 				return nil
 			})
 			if err != nil {
-				dbLogger.Verbosef("Error querying unprocessed tasks for step %s: %v\n", stepID, err)
+				dbLogger.Error("Failed to query unprocessed tasks", "step_id", stepID, "error", err)
 				break
 			}
 			total += len(tasks)
@@ -216,7 +246,7 @@ func (d Database) GetUnprocessedTasks(stepID string) chan Task {
 			}
 			cursor = append(lastKey, 0x00)
 		}
-		dbLogger.Verbosef("GetUnprocessedTasks(step=%s) found %d unprocessed tasks\n", stepID, total)
+		dbLogger.Debug("Listed unprocessed tasks", "step_id", stepID, "tasks", total)
 	}()
 	return ch
 }
@@ -239,6 +269,58 @@ type TaskStatusUpdate struct {
 	ID        string
 	Processed bool
 	Error     *string
+	Skipped   bool
+}
+
+// applyTaskStatusTxn writes a status change to t and moves it between the
+// per-step status indexes.
+func applyTaskStatusTxn(txn *badger.Txn, t *Task, u TaskStatusUpdate) error {
+	wasProcessed := t.Processed
+	wasSkipped := t.Skipped
+	wasFailed := t.Error != nil
+	t.Processed = u.Processed
+	t.Error = u.Error
+	t.Skipped = u.Skipped
+	if u.Processed && !wasProcessed {
+		t.FinishedAt = nowTimestamp()
+	} else if !u.Processed {
+		t.FinishedAt = ""
+	}
+	if err := putEntity(txn, taskKey(t.ID), t); err != nil {
+		return err
+	}
+
+	if wasProcessed != u.Processed {
+		if u.Processed {
+			_ = txn.Delete(idxTaskByStepUnprocKey(t.StepID, t.ID))
+			if err := txn.Set(idxTaskByStepProcKey(t.StepID, t.ID), nil); err != nil {
+				return err
+			}
+		} else {
+			_ = txn.Delete(idxTaskByStepProcKey(t.StepID, t.ID))
+			if err := txn.Set(idxTaskByStepUnprocKey(t.StepID, t.ID), nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	if wasSkipped != u.Skipped {
+		if u.Skipped {
+			if err := txn.Set(idxTaskByStepSkippedKey(t.StepID, t.ID), nil); err != nil {
+				return err
+			}
+		} else {
+			_ = txn.Delete(idxTaskByStepSkippedKey(t.StepID, t.ID))
+		}
+	}
+
+	if failed := u.Error != nil; wasFailed != failed {
+		if failed {
+			return txn.Set(idxTaskByStepFailedKey(t.StepID, t.ID), nil)
+		}
+		_ = txn.Delete(idxTaskByStepFailedKey(t.StepID, t.ID))
+	}
+	return nil
 }
 
 // BatchUpdateTaskStatus writes a slice of task status updates in chunks of
@@ -250,30 +332,19 @@ func (d Database) BatchUpdateTaskStatus(updates []TaskStatusUpdate) error {
 			end = len(updates)
 		}
 		chunk := updates[i:end]
+		var finished []*Task
 		err := d.badgerDB.Update(func(txn *badger.Txn) error {
+			finished = finished[:0]
 			for _, u := range chunk {
 				t, err := getEntity[Task](txn, taskKey(u.ID))
 				if err != nil || t == nil {
 					continue
 				}
-				wasProcessed := t.Processed
-				t.Processed = u.Processed
-				t.Error = u.Error
-				if err := putEntity(txn, taskKey(u.ID), t); err != nil {
+				if err := applyTaskStatusTxn(txn, t, u); err != nil {
 					return err
 				}
-				if wasProcessed != u.Processed {
-					if u.Processed {
-						_ = txn.Delete(idxTaskByStepUnprocKey(t.StepID, u.ID))
-						if err := txn.Set(idxTaskByStepProcKey(t.StepID, u.ID), nil); err != nil {
-							return err
-						}
-					} else {
-						_ = txn.Delete(idxTaskByStepProcKey(t.StepID, u.ID))
-						if err := txn.Set(idxTaskByStepUnprocKey(t.StepID, u.ID), nil); err != nil {
-							return err
-						}
-					}
+				if t.Processed {
+					finished = append(finished, t)
 				}
 			}
 			return nil
@@ -281,37 +352,27 @@ func (d Database) BatchUpdateTaskStatus(updates []TaskStatusUpdate) error {
 		if err != nil {
 			return err
 		}
+		for _, t := range finished {
+			d.publish(Event{Kind: EventTaskFinished, Task: t})
+		}
 	}
 	return nil
 }
 
 func (d Database) UpdateTaskStatus(id string, processed bool, errorMsg *string) error {
-	return d.badgerDB.Update(func(txn *badger.Txn) error {
-		t, err := getEntity[Task](txn, taskKey(id))
+	var t *Task
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		var err error
+		t, err = getEntity[Task](txn, taskKey(id))
 		if err != nil || t == nil {
 			return err
 		}
-
-		wasProcessed := t.Processed
-		t.Processed = processed
-		t.Error = errorMsg
-
-		if err := putEntity(txn, taskKey(id), t); err != nil {
-			return err
-		}
-
-		// Move between status indexes
-		if wasProcessed != processed {
-			if processed {
-				_ = txn.Delete(idxTaskByStepUnprocKey(t.StepID, id))
-				return txn.Set(idxTaskByStepProcKey(t.StepID, id), nil)
-			} else {
-				_ = txn.Delete(idxTaskByStepProcKey(t.StepID, id))
-				return txn.Set(idxTaskByStepUnprocKey(t.StepID, id), nil)
-			}
-		}
-		return nil
+		return applyTaskStatusTxn(txn, t, TaskStatusUpdate{ID: id, Processed: processed, Error: errorMsg})
 	})
+	if err == nil && t != nil && t.Processed {
+		d.publish(Event{Kind: EventTaskFinished, Task: t})
+	}
+	return err
 }
 
 func (d Database) CountTasksForStep(stepID string) (int64, error) {
@@ -324,6 +385,26 @@ func (d Database) CountTasksForStep(stepID string) (int64, error) {
 	return count, err
 }
 
+func (d Database) CountSkippedTasksForStep(stepID string) (int64, error) {
+	var count int64
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = prefixCount(txn, idxTaskByStepSkippedPrefix(stepID))
+		return err
+	})
+	return count, err
+}
+
+func (d Database) CountFailedTasksForStep(stepID string) (int64, error) {
+	var count int64
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		count, err = prefixCount(txn, idxTaskByStepFailedPrefix(stepID))
+		return err
+	})
+	return count, err
+}
+
 func (d Database) CountUnprocessedTasks() (int64, error) {
 	var count int64
 	err := d.badgerDB.View(func(txn *badger.Txn) error {
@@ -358,11 +439,13 @@ func (d Database) GetTaskCountsForStep(stepID string) (total int64, processed in
 }
 
 func (d Database) DeleteTask(id string) error {
-	return d.badgerDB.Update(func(txn *badger.Txn) error {
+	var reduce bool
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
 		t, err := getEntity[Task](txn, taskKey(id))
 		if err != nil || t == nil {
 			return err
 		}
+		reduce = t.Fingerprint != ""
 
 		if err := txn.Delete(taskKey(id)); err != nil {
 			return err
@@ -370,11 +453,19 @@ func (d Database) DeleteTask(id string) error {
 		_ = txn.Delete(idxTaskByStepAllKey(t.StepID, id))
 		_ = txn.Delete(idxTaskByStepUnprocKey(t.StepID, id))
 		_ = txn.Delete(idxTaskByStepProcKey(t.StepID, id))
-		if t.InputResourceID != nil {
-			_ = txn.Delete(idxTaskUniqueKey(t.StepID, *t.InputResourceID))
+		_ = txn.Delete(idxTaskByStepSkippedKey(t.StepID, id))
+		_ = txn.Delete(idxTaskByStepFailedKey(t.StepID, id))
+		_ = txn.Delete(idxTaskBySeedParamKey(t.StepID, seedParamKey(*t), id))
+		_ = txn.Delete(taskLogKey(id))
+		for _, resourceID := range t.InputIDs() {
+			_ = txn.Delete(idxTaskUniqueKey(t.StepID, resourceID))
 		}
 		return nil
 	})
+	if err != nil || !reduce {
+		return err
+	}
+	return d.deleteTaskInputs(id)
 }
 
 func (d Database) TaskExists(id string) (bool, error) {
@@ -472,13 +563,7 @@ func (d Database) MarkStepTasksUnprocessed(stepID string) error {
 					if err != nil || t == nil {
 						continue
 					}
-					t.Processed = false
-					t.Error = nil
-					if err := putEntity(txn, taskKey(taskID), t); err != nil {
-						return err
-					}
-					_ = txn.Delete(idxTaskByStepProcKey(stepID, taskID))
-					if err := txn.Set(idxTaskByStepUnprocKey(stepID, taskID), nil); err != nil {
+					if err := applyTaskStatusTxn(txn, t, TaskStatusUpdate{ID: taskID}); err != nil {
 						return err
 					}
 				}
@@ -533,19 +618,28 @@ func (d Database) MarkStepUndone(stepID string) error {
 				end = len(taskIDs)
 			}
 			chunk := taskIDs[i:end]
+			var reduceTasks []string
 			err = d.badgerDB.Update(func(txn *badger.Txn) error {
+				reduceTasks = reduceTasks[:0]
 				for _, taskID := range chunk {
 					t, err := getEntity[Task](txn, taskKey(taskID))
 					if err != nil || t == nil {
 						continue
 					}
+					if t.Fingerprint != "" {
+						reduceTasks = append(reduceTasks, taskID)
+					}
 					// Delete task and all its indexes
 					_ = txn.Delete(taskKey(taskID))
 					_ = txn.Delete(idxTaskByStepAllKey(stepID, taskID))
 					_ = txn.Delete(idxTaskByStepUnprocKey(stepID, taskID))
 					_ = txn.Delete(idxTaskByStepProcKey(stepID, taskID))
-					if t.InputResourceID != nil {
-						_ = txn.Delete(idxTaskUniqueKey(stepID, *t.InputResourceID))
+					_ = txn.Delete(idxTaskByStepSkippedKey(stepID, taskID))
+					_ = txn.Delete(idxTaskByStepFailedKey(stepID, taskID))
+					_ = txn.Delete(idxTaskBySeedParamKey(stepID, seedParamKey(*t), taskID))
+					_ = txn.Delete(taskLogKey(taskID))
+					for _, resourceID := range t.InputIDs() {
+						_ = txn.Delete(idxTaskUniqueKey(stepID, resourceID))
 					}
 					totalDeleted++
 				}
@@ -554,13 +648,24 @@ func (d Database) MarkStepUndone(stepID string) error {
 			if err != nil {
 				return err
 			}
+			for _, taskID := range reduceTasks {
+				if err := d.deleteTaskInputs(taskID); err != nil {
+					return err
+				}
+			}
 		}
 		if exhausted || lastKey == nil {
 			break
 		}
 		cursor = append(lastKey, 0x00)
 	}
-	dbLogger.Verbosef("Marked step %s as undone: deleted %d tasks\n", stepID, totalDeleted)
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(metaScheduleWatermarkKey(stepID))
+	})
+	if err != nil {
+		return err
+	}
+	dbLogger.Debug("Marked step as undone", "step_id", stepID, "deleted_tasks", totalDeleted)
 	return nil
 }
 
@@ -583,7 +688,7 @@ func (d Database) CheckAndMarkStepComplete(stepID string) (bool, error) {
 			return false, err
 		}
 		if step != nil {
-			dbLogger.Verbosef("Step %s (%s) marked as complete\n", stepID, step.Name)
+			dbLogger.Debug("Step complete", "step", step.Name, "step_id", stepID)
 		}
 	}
 	return isComplete, nil
@@ -624,38 +729,44 @@ func (d Database) ScheduleTasksForStep(stepID string) (int64, error) {
 		return 0, err
 	}
 	if step == nil || step.Input == "" {
-		dbLogger.Verbosef("Step %s (%s) has no input, skipping scheduling\n", stepID, step.Name)
+		dbLogger.Debug("Step has no input, skipping scheduling", "step_id", stepID)
 		return 0, nil
 	}
 
-	dbLogger.Verbosef("Scheduling tasks for step %s (%s) with input: %s\n", stepID, step.Name, step.Input)
+	dbLogger.Debug("Scheduling tasks", "step", step.Name, "step_id", stepID, "input", step.Input)
+
+	if step.Reduce() {
+		return d.scheduleReduceTaskForStep(*step)
+	}
+	if step.Batched() {
+		return d.scheduleBatchedTasksForStep(*step, false)
+	}
+
+	filter, err := d.newInputFilter(*step)
+	if err != nil {
+		return 0, err
+	}
 
 	const scheduleBatchSize = scanBatchSize
-	var totalScheduled int64
+	var totalScheduled, totalFiltered int64
 
 	prefix := idxResourceByNamePrefix(step.Input)
-	cursor := append([]byte{}, prefix...) // start at beginning of prefix; may be advanced by watermark below
-
-	// Fast-forward cursor past resources already scheduled for this step.
-	// The unique index (ix:tu:{stepID}\x00{resourceID}) is ULID-ordered, so
-	// its last key is the highest resourceID already scheduled.  A single
-	// reverse seek gives us the resume point in O(1) instead of re-scanning
-	// the entire resource index on every startup.
-	uniquePrefix := []byte(idxTaskUnique + stepID + "\x00")
+	// Fast-forward cursor past resources already swept for this step, so a
+	// restart doesn't re-scan the entire resource index. The watermark is
+	// kept separately from the unique index because tasks created out of
+	// order (sampled runs) also land there.
+	var cursor []byte
 	wmErr := d.badgerDB.View(func(txn *badger.Txn) error {
-		return prefixScanReverse(txn, uniquePrefix, func(key, _ []byte) (bool, error) {
-			lastResourceID := string(key[len(uniquePrefix):])
-			// Set cursor to just after the last scheduled resource key.
-			cursor = append(idxResourceByNameKey(step.Input, lastResourceID), 0x00)
-			return false, nil // stop after first (highest) result
-		})
+		var err error
+		cursor, err = scheduleCursorTxn(txn, *step)
+		return err
 	})
 	if wmErr != nil {
-		dbLogger.Verbosef("ScheduleTasksForStep: step=%s watermark lookup error (proceeding from start): %v\n", stepID, wmErr)
+		dbLogger.Warn("Watermark lookup failed, scanning from start", "step", step.Name, "error", wmErr)
 		cursor = append([]byte{}, prefix...) // reset to safe default
 	}
 
-	dbLogger.Verbosef("ScheduleTasksForStep: step=%s input=%s scanning\n", stepID, step.Input)
+	dbLogger.Debug("Scanning inputs", "step", step.Name, "input", step.Input)
 
 	for {
 		var batch []string
@@ -687,11 +798,11 @@ func (d Database) ScheduleTasksForStep(stepID string) (int64, error) {
 			return totalScheduled, fmt.Errorf("failed to scan resources for step %s: %w", stepID, err)
 		}
 
-		dbLogger.Verbosef("ScheduleTasksForStep: step=%s input=%s scan_window=%d exhausted=%v\n",
-			stepID, step.Input, scanTotal, exhausted)
+		dbLogger.Debug("Scanned input window", "step", step.Name, "input", step.Input,
+			"scanned", scanTotal, "exhausted", exhausted)
 
 		if len(batch) > 0 {
-			var batchWritten int
+			var batchWritten, batchFiltered int
 			for j := 0; j < len(batch); j += writeBatchSize {
 				wEnd := j + writeBatchSize
 				if wEnd > len(batch) {
@@ -704,12 +815,27 @@ func (d Database) ScheduleTasksForStep(stepID string) (int64, error) {
 						if keyExists(txn, uniqueKey) {
 							continue
 						}
+						match, err := filter.matchTxn(txn, resourceID)
+						if err != nil {
+							return err
+						}
+						if !match {
+							// Mark as consumed without a task so the resource
+							// is not evaluated again.
+							if err := txn.Set(uniqueKey, nil); err != nil {
+								return err
+							}
+							batchFiltered++
+							continue
+						}
 						id := newULID()
 						resID := resourceID
 						task := Task{
 							ID:              id,
 							StepID:          stepID,
 							InputResourceID: &resID,
+							CreatedAt:       nowTimestamp(),
+							RunID:           d.currentRunID(),
 						}
 						if err := putEntity(txn, taskKey(id), &task); err != nil {
 							return err
@@ -731,9 +857,206 @@ func (d Database) ScheduleTasksForStep(stepID string) (int64, error) {
 					return totalScheduled, fmt.Errorf("failed to write task batch for step %s: %w", stepID, err)
 				}
 			}
+			err = d.badgerDB.Update(func(txn *badger.Txn) error {
+				return txn.Set(metaScheduleWatermarkKey(stepID), lastKey[len(prefix):])
+			})
+			if err != nil {
+				return totalScheduled, fmt.Errorf("failed to advance watermark for step %s: %w", stepID, err)
+			}
 			totalScheduled += int64(batchWritten)
-			dbLogger.Verbosef("ScheduleTasksForStep: step=%s input=%s batch_written=%d (race_skipped=%d) total_scheduled=%d\n",
-				stepID, step.Input, batchWritten, len(batch)-batchWritten, totalScheduled)
+			totalFiltered += int64(batchFiltered)
+			dbLogger.Debug("Wrote task batch", "step", step.Name, "input", step.Input, "written", batchWritten,
+				"filtered", batchFiltered, "race_skipped", len(batch)-batchWritten-batchFiltered, "total_scheduled", totalScheduled)
+		}
+
+		if exhausted || len(lastKey) == 0 {
+			break
+		}
+		cursor = append(lastKey, 0x00)
+	}
+
+	dbLogger.Debug("Finished scanning inputs", "step", step.Name, "input", step.Input,
+		"scheduled", totalScheduled, "filtered", totalFiltered)
+
+	if totalScheduled > 0 {
+		dbLogger.Debug("Scheduled new tasks", "step", step.Name, "tasks", totalScheduled)
+	}
+	return totalScheduled, nil
+}
+
+// FlushBatchesForStep schedules a batched step's inputs like
+// ScheduleTasksForStep, but writes a partial batch instead of holding it for
+// BatchTimeout. A run calls it once nothing more is coming.
+func (d Database) FlushBatchesForStep(stepID string) (int64, error) {
+	step, err := d.GetStep(stepID)
+	if err != nil {
+		return 0, err
+	}
+	if step == nil || !step.Batched() {
+		return 0, nil
+	}
+	return d.scheduleBatchedTasksForStep(*step, true)
+}
+
+// scheduleCursorTxn returns the resource index key to resume scheduling step
+// from: just past the watermark, or the start of the input's index when the
+// step has never been swept.
+func scheduleCursorTxn(txn *badger.Txn, step Step) ([]byte, error) {
+	last, err := getVal(txn, metaScheduleWatermarkKey(step.ID))
+	if err != nil {
+		return nil, err
+	}
+	if len(last) == 0 {
+		return append([]byte{}, idxResourceByNamePrefix(step.Input)...), nil
+	}
+	return append(idxResourceByNameKey(step.Input, string(last)), 0x00), nil
+}
+
+// scheduleBatchedTasksForStep is ScheduleTasksForStep for steps with a batch
+// size: unconsumed resources are grouped into tasks of up to BatchSize inputs,
+// and every member is recorded in the unique index so it is consumed once.
+//
+// A trailing partial batch is only written once its oldest member is older
+// than BatchTimeout, or when flush is set; until then it is left for a later
+// scheduling pass. Like
+// ScheduleTasksForStep, a pass resumes from the step's watermark; while a
+// batch is held the watermark stops just before it, so the next pass rescans
+// only the held resources and whatever arrived after them.
+func (d Database) scheduleBatchedTasksForStep(step Step, flush bool) (int64, error) {
+	filter, err := d.newInputFilter(step)
+	if err != nil {
+		return 0, err
+	}
+
+	var totalScheduled int64
+
+	prefix := idxResourceByNamePrefix(step.Input)
+	var cursor, watermark []byte
+	err = d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		if watermark, err = getVal(txn, metaScheduleWatermarkKey(step.ID)); err != nil {
+			return err
+		}
+		cursor, err = scheduleCursorTxn(txn, step)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to read watermark for step %s: %w", step.ID, err)
+	}
+
+	// markFiltered records resources rejected by the `when` condition as
+	// consumed without a task.
+	markFiltered := func(filtered []string) error {
+		for i := 0; i < len(filtered); i += writeBatchSize {
+			chunk := filtered[i:min(i+writeBatchSize, len(filtered))]
+			err := d.badgerDB.Update(func(txn *badger.Txn) error {
+				for _, resourceID := range chunk {
+					if err := txn.Set(idxTaskUniqueKey(step.ID, resourceID), nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	writeGroup := func(group []string) error {
+		return d.badgerDB.Update(func(txn *badger.Txn) error {
+			members := make([]string, 0, len(group))
+			for _, resourceID := range group {
+				if !keyExists(txn, idxTaskUniqueKey(step.ID, resourceID)) {
+					members = append(members, resourceID)
+				}
+			}
+			if len(members) == 0 {
+				return nil
+			}
+			id := newULID()
+			task := Task{
+				ID:               id,
+				StepID:           step.ID,
+				InputResourceIDs: members,
+				CreatedAt:        nowTimestamp(),
+				RunID:            d.currentRunID(),
+			}
+			if err := putEntity(txn, taskKey(id), &task); err != nil {
+				return err
+			}
+			if err := txn.Set(idxTaskByStepUnprocKey(step.ID, id), nil); err != nil {
+				return err
+			}
+			if err := txn.Set(idxTaskByStepAllKey(step.ID, id), nil); err != nil {
+				return err
+			}
+			for _, resourceID := range members {
+				if err := txn.Set(idxTaskUniqueKey(step.ID, resourceID), []byte(id)); err != nil {
+					return err
+				}
+			}
+			totalScheduled++
+			return nil
+		})
+	}
+
+	// group holds the resources of the batch being filled; before[i] is the
+	// resource indexed just ahead of group[i], where the watermark stops if
+	// group[i] starts a held batch.
+	var group, before []string
+	lastSeen := string(watermark)
+	for {
+		var lastKey []byte
+		var exhausted bool
+		var filtered []string
+
+		err := d.badgerDB.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.Prefix = prefix
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			var scanned int
+			for it.Seek(cursor); it.ValidForPrefix(prefix); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				lastKey = key
+				scanned++
+				resourceID := string(key[len(prefix):])
+				if !keyExists(txn, idxTaskUniqueKey(step.ID, resourceID)) {
+					match, err := filter.matchTxn(txn, resourceID)
+					if err != nil {
+						return err
+					}
+					if match {
+						group = append(group, resourceID)
+						before = append(before, lastSeen)
+					} else {
+						filtered = append(filtered, resourceID)
+					}
+				}
+				lastSeen = resourceID
+				if scanned >= scanBatchSize {
+					return nil
+				}
+			}
+			exhausted = true
+			return nil
+		})
+		if err != nil {
+			return totalScheduled, fmt.Errorf("failed to scan resources for step %s: %w", step.ID, err)
+		}
+		if err := markFiltered(filtered); err != nil {
+			return totalScheduled, fmt.Errorf("failed to record filtered resources for step %s: %w", step.ID, err)
+		}
+
+		for len(group) >= step.BatchSize {
+			if err := writeGroup(group[:step.BatchSize]); err != nil {
+				return totalScheduled, fmt.Errorf("failed to write task batch for step %s: %w", step.ID, err)
+			}
+			group, before = group[step.BatchSize:], before[step.BatchSize:]
 		}
 
 		if exhausted || len(lastKey) == 0 {
@@ -742,11 +1065,26 @@ func (d Database) ScheduleTasksForStep(stepID string) (int64, error) {
 		cursor = append(lastKey, 0x00)
 	}
 
-	dbLogger.Verbosef("ScheduleTasksForStep: step=%s input=%s done: scheduled=%d\n",
-		stepID, step.Input, totalScheduled)
+	if len(group) > 0 {
+		if !flush && step.BatchTimeout > 0 && time.Since(ulidTime(group[0])) < step.BatchTimeout {
+			dbLogger.Debug("Holding partial batch", "step", step.Name, "resources", len(group))
+			lastSeen = before[0]
+		} else if err := writeGroup(group); err != nil {
+			return totalScheduled, fmt.Errorf("failed to write task batch for step %s: %w", step.ID, err)
+		}
+	}
+
+	if lastSeen != "" && lastSeen != string(watermark) {
+		err := d.badgerDB.Update(func(txn *badger.Txn) error {
+			return txn.Set(metaScheduleWatermarkKey(step.ID), []byte(lastSeen))
+		})
+		if err != nil {
+			return totalScheduled, fmt.Errorf("failed to advance watermark for step %s: %w", step.ID, err)
+		}
+	}
 
 	if totalScheduled > 0 {
-		dbLogger.Verbosef("Scheduled %d new tasks for step %s (%s)\n", totalScheduled, stepID, step.Name)
+		dbLogger.Debug("Scheduled new batch tasks", "step", step.Name, "tasks", totalScheduled)
 	}
 	return totalScheduled, nil
 }
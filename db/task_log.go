@@ -0,0 +1,31 @@
+package db
+
+import (
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// MaxTaskLogSize caps how much script output is kept per task. Longer output
+// keeps only its tail, which is where errors usually are.
+const MaxTaskLogSize = 256 << 10
+
+// SaveTaskLog stores the captured output of a task, replacing any earlier
+// log for it.
+func (d Database) SaveTaskLog(taskID string, output []byte) error {
+	if len(output) > MaxTaskLogSize {
+		output = output[len(output)-MaxTaskLogSize:]
+	}
+	return d.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(taskLogKey(taskID), output)
+	})
+}
+
+// GetTaskLog returns the captured output of a task, or nil if none was kept.
+func (d Database) GetTaskLog(taskID string) ([]byte, error) {
+	var output []byte
+	err := d.badgerDB.View(func(txn *badger.Txn) error {
+		var err error
+		output, err = getVal(txn, taskLogKey(taskID))
+		return err
+	})
+	return output, err
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestFailedTaskIndex(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	for i := 0; i < 4; i++ {
+		if _, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte(fmt.Sprint(i)))); err != nil {
+			t.Fatalf("CreateResourceFromReader(%d) error = %v", i, err)
+		}
+	}
+	stepID, err := database.CreateStep(Step{Name: "up", Script: "true", Input: "row"})
+	if err != nil {
+		t.Fatalf("CreateStep() error = %v", err)
+	}
+	if _, err := database.ScheduleTasksForStep(stepID); err != nil {
+		t.Fatalf("ScheduleTasksForStep() error = %v", err)
+	}
+	tasks, _, err := database.ListTasksPage(stepID, TaskStatusAll, "", 10)
+	if err != nil || len(tasks) != 4 {
+		t.Fatalf("ListTasksPage() = %d tasks, %v; want 4", len(tasks), err)
+	}
+
+	boom := "boom"
+	updates := []TaskStatusUpdate{
+		{ID: tasks[0].ID, Processed: true, Error: &boom},
+		{ID: tasks[1].ID, Processed: true, Error: &boom},
+		{ID: tasks[2].ID, Processed: true},
+	}
+	if err := database.BatchUpdateTaskStatus(updates); err != nil {
+		t.Fatalf("BatchUpdateTaskStatus() error = %v", err)
+	}
+	// Retrying a failed task clears it from the index.
+	if err := database.UpdateTaskStatus(tasks[1].ID, false, nil); err != nil {
+		t.Fatalf("UpdateTaskStatus() error = %v", err)
+	}
+
+	failed, err := database.CountFailedTasksForStep(stepID)
+	if err != nil || failed != 1 {
+		t.Fatalf("CountFailedTasksForStep() = %d, %v; want 1", failed, err)
+	}
+	page, _, err := database.ListTasksPage(stepID, TaskStatusFailed, "", 10)
+	if err != nil || len(page) != 1 || page[0].ID != tasks[0].ID {
+		t.Fatalf("ListTasksPage(failed) = %v, %v; want only %s", page, err, tasks[0].ID)
+	}
+
+	if err := database.DeleteTask(tasks[0].ID); err != nil {
+		t.Fatalf("DeleteTask() error = %v", err)
+	}
+	if failed, _ := database.CountFailedTasksForStep(stepID); failed != 0 {
+		t.Fatalf("CountFailedTasksForStep() after delete = %d, want 0", failed)
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"fmt"
+	"time"
+
+	"grit/expr"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// inputFilter evaluates a step's `when` condition against candidate input
+// resources at scheduling time. A nil filter matches everything.
+type inputFilter struct {
+	d    Database
+	cond *expr.Expr
+}
+
+func (d Database) newInputFilter(step Step) (*inputFilter, error) {
+	if step.When == "" {
+		return nil, nil
+	}
+	cond, err := expr.Parse(step.When)
+	if err != nil {
+		return nil, fmt.Errorf("invalid when condition for step %s: %w", step.Name, err)
+	}
+	return &inputFilter{d: d, cond: cond}, nil
+}
+
+// matchTxn reports whether the resource should get a task. Resources that no
+// longer exist never match, and neither do resources the condition cannot be
+// evaluated on (e.g. labels.score > 0.5 where score is missing or not a
+// number); those are logged rather than failing scheduling for the step.
+func (f *inputFilter) matchTxn(txn *badger.Txn, resourceID string) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	r, err := getEntity[Resource](txn, resourceKey(resourceID))
+	if err != nil || r == nil {
+		return false, err
+	}
+	env, err := f.d.resourceEnvTxn(txn, *r)
+	if err != nil {
+		return false, err
+	}
+	match, err := f.cond.Eval(env)
+	if err != nil {
+		dbLogger.Warn("when condition failed to evaluate, skipping resource",
+			"when", f.cond.String(), "resource", resourceID, "error", err)
+		return false, nil
+	}
+	return match, nil
+}
+
+// resourceEnvTxn exposes resource metadata to `when` conditions:
+// size (bytes), name, created_at, age (seconds) and labels.
+func (d Database) resourceEnvTxn(txn *badger.Txn, r Resource) (expr.Env, error) {
+	size := r.Size
+	if size == 0 {
+		var err error
+		if size, err = d.objectSizeTxn(txn, r.ObjectHash); err != nil {
+			return nil, err
+		}
+	}
+	createdAt := ulidTime(r.ID)
+	labels := r.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return expr.Env{
+		"size":       size,
+		"name":       r.Name,
+		"created_at": createdAt,
+		"age":        time.Since(createdAt).Seconds(),
+		"labels":     labels,
+	}, nil
+}
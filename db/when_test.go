@@ -0,0 +1,59 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+func TestWhenOnLabels(t *testing.T) {
+	database, err := NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase() error = %v", err)
+	}
+	defer database.Close()
+
+	// Scores are strings, as labels always are; "n/a" and the missing label
+	// cannot be compared with a number.
+	for _, score := range []string{"0.9", "0.1", "n/a", ""} {
+		id, _, err := database.CreateResourceFromReader("row", bytes.NewReader([]byte("row "+score)))
+		if err != nil {
+			t.Fatalf("CreateResourceFromReader(%q) error = %v", score, err)
+		}
+		if score != "" {
+			setLabels(t, database, id, map[string]string{"score": score})
+		}
+	}
+
+	for _, step := range []Step{
+		{Name: "each", Script: "true", Input: "row", When: "labels.score > 0.5"},
+		{Name: "batched", Script: "true", Input: "row", When: "labels.score > 0.5", BatchSize: 2},
+	} {
+		stepID, err := database.CreateStep(step)
+		if err != nil {
+			t.Fatalf("CreateStep(%s) error = %v", step.Name, err)
+		}
+		if _, err := database.ScheduleTasksForStep(stepID); err != nil {
+			t.Fatalf("ScheduleTasksForStep(%s) error = %v", step.Name, err)
+		}
+		if n, err := database.CountTasksForStep(stepID); err != nil || n != 1 {
+			t.Errorf("CountTasksForStep(%s) = %d, %v; want 1", step.Name, n, err)
+		}
+	}
+}
+
+func setLabels(t *testing.T, d Database, id string, labels map[string]string) {
+	t.Helper()
+	err := d.badgerDB.Update(func(txn *badger.Txn) error {
+		r, err := getEntity[Resource](txn, resourceKey(id))
+		if err != nil {
+			return err
+		}
+		r.Labels = labels
+		return putEntity(txn, resourceKey(id), r)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
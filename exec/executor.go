@@ -2,26 +2,42 @@ package exec
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"grit/db"
 	"grit/log"
+	"grit/tracing"
 	"grit/types"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ScriptExecutor struct {
-	db *db.Database
+	db Store
 }
 
-func NewScriptExecutor(db *db.Database) *ScriptExecutor {
+func NewScriptExecutor(db Store) *ScriptExecutor {
 	return &ScriptExecutor{db}
 }
 
 var executeLogger = log.NewLogger("EXEC")
 
+// SkipExitCode is the reserved exit status a script uses to say "nothing to
+// do for this input". The task is recorded as skipped rather than failed and
+// its outputs are discarded. Scripts see it as $SKIP_EXIT_CODE.
+const SkipExitCode = 99
+
+// ErrSkipped is returned by Execute when the script exited with SkipExitCode.
+var ErrSkipped = errors.New("task skipped")
+
 // func (e *ScriptExecutor) ExecuteStep(step types.Step, defaultParallel int) error {
 // 	database := e.db
 // 	executeLogger.Println("Running unfinished tasks for step", step.Name)
@@ -41,8 +57,27 @@ var executeLogger = log.NewLogger("EXEC")
 // 	return nil
 // }
 
-func (e *ScriptExecutor) Execute(task types.Task, step types.Step) error {
-	// executeLogger.Printf("Executing task ID=%d for step '%s' (step_id=%d)\n", task.ID, step.Name, task.StepID)
+// Execute runs one task of step and ingests its outputs. The task is traced
+// as a child of any span in ctx.
+func (e *ScriptExecutor) Execute(ctx context.Context, task types.Task, step types.Step) (err error) {
+	ctx, span := tracing.Start(ctx, "task",
+		tracing.StepKey.String(step.Name),
+		tracing.StepVersionKey.Int(step.Version),
+		tracing.TaskIDKey.String(task.ID),
+	)
+	defer func() {
+		if errors.Is(err, ErrSkipped) {
+			span.SetAttributes(tracing.SkippedKey.Bool(true))
+			tracing.End(span, nil)
+			return
+		}
+		tracing.End(span, err)
+	}()
+
+	logger := executeLogger.With("step", step.Name, "task_id", task.ID)
+	if task.InputResourceID != nil {
+		logger = logger.With("resource", *task.InputResourceID)
+	}
 	start := time.Now()
 
 	// Create input file
@@ -53,8 +88,30 @@ func (e *ScriptExecutor) Execute(task types.Task, step types.Step) error {
 	defer os.Remove(inputFile.Name())
 	defer inputFile.Close()
 
-	// Write input data if exists
-	if err := e.prepareInput(task, inputFile); err != nil {
+	// Write input data if exists. Batched and reduce tasks get a directory
+	// holding one file per input resource, and the input file lists their
+	// paths; reduce steps may ask for a single concatenated file instead.
+	prepareCtx, prepareSpan := tracing.Start(ctx, "prepare_input")
+	var inputDir string
+	switch {
+	case step.Reduce() && step.InputFormat == types.InputFormatConcat:
+		err = e.prepareConcatInput(prepareCtx, logger, e.taskInputs(task), inputFile)
+
+	case step.Reduce() || len(task.InputResourceIDs) > 0:
+		inputDir, err = os.MkdirTemp("", "grit-input-*")
+		if err != nil {
+			err = fmt.Errorf("failed to create input dir: %w", err)
+			break
+		}
+		defer os.RemoveAll(inputDir)
+
+		err = e.prepareDirInput(prepareCtx, logger, e.taskInputs(task), inputDir, inputFile)
+
+	default:
+		err = e.prepareInput(prepareCtx, logger, task, inputFile)
+	}
+	tracing.End(prepareSpan, err)
+	if err != nil {
 		return err
 	}
 	inputFile.Close()
@@ -66,37 +123,93 @@ func (e *ScriptExecutor) Execute(task types.Task, step types.Step) error {
 	}
 	defer os.RemoveAll(outputDir)
 
-	// Execute the script
-	executeLogger.Verbosef("Executing: %s\n", step.Script)
-	cmd := e.buildCommand(step, inputFile.Name(), outputDir, task.ID)
+	// Labels for an output named X are read from $LABELS_DIR/X as key=value lines
+	labelsDir, err := os.MkdirTemp("", "grit-labels-*")
+	if err != nil {
+		return fmt.Errorf("failed to create labels dir: %w", err)
+	}
+	defer os.RemoveAll(labelsDir)
 
-	// Run script and capture output
-	if err := e.runScript(cmd, step); err != nil {
+	env := []string{
+		fmt.Sprintf("INPUT_FILE=%s", inputFile.Name()),
+		fmt.Sprintf("OUTPUT_DIR=%s", outputDir),
+		fmt.Sprintf("LABELS_DIR=%s", labelsDir),
+		fmt.Sprintf("SKIP_EXIT_CODE=%d", SkipExitCode),
+	}
+	if inputDir != "" {
+		env = append(env, fmt.Sprintf("INPUT_DIR=%s", inputDir))
+	}
+	if task.SeedParam != nil {
+		env = append(env, fmt.Sprintf("SEED_PARAM=%s", *task.SeedParam))
+	}
+
+	// Execute the script
+	logger.Debug("Executing script", "script", step.Script)
+	cmd, cleanup, err := e.buildCommand(step, env)
+	if err != nil {
 		return err
 	}
+	defer cleanup()
+
+	// Run script and capture output. The log is kept whether or not the
+	// script succeeds; failures are when it matters most.
+	_, scriptSpan := tracing.Start(ctx, "execute_script")
+	output := &tailBuffer{max: db.MaxTaskLogSize}
+	runErr := e.runScript(logger, cmd, step, output)
+	if errors.Is(runErr, ErrSkipped) {
+		tracing.End(scriptSpan, nil)
+	} else {
+		tracing.End(scriptSpan, runErr)
+	}
+	if output.Len() > 0 {
+		if err := e.db.SaveTaskLog(task.ID, output.Bytes()); err != nil {
+			logger.Error("Failed to save task log", "error", err)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
 
 	// Ingest output files synchronously
+	_, ingestSpan := tracing.Start(ctx, "ingest_outputs")
+	outputs, err := e.ingestOutputs(task, outputDir, labelsDir)
+	ingestSpan.SetAttributes(tracing.OutputCountKey.Int(outputs))
+	tracing.End(ingestSpan, err)
+	span.SetAttributes(tracing.OutputCountKey.Int(outputs))
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Task succeeded", "duration", time.Since(start))
+	return nil
+}
+
+// ingestOutputs stores every file in outputDir as an output of task and
+// returns how many were stored.
+func (e *ScriptExecutor) ingestOutputs(task types.Task, outputDir, labelsDir string) (int, error) {
 	entries, err := os.ReadDir(outputDir)
 	if err != nil {
-		return fmt.Errorf("failed to read output dir: %w", err)
+		return 0, fmt.Errorf("failed to read output dir: %w", err)
 	}
+	count := 0
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
+		labels, err := readLabels(filepath.Join(labelsDir, entry.Name()))
+		if err != nil {
+			return count, fmt.Errorf("failed to read labels for %s: %w", entry.Name(), err)
+		}
 		path := outputDir + "/" + entry.Name()
-		if err := e.db.IngestFile(path, entry.Name(), task.ID); err != nil {
-			return fmt.Errorf("failed to ingest output file %s: %w", entry.Name(), err)
+		if err := e.db.IngestFile(path, entry.Name(), task.ID, labels); err != nil {
+			return count, fmt.Errorf("failed to ingest output file %s: %w", entry.Name(), err)
 		}
+		count++
 	}
-
-	elapsedTime := time.Since(start)
-
-	executeLogger.Printf("Executed task ID=%s for step '%s' successfully in %s\n", task.ID, step.Name, elapsedTime.String())
-	return nil
+	return count, nil
 }
 
-func (e *ScriptExecutor) prepareInput(task types.Task, inputFile *os.File) error {
+func (e *ScriptExecutor) prepareInput(ctx context.Context, logger log.MyLogger, task types.Task, inputFile *os.File) error {
 	// Get input resource if task has one
 	if task.InputResourceID != nil {
 		inputResource, err := e.db.GetResource(*task.InputResourceID)
@@ -104,33 +217,162 @@ func (e *ScriptExecutor) prepareInput(task types.Task, inputFile *os.File) error
 			return fmt.Errorf("failed to get input resource: %w", err)
 		}
 
-		data, err := e.db.GetObject(inputResource.ObjectHash)
+		obj, err := e.db.OpenObject(inputResource.ObjectHash)
 		if err != nil {
 			return fmt.Errorf("failed to get object: %w", err)
 		}
+		defer obj.Close()
 
-		n, err := inputFile.Write(data)
+		n, err := io.Copy(inputFile, obj)
 		if err != nil {
 			return fmt.Errorf("failed to write input data: %w", err)
 		}
-		executeLogger.Verbosef("Input: %d bytes from resource '%s' (hash: %s)\n", n, inputResource.Name, inputResource.ObjectHash[:16]+"...")
+		trace.SpanFromContext(ctx).SetAttributes(
+			tracing.InputHashKey.String(inputResource.ObjectHash),
+			tracing.InputCountKey.Int(1),
+		)
+		logger.Debug("Prepared input", "name", inputResource.Name, "bytes", n)
 	} else {
-		executeLogger.Verbosef("Input: (empty - start step)\n")
+		logger.Debug("No input (start step)")
 	}
 
 	return nil
 }
 
-func (e *ScriptExecutor) buildCommand(step types.Step, inputFile, outputDir string, taskID string) *exec.Cmd {
-	cmd := exec.Command("sh", "-c", step.Script)
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("INPUT_FILE=%s", inputFile),
-		fmt.Sprintf("OUTPUT_DIR=%s", outputDir),
-	)
-	return cmd
+// taskInputs calls fn for every input resource of a batched or reduce task.
+func (e *ScriptExecutor) taskInputs(task types.Task) func(fn func(types.Resource) error) error {
+	return func(fn func(types.Resource) error) error {
+		return e.db.ListTaskInputs(task, func(resourceID string) error {
+			inputResource, err := e.db.GetResource(resourceID)
+			if err != nil {
+				return fmt.Errorf("failed to get input resource: %w", err)
+			}
+			if inputResource == nil {
+				return fmt.Errorf("input resource %s not found", resourceID)
+			}
+			return fn(*inputResource)
+		})
+	}
+}
+
+// prepareDirInput writes every input resource into inputDir and lists the
+// resulting paths, one per line, in listFile.
+func (e *ScriptExecutor) prepareDirInput(ctx context.Context, logger log.MyLogger, inputs func(fn func(types.Resource) error) error, inputDir string, listFile *os.File) error {
+	list := bufio.NewWriter(listFile)
+	var count int
+	var total int64
+	err := inputs(func(inputResource types.Resource) error {
+		path := filepath.Join(inputDir, fmt.Sprintf("%06d-%s", count, inputResource.ID))
+		n, err := e.copyObject(inputResource.ObjectHash, path)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(list, path); err != nil {
+			return fmt.Errorf("failed to write input list: %w", err)
+		}
+		count++
+		total += n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.InputCountKey.Int(count))
+	logger.Debug("Prepared input directory", "resources", count, "bytes", total, "dir", inputDir)
+	return list.Flush()
+}
+
+// copyObject writes an object's content to a new file at path.
+func (e *ScriptExecutor) copyObject(hash, path string) (int64, error) {
+	obj, err := e.db.OpenObject(hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer obj.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write input data: %w", err)
+	}
+	n, err := io.Copy(f, obj)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to write input data: %w", err)
+	}
+	return n, nil
+}
+
+// prepareConcatInput streams every input resource of a reduce task into a
+// single file.
+func (e *ScriptExecutor) prepareConcatInput(ctx context.Context, logger log.MyLogger, inputs func(fn func(types.Resource) error) error, inputFile *os.File) error {
+	out := bufio.NewWriter(inputFile)
+	var count int
+	var total int64
+	err := inputs(func(r types.Resource) error {
+		obj, err := e.db.OpenObject(r.ObjectHash)
+		if err != nil {
+			return fmt.Errorf("failed to get object: %w", err)
+		}
+		n, err := io.Copy(out, obj)
+		obj.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write input data: %w", err)
+		}
+		count++
+		total += n
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(tracing.InputCountKey.Int(count))
+	logger.Debug("Prepared concatenated input", "resources", count, "bytes", total)
+	return out.Flush()
+}
+
+// buildCommand picks how a step is launched:
+//   - command:     argv run directly, no shell
+//   - interpreter: the script is written to a temp file passed as the last arg
+//   - shell:       the script is appended to the given argv (default sh -c)
+//
+// The returned cleanup func removes any temp files and must always be called.
+func (e *ScriptExecutor) buildCommand(step types.Step, env []string) (*exec.Cmd, func(), error) {
+	cleanup := func() {}
+
+	var cmd *exec.Cmd
+	switch {
+	case len(step.Command) > 0:
+		cmd = exec.Command(step.Command[0], step.Command[1:]...)
+
+	case step.Interpreter != "":
+		scriptFile, err := os.CreateTemp("", "grit-script-*")
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("failed to create script file: %w", err)
+		}
+		cleanup = func() { os.Remove(scriptFile.Name()) }
+		if _, err := scriptFile.WriteString(step.Script); err != nil {
+			scriptFile.Close()
+			return nil, cleanup, fmt.Errorf("failed to write script file: %w", err)
+		}
+		scriptFile.Close()
+		cmd = exec.Command(step.Interpreter, scriptFile.Name())
+
+	default:
+		shell := step.Shell
+		if len(shell) == 0 {
+			shell = []string{"sh", "-c"}
+		}
+		args := append(append([]string{}, shell[1:]...), step.Script)
+		cmd = exec.Command(shell[0], args...)
+	}
+
+	cmd.Env = append(os.Environ(), env...)
+	return cmd, cleanup, nil
 }
 
-func (e *ScriptExecutor) runScript(cmd *exec.Cmd, step types.Step) error {
+func (e *ScriptExecutor) runScript(logger log.MyLogger, cmd *exec.Cmd, step types.Step, output *tailBuffer) error {
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdout pipe: %w", err)
@@ -142,11 +384,11 @@ func (e *ScriptExecutor) runScript(cmd *exec.Cmd, step types.Step) error {
 	}
 
 	if err := cmd.Start(); err != nil {
-		executeLogger.Printf("Error starting script: %v\n", err)
+		logger.Error("Failed to start script", "error", err)
 		return fmt.Errorf("failed to start script: %w", err)
 	}
 
-	scriptLogger := executeLogger.Context(step.Name)
+	scriptLogger := logger.Context(step.Name)
 
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -155,7 +397,8 @@ func (e *ScriptExecutor) runScript(cmd *exec.Cmd, step types.Step) error {
 		defer wg.Done()
 		scanner := bufio.NewScanner(stdoutPipe)
 		for scanner.Scan() {
-			scriptLogger.Verbosef("[stdout] %s\n", scanner.Text())
+			scriptLogger.Debug(scanner.Text(), "stream", "stdout")
+			output.WriteLine(scanner.Bytes())
 		}
 	}()
 
@@ -163,7 +406,8 @@ func (e *ScriptExecutor) runScript(cmd *exec.Cmd, step types.Step) error {
 		defer wg.Done()
 		scanner := bufio.NewScanner(stderrPipe)
 		for scanner.Scan() {
-			scriptLogger.Verbosef("[stderr] %s\n", scanner.Text())
+			scriptLogger.Debug(scanner.Text(), "stream", "stderr")
+			output.WriteLine(scanner.Bytes())
 		}
 	}()
 
@@ -173,12 +417,77 @@ func (e *ScriptExecutor) runScript(cmd *exec.Cmd, step types.Step) error {
 	// Then wait for goroutines to finish reading
 	wg.Wait()
 
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == SkipExitCode {
+		return ErrSkipped
+	}
 	if err != nil {
-		executeLogger.Printf("Error executing script: %v\n", err)
+		logger.Warn("Script failed", "error", err)
 		return fmt.Errorf("script execution failed: %w", err)
 	}
 
 	return nil
 }
 
+// tailBuffer collects script output lines from several goroutines, keeping
+// only the last max bytes.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (t *tailBuffer) WriteLine(line []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, line...)
+	t.buf = append(t.buf, '\n')
+	// Trim in large steps so appends stay amortised.
+	if len(t.buf) > 2*t.max {
+		t.buf = append([]byte{}, t.buf[len(t.buf)-t.max:]...)
+	}
+}
+
+func (t *tailBuffer) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.buf)
+}
+
+func (t *tailBuffer) Bytes() []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.buf) > t.max {
+		return t.buf[len(t.buf)-t.max:]
+	}
+	return t.buf
+}
+
+// readLabels parses a key=value labels file. A missing file means no labels.
+func readLabels(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	labels := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label line %q (want key=value)", line)
+		}
+		labels[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return labels, scanner.Err()
+}
+
 
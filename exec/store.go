@@ -0,0 +1,76 @@
+package exec
+
+import (
+	"io"
+
+	"grit/types"
+)
+
+// Store is the part of the database the executor needs: reading inputs and
+// ingesting outputs. *db.Database satisfies it.
+type Store interface {
+	GetResource(id string) (*types.Resource, error)
+	// ListTaskInputs calls fn with the ID of every input resource of task,
+	// including the recorded input set of a reduce task.
+	ListTaskInputs(task types.Task, fn func(resourceID string) error) error
+	GetObject(hash string) ([]byte, error)
+	OpenObject(hash string) (io.ReadCloser, error)
+	IngestFile(path, name, taskID string, labels map[string]string) error
+	SaveTaskLog(taskID string, output []byte) error
+}
+
+// overlayDB is what an overlay offers beyond Store. Objects are looked up by
+// hash alone, so the overlay has to say whether it holds one.
+type overlayDB interface {
+	Store
+	ObjectExists(hash string) bool
+}
+
+// overlayStore writes to overlay, so outputs of a trial run never touch the
+// base database. Reads go to the overlay first, so a step sees what the steps
+// before it wrote there, and fall back to base.
+type overlayStore struct {
+	Store
+	overlay overlayDB
+}
+
+// NewOverlayStore returns a Store that reads inputs from overlay or base and
+// ingests outputs into overlay.
+func NewOverlayStore(base Store, overlay overlayDB) Store {
+	return overlayStore{base, overlay}
+}
+
+func (o overlayStore) GetResource(id string) (*types.Resource, error) {
+	r, err := o.overlay.GetResource(id)
+	if err != nil || r != nil {
+		return r, err
+	}
+	return o.Store.GetResource(id)
+}
+
+// ListTaskInputs reads from overlay, which holds the tasks of a trial run.
+func (o overlayStore) ListTaskInputs(task types.Task, fn func(resourceID string) error) error {
+	return o.overlay.ListTaskInputs(task, fn)
+}
+
+func (o overlayStore) GetObject(hash string) ([]byte, error) {
+	if o.overlay.ObjectExists(hash) {
+		return o.overlay.GetObject(hash)
+	}
+	return o.Store.GetObject(hash)
+}
+
+func (o overlayStore) OpenObject(hash string) (io.ReadCloser, error) {
+	if o.overlay.ObjectExists(hash) {
+		return o.overlay.OpenObject(hash)
+	}
+	return o.Store.OpenObject(hash)
+}
+
+func (o overlayStore) IngestFile(path, name, taskID string, labels map[string]string) error {
+	return o.overlay.IngestFile(path, name, taskID, labels)
+}
+
+func (o overlayStore) SaveTaskLog(taskID string, output []byte) error {
+	return o.overlay.SaveTaskLog(taskID, output)
+}
@@ -0,0 +1,559 @@
+// Package expr implements the small boolean expression language used by
+// step `when` conditions.
+//
+//	size > 10KB && name =~ "^page-" && labels.lang == "en"
+//	!(age < 1h) || labels.priority == "high"
+//
+// Values are numbers, strings or times. Numeric literals accept size suffixes
+// (B, KB, MB, GB, TB, KiB, MiB, GiB, TiB) and duration suffixes (ms, s, m, h,
+// d); sizes evaluate to bytes and durations to seconds. A string compared
+// against a time is parsed as RFC3339 or YYYY-MM-DD, and one compared against
+// a number is parsed as a number, so labels.score > 0.5 works on the string
+// "0.75". Identifiers of the form a.b look up key b in map a; missing keys
+// evaluate to the empty string.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Env holds the variables an expression can reference. Values must be
+// float64, int64, int, string, time.Time or map[string]string.
+type Env map[string]any
+
+// Expr is a parsed expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse compiles src into an Expr.
+func Parse(src string) (*Expr, error) {
+	p := &parser{lex: newLexer(src)}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", p.tok.text, p.tok.pos)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source the expression was parsed from.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against env. The result must be a boolean.
+func (e *Expr) Eval(env Env) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression %q does not evaluate to a boolean", e.src)
+	}
+	return b, nil
+}
+
+// --- AST ---
+
+type node interface {
+	eval(env Env) (any, error)
+}
+
+type literal struct{ value any }
+
+func (n literal) eval(Env) (any, error) { return n.value, nil }
+
+type ident struct{ name string }
+
+func (n ident) eval(env Env) (any, error) {
+	base, key, nested := strings.Cut(n.name, ".")
+	v, ok := env[base]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", base)
+	}
+	if !nested {
+		return normalize(v), nil
+	}
+	m, ok := v.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf("variable %q has no fields", base)
+	}
+	return m[key], nil
+}
+
+type not struct{ operand node }
+
+func (n not) eval(env Env) (any, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("! applied to non-boolean %v", v)
+	}
+	return !b, nil
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n logical) eval(env Env) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := l.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s applied to non-boolean %v", n.op, l)
+	}
+	// Short-circuit.
+	if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+		return lb, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	rb, ok := r.(bool)
+	if !ok {
+		return nil, fmt.Errorf("%s applied to non-boolean %v", n.op, r)
+	}
+	return rb, nil
+}
+
+type comparison struct {
+	op          string
+	left, right node
+	// re caches the compiled pattern when the right side of =~ is a literal.
+	re *regexp.Regexp
+}
+
+func (n comparison) eval(env Env) (any, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.op == "=~" || n.op == "!~" {
+		ls, lok := l.(string)
+		rs, rok := r.(string)
+		if !lok || !rok {
+			return nil, fmt.Errorf("%s requires strings, got %v and %v", n.op, l, r)
+		}
+		re := n.re
+		if re == nil {
+			if re, err = regexp.Compile(rs); err != nil {
+				return nil, err
+			}
+		}
+		return re.MatchString(ls) == (n.op == "=~"), nil
+	}
+
+	cmp, err := compare(l, r)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func normalize(v any) any {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	}
+	return v
+}
+
+func compare(l, r any) (int, error) {
+	switch lv := l.(type) {
+	case float64:
+		switch rv := r.(type) {
+		case float64:
+			return cmpOrdered(lv, rv), nil
+		case string:
+			rf, err := numericString(rv)
+			if err != nil {
+				return 0, err
+			}
+			return cmpOrdered(lv, rf), nil
+		}
+		return 0, fmt.Errorf("cannot compare number %v with %v", l, r)
+	case string:
+		switch rv := r.(type) {
+		case string:
+			return strings.Compare(lv, rv), nil
+		case float64:
+			lf, err := numericString(lv)
+			if err != nil {
+				return 0, err
+			}
+			return cmpOrdered(lf, rv), nil
+		case time.Time:
+			lt, err := parseTime(lv)
+			if err != nil {
+				return 0, err
+			}
+			return lt.Compare(rv), nil
+		}
+		return 0, fmt.Errorf("cannot compare string %q with %v", lv, r)
+	case time.Time:
+		switch rv := r.(type) {
+		case time.Time:
+			return lv.Compare(rv), nil
+		case string:
+			rt, err := parseTime(rv)
+			if err != nil {
+				return 0, err
+			}
+			return lv.Compare(rt), nil
+		}
+		return 0, fmt.Errorf("cannot compare time with %v", r)
+	case bool:
+		rv, ok := r.(bool)
+		if !ok {
+			return 0, fmt.Errorf("cannot compare boolean with %v", r)
+		}
+		if lv == rv {
+			return 0, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("cannot compare %v", l)
+}
+
+func cmpOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func numericString(s string) (float64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compare %q with a number", s)
+	}
+	return f, nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (want RFC3339 or YYYY-MM-DD)", s)
+}
+
+// --- Parser ---
+//
+//	or         = and { "||" and }
+//	and        = unary { "&&" unary }
+//	unary      = "!" unary | comparison
+//	comparison = operand [ op operand ]
+//	operand    = number | string | ident | "(" or ")"
+
+type parser struct {
+	lex *lexer
+	tok token
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "||" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"||", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == tokOp && p.tok.text == "&&" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{"&&", left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.tok.kind == tokOp && p.tok.text == "!" {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokOp {
+		return left, nil
+	}
+	op := p.tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+	default:
+		return left, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	n := comparison{op: op, left: left, right: right}
+	if lit, ok := right.(literal); ok && (op == "=~" || op == "!~") {
+		pattern, ok := lit.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a string pattern", op)
+		}
+		if n.re, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return n, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		v, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, err
+		}
+		return literal{v}, p.advance()
+	case tokString:
+		return literal{tok.text}, p.advance()
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return literal{true}, p.advance()
+		case "false":
+			return literal{false}, p.advance()
+		}
+		return ident{tok.text}, p.advance()
+	case tokLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, fmt.Errorf("expected ) at offset %d", p.tok.pos)
+		}
+		return inner, p.advance()
+	case tokEOF:
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.pos)
+}
+
+var numberSuffixes = map[string]float64{
+	"":    1,
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+	"ms":  1e-3,
+	"s":   1,
+	"m":   60,
+	"h":   3600,
+	"d":   86400,
+}
+
+func parseNumber(text string) (float64, error) {
+	i := 0
+	for i < len(text) && (text[i] >= '0' && text[i] <= '9' || text[i] == '.') {
+		i++
+	}
+	v, err := strconv.ParseFloat(text[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", text)
+	}
+	mult, ok := numberSuffixes[strings.ToLower(text[i:])]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in %q", text)
+	}
+	return v * mult, nil
+}
+
+// --- Lexer ---
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokOp
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+func newLexer(src string) *lexer {
+	return &lexer{src: src}
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])) {
+		l.pos++
+	}
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{tokLParen, "(", start}, nil
+	case c == ')':
+		l.pos++
+		return token{tokRParen, ")", start}, nil
+	case c == '"' || c == '\'':
+		return l.lexString(c)
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && (isIdentChar(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{tokNumber, l.src[start:l.pos], start}, nil
+	case isIdentStart(c):
+		for l.pos < len(l.src) && (isIdentChar(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{tokIdent, l.src[start:l.pos], start}, nil
+	}
+
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "!~", "<", ">", "!"} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{tokOp, op, start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected character %q at offset %d", c, start)
+}
+
+func (l *lexer) lexString(quote byte) (token, error) {
+	start := l.pos
+	l.pos++
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == quote:
+			l.pos++
+			return token{tokString, sb.String(), start}, nil
+		case c == '\\' && l.pos+1 < len(l.src):
+			sb.WriteByte(l.src[l.pos+1])
+			l.pos += 2
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+	return token{}, fmt.Errorf("unterminated string at offset %d", start)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9')
+}
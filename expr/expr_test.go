@@ -0,0 +1,82 @@
+package expr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEval(t *testing.T) {
+	env := Env{
+		"size":       int64(20_000),
+		"name":       "page-42",
+		"created_at": time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		"age":        float64(7200),
+		"labels":     map[string]string{"lang": "en", "score": "0.75"},
+	}
+
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`size > 10KB`, true},
+		{`size > 1.5MiB`, false},
+		{`name =~ "^page-"`, true},
+		{`name !~ "^page-"`, false},
+		{`labels.lang == "en" && size >= 20000`, true},
+		{`labels.missing == ""`, true},
+		{`labels.score > 0.5 && 1 > labels.score`, true},
+		{`labels.score == 0.75`, true},
+		{`created_at > "2026-01-01"`, true},
+		{`created_at < "2026-03-01T00:00:00Z"`, false},
+		{`age > 1h && !(age > 3h)`, true},
+		{`name == 'x' || (size < 1KB || labels.lang != "de")`, true},
+	}
+	for _, c := range cases {
+		e, err := Parse(c.src)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", c.src, err)
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Fatalf("Eval(%q) error = %v", c.src, err)
+		}
+		if got != c.want {
+			t.Errorf("Eval(%q) = %v, want %v", c.src, got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`size >`,
+		`(size > 1`,
+		`size > 10XB`,
+		`name =~ "("`,
+		`"unterminated`,
+		`size > 1 size`,
+	} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) expected error", src)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	env := Env{"size": 10, "name": "x", "labels": map[string]string{}}
+	for _, src := range []string{
+		`size`,
+		`size > "big"`,
+		`labels.missing > 0.5`,
+		`unknown == 1`,
+		`name.field == "x"`,
+	} {
+		e, err := Parse(src)
+		if err != nil {
+			t.Fatalf("Parse(%q) error = %v", src, err)
+		}
+		if _, err := e.Eval(env); err == nil {
+			t.Errorf("Eval(%q) expected error", src)
+		}
+	}
+}
@@ -25,11 +25,12 @@
           pname = "grit";
           version = import ./changelog;
           src = self;
-          vendorHash = "sha256-oVpvup5G7aVAqxDaHAeZAFjKZ+BkTTvaz2N2tE6aKY0=";
+          vendorHash = "sha256-0+UWNQ7znELtU8wE3hfyE8lfrVecB6n0GyVvXSGH2vA=";
           subPackages = [ "." ];
           ldflags = [
             "-s"
             "-w"
+            "-X grit/utils.Version=${import ./changelog}"
           ];
 
           GO_PATH = "${self.outPath}/.go";
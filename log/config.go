@@ -0,0 +1,99 @@
+package log
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// componentKey holds the logger's component, e.g. RUN or EXECUTE.
+const componentKey = "component"
+
+// Options selects where and how logs are written.
+type Options struct {
+	// Level is debug, info, warn or error.
+	Level string
+	// Format is text or json.
+	Format string
+	// File, if set, receives logs instead of stderr and is rotated once it
+	// grows past MaxSizeMB, keeping MaxBackups old files.
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+var (
+	flagLevel      *string
+	flagFormat     *string
+	flagFile       *string
+	flagMaxSizeMB  *int
+	flagMaxBackups *int
+	flagVerbose    *bool
+	flagQuiet      *bool
+)
+
+// RegisterFlags adds the logging flags shared by every command.
+func RegisterFlags(fs *flag.FlagSet) {
+	flagLevel = fs.String("log-level", "", "log level: debug, info, warn or error (default info)")
+	flagFormat = fs.String("log-format", "text", "log format: text or json")
+	flagFile = fs.String("log-file", "", "write logs to this file instead of stderr, rotating it by size")
+	flagMaxSizeMB = fs.Int("log-max-size", 100, "with -log-file, rotate after this many megabytes")
+	flagMaxBackups = fs.Int("log-max-backups", 5, "with -log-file, rotated files to keep")
+	flagVerbose = fs.Bool("verbose", false, "shorthand for -log-level debug")
+	flagQuiet = fs.Bool("quiet", false, "shorthand for -log-level error")
+}
+
+// ApplyFlags configures logging from the flags added by RegisterFlags.
+func ApplyFlags() error {
+	opts := Options{
+		Level:      *flagLevel,
+		Format:     *flagFormat,
+		File:       *flagFile,
+		MaxSizeMB:  *flagMaxSizeMB,
+		MaxBackups: *flagMaxBackups,
+	}
+	if opts.Level == "" {
+		switch {
+		case *flagQuiet:
+			opts.Level = "error"
+		case *flagVerbose:
+			opts.Level = "debug"
+		}
+	}
+	return Configure(opts)
+}
+
+// Configure replaces the logging configuration. An empty Level keeps the
+// current level.
+func Configure(opts Options) error {
+	if opts.Level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(opts.Level)); err != nil {
+			return fmt.Errorf("invalid log level %q", opts.Level)
+		}
+		level.Set(l)
+	}
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		f, err := openRotatingFile(opts.File, int64(opts.MaxSizeMB)<<20, opts.MaxBackups)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		out = f
+	}
+
+	var handler slog.Handler
+	switch opts.Format {
+	case "", "text":
+		handler = newTextHandler(out, level)
+	case "json":
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("invalid log format %q: must be text or json", opts.Format)
+	}
+	root.Store(slog.New(handler))
+	return nil
+}
@@ -1,45 +1,94 @@
+// Package log is grit's logger: levelled, structured and written as text or
+// JSON through log/slog. Loggers are created per component at package level
+// and pick up the configuration from Configure whenever they log, so they can
+// be declared before flags are parsed.
 package log
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
+var (
+	level = new(slog.LevelVar)
+	root  atomic.Pointer[slog.Logger]
+)
+
+func init() {
+	// VERBOSE predates -log-level and still turns on debug output.
+	if os.Getenv("VERBOSE") != "" {
+		level.Set(slog.LevelDebug)
+	}
+	root.Store(slog.New(newTextHandler(os.Stderr, level)))
+}
+
 type MyLogger struct {
-	logger *log.Logger
-	prefix string
+	component string
+	attrs     []any
 }
 
 func NewLogger(prefix string) MyLogger {
-	l := log.New(os.Stderr, fmt.Sprintf("[%s] ", prefix), log.Ldate|log.Lmicroseconds)
+	return MyLogger{component: prefix}
+}
 
-	return MyLogger{l, prefix}
+// With returns a logger that adds the given key-value pairs to every message.
+func (m MyLogger) With(args ...any) MyLogger {
+	attrs := make([]any, 0, len(m.attrs)+len(args))
+	attrs = append(append(attrs, m.attrs...), args...)
+	return MyLogger{component: m.component, attrs: attrs}
 }
 
+// Context returns a logger for a sub-component, such as one step's script.
+func (m MyLogger) Context(c string) MyLogger {
+	return MyLogger{component: m.component + ":" + c, attrs: m.attrs}
+}
+
+func (m MyLogger) Debug(msg string, args ...any) { m.log(slog.LevelDebug, msg, args) }
+func (m MyLogger) Info(msg string, args ...any)  { m.log(slog.LevelInfo, msg, args) }
+func (m MyLogger) Warn(msg string, args ...any)  { m.log(slog.LevelWarn, msg, args) }
+func (m MyLogger) Error(msg string, args ...any) { m.log(slog.LevelError, msg, args) }
+
+// Printf logs a formatted message at info level.
 func (m MyLogger) Printf(format string, v ...any) {
-	m.logger.Printf(format, v...)
+	m.log(slog.LevelInfo, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"), nil)
 }
 
+// Println logs its operands at info level.
 func (m MyLogger) Println(v ...any) {
-	m.logger.Println(v...)
+	m.log(slog.LevelInfo, strings.TrimSuffix(fmt.Sprintln(v...), "\n"), nil)
 }
 
+// Verbosef logs a formatted message at debug level.
 func (m MyLogger) Verbosef(format string, v ...any) {
-	if len(os.Getenv("VERBOSE")) >= 1 {
-		m.Printf(format, v...)
+	if Enabled(slog.LevelDebug) {
+		m.log(slog.LevelDebug, strings.TrimSuffix(fmt.Sprintf(format, v...), "\n"), nil)
 	}
 }
 
+// Verboseln logs its operands at debug level.
 func (m MyLogger) Verboseln(v ...any) {
-	if len(os.Getenv("VERBOSE")) >= 1 {
-		m.logger.Println(v...)
+	if Enabled(slog.LevelDebug) {
+		m.log(slog.LevelDebug, strings.TrimSuffix(fmt.Sprintln(v...), "\n"), nil)
 	}
 }
 
-func (m MyLogger) Context(c string) MyLogger {
-	p := fmt.Sprintf("[%s:%s] ", m.prefix, c)
-	l := log.New(os.Stderr, p, log.Ldate|log.Lmicroseconds|log.Lshortfile)
+// Enabled reports whether messages at l are written.
+func Enabled(l slog.Level) bool {
+	return l >= level.Level()
+}
 
-	return MyLogger{l, p}
+func (m MyLogger) log(l slog.Level, msg string, args []any) {
+	if !Enabled(l) {
+		return
+	}
+	r := slog.NewRecord(time.Now(), l, msg, 0)
+	r.AddAttrs(slog.String(componentKey, m.component))
+	r.Add(m.attrs...)
+	r.Add(args...)
+	root.Load().Handler().Handle(context.Background(), r)
 }
@@ -0,0 +1,111 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// textHandler writes one line per record in grit's historical layout:
+//
+//	[COMPONENT] 2006/01/02 15:04:05.000000 WARN message key=value
+//
+// The level is omitted for info messages.
+type textHandler struct {
+	mu    *sync.Mutex
+	out   io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func newTextHandler(out io.Writer, level slog.Leveler) *textHandler {
+	return &textHandler{mu: new(sync.Mutex), out: out, level: level}
+}
+
+func (h *textHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+	return &clone
+}
+
+// WithGroup is unsupported; grit never groups attributes.
+func (h *textHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var component string
+	var fields bytes.Buffer
+	write := func(a slog.Attr) bool {
+		if a.Key == componentKey {
+			component = a.Value.String()
+			return true
+		}
+		if a.Equal(slog.Attr{}) {
+			return true
+		}
+		fields.WriteByte(' ')
+		fields.WriteString(a.Key)
+		fields.WriteByte('=')
+		fields.WriteString(formatValue(a.Value))
+		return true
+	}
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+
+	var buf bytes.Buffer
+	if component != "" {
+		fmt.Fprintf(&buf, "[%s] ", component)
+	}
+	buf.WriteString(r.Time.Format("2006/01/02 15:04:05.000000"))
+	if r.Level != slog.LevelInfo {
+		buf.WriteByte(' ')
+		buf.WriteString(r.Level.String())
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(r.Message)
+	buf.Write(fields.Bytes())
+	buf.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(buf.Bytes())
+	return err
+}
+
+func formatValue(v slog.Value) string {
+	v = v.Resolve()
+	var s string
+	switch v.Kind() {
+	case slog.KindDuration:
+		s = v.Duration().Round(time.Microsecond).String()
+	case slog.KindTime:
+		s = v.Time().Format(time.RFC3339Nano)
+	default:
+		s = v.String()
+	}
+	if s == "" || needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuote(s string) bool {
+	for _, c := range s {
+		if c <= ' ' || c == '=' || c == '"' || c > '~' {
+			return true
+		}
+	}
+	return false
+}
@@ -5,11 +5,25 @@ import (
 	"fmt"
 	"os"
 
+	"grit/cmd/backup"
 	"grit/cmd/delete_resource"
 	"grit/cmd/export"
+	"grit/cmd/fsck"
+	"grit/cmd/gc"
+	"grit/cmd/graph"
+	"grit/cmd/lineage"
+	"grit/cmd/logs"
+	"grit/cmd/migrate"
 	"grit/cmd/progress"
 	"grit/cmd/prune_resources"
+	"grit/cmd/restore"
 	"grit/cmd/run"
+	"grit/cmd/runs"
+	"grit/cmd/serve"
+	"grit/cmd/snapshot"
+	"grit/cmd/worker"
+	"grit/log"
+	"grit/repo"
 )
 
 func main() {
@@ -24,33 +38,105 @@ func main() {
 	case "run":
 		runCmd := flag.NewFlagSet("run", flag.ExitOnError)
 		run.RegisterFlags(runCmd)
-		runCmd.Parse(os.Args[2:])
+		parseFlags(runCmd)
 		run.Execute()
 
 	case "export":
 		exportCmd := flag.NewFlagSet("export", flag.ExitOnError)
 		export.RegisterFlags(exportCmd)
-		exportCmd.Parse(os.Args[2:])
+		parseFlags(exportCmd)
 		export.Execute()
 
 	case "progress":
 		progressCmd := flag.NewFlagSet("progress", flag.ExitOnError)
 		progress.RegisterFlags(progressCmd)
-		progressCmd.Parse(os.Args[2:])
+		parseFlags(progressCmd)
 		progress.Execute()
 
 	case "delete":
 		deleteResourceCmd := flag.NewFlagSet("delete", flag.ExitOnError)
 		delete_resource.RegisterFlags(deleteResourceCmd)
-		deleteResourceCmd.Parse(os.Args[2:])
+		parseFlags(deleteResourceCmd)
 		delete_resource.Execute()
 
 	case "prune":
 		pruneResourcesCmd := flag.NewFlagSet("prune", flag.ExitOnError)
 		prune_resources.RegisterFlags(pruneResourcesCmd)
-		pruneResourcesCmd.Parse(os.Args[2:])
+		parseFlags(pruneResourcesCmd)
 		prune_resources.Execute()
 
+	case "graph":
+		graphCmd := flag.NewFlagSet("graph", flag.ExitOnError)
+		graph.RegisterFlags(graphCmd)
+		parseFlags(graphCmd)
+		graph.Execute()
+
+	case "serve":
+		serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+		serve.RegisterFlags(serveCmd)
+		parseFlags(serveCmd)
+		serve.Execute()
+
+	case "logs":
+		logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+		logs.RegisterFlags(logsCmd)
+		parseFlags(logsCmd)
+		logs.Execute()
+
+	case "lineage":
+		lineageCmd := flag.NewFlagSet("lineage", flag.ExitOnError)
+		lineage.RegisterFlags(lineageCmd)
+		parseFlags(lineageCmd)
+		lineage.Execute()
+
+	case "fsck":
+		fsckCmd := flag.NewFlagSet("fsck", flag.ExitOnError)
+		fsck.RegisterFlags(fsckCmd)
+		parseFlags(fsckCmd)
+		fsck.Execute()
+
+	case "gc":
+		gcCmd := flag.NewFlagSet("gc", flag.ExitOnError)
+		gc.RegisterFlags(gcCmd)
+		parseFlags(gcCmd)
+		gc.Execute()
+
+	case "migrate":
+		migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+		migrate.RegisterFlags(migrateCmd)
+		parseFlags(migrateCmd)
+		migrate.Execute()
+
+	case "backup":
+		backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+		backup.RegisterFlags(backupCmd)
+		parseFlags(backupCmd)
+		backup.Execute()
+
+	case "restore":
+		restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+		restore.RegisterFlags(restoreCmd)
+		parseFlags(restoreCmd)
+		restore.Execute()
+
+	case "snapshot":
+		snapshotCmd := flag.NewFlagSet("snapshot", flag.ExitOnError)
+		snapshot.RegisterFlags(snapshotCmd)
+		parseFlags(snapshotCmd)
+		snapshot.Execute()
+
+	case "runs":
+		runsCmd := flag.NewFlagSet("runs", flag.ExitOnError)
+		runs.RegisterFlags(runsCmd)
+		parseFlags(runsCmd)
+		runs.Execute()
+
+	case "worker":
+		workerCmd := flag.NewFlagSet("worker", flag.ExitOnError)
+		worker.RegisterFlags(workerCmd)
+		parseFlags(workerCmd)
+		worker.Execute()
+
 	case "help", "-h", "--help":
 		printUsage()
 
@@ -61,6 +147,18 @@ func main() {
 	}
 }
 
+// parseFlags adds the shared logging flags to fs, parses the command line
+// and applies the logging configuration.
+func parseFlags(fs *flag.FlagSet) {
+	log.RegisterFlags(fs)
+	repo.RegisterFlags(fs)
+	fs.Parse(os.Args[2:])
+	if err := log.ApplyFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Name(), err)
+		os.Exit(2)
+	}
+}
+
 func printUsage() {
 	fmt.Println("grit - Task pipeline management system")
 	fmt.Println("\nUsage:")
@@ -71,5 +169,17 @@ func printUsage() {
 	fmt.Println("  progress  Show pipeline progress and statistics")
 	fmt.Println("  delete   Delete resources and unreferenced object blobs")
 	fmt.Println("  prune    Prune old resource versions by keeping newest N")
+	fmt.Println("  graph     Show the step dependency graph")
+	fmt.Println("  logs      Show the captured output of tasks")
+	fmt.Println("  lineage   Trace a resource back through the tasks that produced it")
+	fmt.Println("  serve     Serve the dashboard, HTTP API and worker coordinator")
+	fmt.Println("  worker    Run tasks leased from a coordinator")
+	fmt.Println("  runs      List past runs and show what one of them did")
+	fmt.Println("  fsck      Verify objects and find dangling records")
+	fmt.Println("  gc        Delete objects no resource refers to")
+	fmt.Println("  migrate   Bring a database's key layout up to date")
+	fmt.Println("  backup    Write a full or incremental backup of a database")
+	fmt.Println("  restore   Restore a database from backups and verify it")
+	fmt.Println("  snapshot  Copy a database to a new local repo, hard-linking objects")
 	fmt.Println("\nUse 'grit <command> -h' for more information about a command.")
 }
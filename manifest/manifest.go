@@ -1,15 +1,33 @@
 package manifest
 
 import (
+	"errors"
 	"fmt"
 	"grit/db"
+	"grit/expr"
+	"grit/objstore"
 	"grit/types"
+	"os"
+	"path/filepath"
 	"slices"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml"
 )
 
 type Manifest struct {
 	Steps    []ManifestStep    `toml:"step"`
 	CsvFiles []ManifestCsvFile `toml:"csv"`
+
+	// ObjectStore is where large objects go, such as s3://bucket/prefix, or
+	// "fs" for the repo's objects/ directory. Empty keeps the database's
+	// current store. See db.Database.UseObjectStore.
+	ObjectStore string `toml:"object_store"`
+
+	// Dir is the directory the manifest was loaded from. Relative script
+	// files and command paths are resolved against it.
+	Dir string `toml:"-"`
 }
 
 type ManifestCsvFile struct {
@@ -19,21 +37,330 @@ type ManifestCsvFile struct {
 }
 
 type ManifestStep struct {
-	Name     string `toml:"name"`
-	Script   string `toml:"script"`
-	Parallel *int   `toml:"parallel"`
-	Input    string `toml:"input"`
+	Name        string   `toml:"name"`
+	Script      string   `toml:"script"`
+	ScriptFile  string   `toml:"script_file"`
+	Shell       []string `toml:"shell"`
+	Interpreter string   `toml:"interpreter"`
+	Command     []string `toml:"command"`
+	Parallel    *int     `toml:"parallel"`
+	Input       string   `toml:"input"`
+
+	BatchSize    int    `toml:"batch_size"`
+	BatchTimeout string `toml:"batch_timeout"`
+
+	Mode        string `toml:"mode"`
+	InputFormat string `toml:"input_format"`
+
+	When    string   `toml:"when"`
+	After   []string `toml:"after"`
+	Outputs []string `toml:"outputs"`
+
+	Seed       string   `toml:"seed"`
+	Every      string   `toml:"every"`
+	SeedParams []string `toml:"seed_params"`
+
+	Compress string `toml:"compress"`
+	Chunk    bool   `toml:"chunk"`
+}
+
+// Load reads and parses the manifest at path.
+func Load(path string) (Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	var m Manifest
+	if err := toml.Unmarshal(data, &m); err != nil {
+		return Manifest{}, err
+	}
+	m.Dir = filepath.Dir(path)
+	return m, nil
+}
+
+// Validate checks the manifest for mistakes that would otherwise only show up
+// once a task runs.
+func (manifest Manifest) Validate() error {
+	var errs []error
+	seen := make(map[string]bool)
+	for i, step := range manifest.Steps {
+		if step.Name == "" {
+			errs = append(errs, fmt.Errorf("step #%d has no name", i+1))
+			continue
+		}
+		if seen[step.Name] {
+			errs = append(errs, fmt.Errorf("step %q is defined more than once", step.Name))
+		}
+		seen[step.Name] = true
+
+		sources := 0
+		for _, set := range []bool{step.Script != "", step.ScriptFile != "", len(step.Command) > 0} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			errs = append(errs, fmt.Errorf("step %q must set exactly one of script, script_file or command", step.Name))
+		}
+		if len(step.Shell) > 0 && step.Interpreter != "" {
+			errs = append(errs, fmt.Errorf("step %q sets both shell and interpreter", step.Name))
+		}
+		if len(step.Command) > 0 && (len(step.Shell) > 0 || step.Interpreter != "") {
+			errs = append(errs, fmt.Errorf("step %q: command runs without a shell and cannot be combined with shell or interpreter", step.Name))
+		}
+		if step.BatchSize < 0 {
+			errs = append(errs, fmt.Errorf("step %q: batch_size must be >= 0", step.Name))
+		}
+		if step.BatchTimeout != "" {
+			if _, err := time.ParseDuration(step.BatchTimeout); err != nil {
+				errs = append(errs, fmt.Errorf("step %q: invalid batch_timeout: %w", step.Name, err))
+			} else if step.BatchSize == 0 {
+				errs = append(errs, fmt.Errorf("step %q: batch_timeout requires batch_size", step.Name))
+			}
+		}
+		if step.BatchSize > 0 && step.Input == "" {
+			errs = append(errs, fmt.Errorf("step %q: batch_size requires an input", step.Name))
+		}
+		switch step.Mode {
+		case types.StepModeMap, "map":
+			if step.InputFormat != "" {
+				errs = append(errs, fmt.Errorf("step %q: input_format only applies to reduce steps", step.Name))
+			}
+		case types.StepModeReduce:
+			if step.Input == "" {
+				errs = append(errs, fmt.Errorf("step %q: reduce steps require an input", step.Name))
+			}
+			if step.BatchSize > 0 {
+				errs = append(errs, fmt.Errorf("step %q: reduce steps cannot be batched", step.Name))
+			}
+			if step.InputFormat != "" && step.InputFormat != types.InputFormatDir && step.InputFormat != types.InputFormatConcat {
+				errs = append(errs, fmt.Errorf("step %q: input_format must be %q or %q", step.Name, types.InputFormatDir, types.InputFormatConcat))
+			}
+			if step.Input != "" && !manifest.declares(step.Input) {
+				errs = append(errs, fmt.Errorf("step %q: reduce input %q is not listed in the outputs of any step or CSV file", step.Name, step.Input))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("step %q: unknown mode %q", step.Name, step.Mode))
+		}
+		if step.Input != "" && (step.Seed != "" || step.Every != "" || len(step.SeedParams) > 0) {
+			errs = append(errs, fmt.Errorf("step %q: seed, every and seed_params only apply to steps without an input", step.Name))
+		}
+		switch step.Seed {
+		case "", "once", types.SeedAlways:
+		default:
+			errs = append(errs, fmt.Errorf("step %q: seed must be \"once\" or \"always\"", step.Name))
+		}
+		if step.Every != "" {
+			if step.Seed != "" {
+				errs = append(errs, fmt.Errorf("step %q: every cannot be combined with seed = %q", step.Name, step.Seed))
+			}
+			if d, err := time.ParseDuration(step.Every); err != nil {
+				errs = append(errs, fmt.Errorf("step %q: invalid every: %w", step.Name, err))
+			} else if d <= 0 {
+				errs = append(errs, fmt.Errorf("step %q: every must be positive", step.Name))
+			}
+		}
+		switch step.Compress {
+		case "", "auto", types.CompressZstd, types.CompressNone:
+		default:
+			errs = append(errs, fmt.Errorf("step %q: compress must be \"auto\", \"zstd\" or \"none\"", step.Name))
+		}
+		if step.When != "" {
+			if step.Input == "" {
+				errs = append(errs, fmt.Errorf("step %q: when requires an input", step.Name))
+			}
+			if step.Mode == types.StepModeReduce {
+				errs = append(errs, fmt.Errorf("step %q: when cannot be used on reduce steps", step.Name))
+			}
+			if _, err := expr.Parse(step.When); err != nil {
+				errs = append(errs, fmt.Errorf("step %q: invalid when expression: %w", step.Name, err))
+			}
+		}
+	}
+
+	for _, step := range manifest.Steps {
+		for _, dep := range step.After {
+			if dep == step.Name {
+				errs = append(errs, fmt.Errorf("step %q lists itself in after", step.Name))
+			} else if !seen[dep] {
+				errs = append(errs, fmt.Errorf("step %q: after references unknown step %q", step.Name, dep))
+			}
+		}
+	}
+	if cycle := manifest.afterCycle(); cycle != nil {
+		errs = append(errs, fmt.Errorf("after dependencies form a cycle: %s", strings.Join(cycle, " -> ")))
+	}
+	if manifest.ObjectStore != "" && manifest.ObjectStore != "fs" {
+		if _, err := objstore.Open(manifest.ObjectStore); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// declares reports whether a CSV file or the outputs of some step name the
+// given resource.
+func (manifest Manifest) declares(name string) bool {
+	for _, csv := range manifest.CsvFiles {
+		if csv.Output == name {
+			return true
+		}
+	}
+	for _, step := range manifest.Steps {
+		if slices.Contains(step.Outputs, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// afterCycle returns the first cycle in the after graph, starting and ending
+// at the same step, or nil. Self-references are reported by Validate directly.
+func (manifest Manifest) afterCycle() []string {
+	after := make(map[string][]string)
+	for _, step := range manifest.Steps {
+		after[step.Name] = step.After
+	}
+
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = active
+		path = append(path, name)
+		for _, dep := range after[name] {
+			if dep == name {
+				continue
+			}
+			switch state[dep] {
+			case active:
+				start := slices.Index(path, dep)
+				return append(slices.Clone(path[start:]), dep)
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+		return nil
+	}
+
+	for _, step := range manifest.Steps {
+		if state[step.Name] == unvisited {
+			if cycle := visit(step.Name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// resolveScript returns the script text for a step, reading script_file
+// relative to the manifest directory when set.
+func (manifest Manifest) resolveScript(step ManifestStep) (string, error) {
+	if step.ScriptFile == "" {
+		return step.Script, nil
+	}
+	path := step.ScriptFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(manifest.Dir, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read script file for step %s: %w", step.Name, err)
+	}
+	return string(data), nil
+}
+
+// resolveCommand returns a step's command with a relative program path, such
+// as ./bin/tool, resolved against the manifest directory like script_file.
+// A bare program name is still looked up in PATH.
+func (manifest Manifest) resolveCommand(step ManifestStep) []string {
+	if len(step.Command) == 0 {
+		return step.Command
+	}
+	program := step.Command[0]
+	if filepath.IsAbs(program) || !strings.ContainsRune(program, filepath.Separator) {
+		return step.Command
+	}
+	command := append([]string{}, step.Command...)
+	command[0] = filepath.Join(manifest.Dir, program)
+	return command
 }
 
 func (manifest Manifest) RegisterSteps(database *db.Database, enabledSteps []string) []types.Step {
 	// Register all steps from manifest
 	var steps []types.Step
 	for _, manifestStep := range manifest.Steps {
+		script, err := manifest.resolveScript(manifestStep)
+		if err != nil {
+			panic(err)
+		}
+
+		var batchTimeout time.Duration
+		if manifestStep.BatchTimeout != "" {
+			batchTimeout, err = time.ParseDuration(manifestStep.BatchTimeout)
+			if err != nil {
+				panic(err)
+			}
+		}
+
+		seed := types.SeedOnce
+		var seedEvery time.Duration
+		switch {
+		case manifestStep.Every != "":
+			seed = types.SeedEvery
+			seedEvery, err = time.ParseDuration(manifestStep.Every)
+			if err != nil {
+				panic(err)
+			}
+		case manifestStep.Seed == types.SeedAlways:
+			seed = types.SeedAlways
+		}
+
+		compress := manifestStep.Compress
+		if compress == "auto" {
+			compress = types.CompressAuto
+		}
+		mode := manifestStep.Mode
+		if mode == "map" {
+			mode = types.StepModeMap
+		}
+
 		step := types.Step{
-			Name:     manifestStep.Name,
-			Script:   manifestStep.Script,
-			Parallel: manifestStep.Parallel,
-			Input:    manifestStep.Input,
+			Name:        manifestStep.Name,
+			Script:      script,
+			ScriptFile:  manifestStep.ScriptFile,
+			Shell:       manifestStep.Shell,
+			Interpreter: manifestStep.Interpreter,
+			Command:     manifest.resolveCommand(manifestStep),
+			Parallel:    manifestStep.Parallel,
+			Input:       manifestStep.Input,
+
+			BatchSize:    manifestStep.BatchSize,
+			BatchTimeout: batchTimeout,
+
+			Mode:        mode,
+			InputFormat: manifestStep.InputFormat,
+
+			When:    manifestStep.When,
+			After:   manifestStep.After,
+			Outputs: manifestStep.Outputs,
+
+			Seed:       seed,
+			SeedEvery:  seedEvery,
+			SeedParams: manifestStep.SeedParams,
+
+			Compress: compress,
+			Chunk:    manifestStep.Chunk,
 		}
 
 		id, err := database.CreateStep(step)
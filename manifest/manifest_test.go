@@ -0,0 +1,25 @@
+package manifest
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveCommand(t *testing.T) {
+	manifest := Manifest{Dir: "/work/flows"}
+	tests := []struct {
+		command []string
+		want    []string
+	}{
+		{[]string{"./bin/tool", "--flag"}, []string{"/work/flows/bin/tool", "--flag"}},
+		{[]string{"scripts/run.sh"}, []string{"/work/flows/scripts/run.sh"}},
+		{[]string{"/usr/bin/env", "python3"}, []string{"/usr/bin/env", "python3"}},
+		{[]string{"python3", "./score.py"}, []string{"python3", "./score.py"}},
+	}
+	for _, tt := range tests {
+		got := manifest.resolveCommand(ManifestStep{Name: "tool", Command: tt.command})
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("resolveCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
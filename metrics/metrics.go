@@ -0,0 +1,302 @@
+// Package metrics is a small Prometheus client: counters, histograms and
+// gauges read at scrape time, written in the text exposition format. Metrics
+// register themselves with the Default registry when created, so packages
+// declare them as package-level variables next to the code they measure.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector writes one metric family.
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds the metrics served by Handler.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+// Default is the registry metrics register with.
+var Default = &Registry{}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.collectors {
+		if existing.name() == c.name() {
+			panic(fmt.Sprintf("metrics: %s registered twice", c.name()))
+		}
+	}
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every metric in the text exposition format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Handler serves the Default registry.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// series is the state of one label combination.
+type series struct {
+	labels []string
+	value  float64
+	// Histograms only.
+	buckets []uint64
+	count   uint64
+}
+
+// family is the shared part of labelled metrics.
+type family struct {
+	metricName string
+	help       string
+	kind       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newFamily(name, help, kind string, labelNames []string) family {
+	return family{metricName: name, help: help, kind: kind, labelNames: labelNames, series: make(map[string]*series)}
+}
+
+func (f *family) name() string { return f.metricName }
+
+// get returns the series for labelValues. The caller must hold f.mu.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", f.metricName, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\x00")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series in label order. The caller must hold f.mu.
+func (f *family) sorted() []*series {
+	out := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return strings.Join(out[i].labels, "\x00") < strings.Join(out[j].labels, "\x00")
+	})
+	return out
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.metricName, f.help, f.metricName, f.kind)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	family
+}
+
+// NewCounterVec creates and registers a counter.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return newCounterVec(Default, name, help, labelNames...)
+}
+
+func newCounterVec(r *Registry, name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{newFamily(name, help, "counter", labelNames)}
+	r.register(c)
+	return c
+}
+
+// Add increases the counter for labelValues by v.
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	c.get(labelValues).value += v
+	c.mu.Unlock()
+}
+
+// Inc increases the counter for labelValues by one.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, s := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, labelString(c.labelNames, s.labels, "", ""), formatFloat(s.value))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	family
+	bounds []float64
+}
+
+// DurationBuckets suits task durations in seconds, from 10ms to an hour.
+var DurationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// NewHistogramVec creates and registers a histogram with the given upper
+// bucket bounds, which must be sorted.
+func NewHistogramVec(name, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	return newHistogramVec(Default, name, help, bounds, labelNames...)
+}
+
+func newHistogramVec(r *Registry, name, help string, bounds []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{family: newFamily(name, help, "histogram", labelNames), bounds: bounds}
+	r.register(h)
+	return h
+}
+
+// Observe records v for labelValues.
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+	for i, bound := range h.bounds {
+		if v <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, s := range h.sorted() {
+		for i, bound := range h.bounds {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labelNames, s.labels, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelString(h.labelNames, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, labelString(h.labelNames, s.labels, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, labelString(h.labelNames, s.labels, "", ""), s.count)
+	}
+}
+
+// Sample is one labelled value reported by a GaugeFunc.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc is a gauge whose samples are read at scrape time.
+type GaugeFunc struct {
+	metricName string
+	help       string
+	labelNames []string
+
+	mu      sync.Mutex
+	collect func() []Sample
+}
+
+// NewGaugeFunc creates and registers a gauge. Until Set is called it reports
+// nothing.
+func NewGaugeFunc(name, help string, labelNames ...string) *GaugeFunc {
+	return newGaugeFunc(Default, name, help, labelNames...)
+}
+
+func newGaugeFunc(r *Registry, name, help string, labelNames ...string) *GaugeFunc {
+	g := &GaugeFunc{metricName: name, help: help, labelNames: labelNames}
+	r.register(g)
+	return g
+}
+
+// Set replaces the function the gauge reads its samples from.
+func (g *GaugeFunc) Set(collect func() []Sample) {
+	g.mu.Lock()
+	g.collect = collect
+	g.mu.Unlock()
+}
+
+func (g *GaugeFunc) name() string { return g.metricName }
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.mu.Lock()
+	collect := g.collect
+	g.mu.Unlock()
+	if collect == nil {
+		return
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.metricName, g.help, g.metricName)
+	for _, s := range collect() {
+		fmt.Fprintf(w, "%s%s %s\n", g.metricName, labelString(g.labelNames, s.Labels, "", ""), formatFloat(s.Value))
+	}
+}
+
+// labelString formats {name="value",...}, with an optional extra label.
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// escapeLabel leaves only characters that %q writes the way the exposition
+// format expects: it escapes backslash, quote and newline the same way, but
+// would turn other control characters into Go escapes.
+func escapeLabel(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' {
+			return ' '
+		}
+		return r
+	}, v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
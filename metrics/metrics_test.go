@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	registry := &Registry{}
+	counter := newCounterVec(registry, "test_events_total", "Events.", "kind")
+	counter.Inc("b")
+	counter.Add(2, "a")
+
+	histogram := newHistogramVec(registry, "test_duration_seconds", "Durations.", []float64{1, 10}, "step")
+	histogram.Observe(0.5, "x")
+	histogram.Observe(5, "x")
+	histogram.Observe(50, "x")
+
+	gauge := newGaugeFunc(registry, "test_depth", "Depth.")
+	gauge.Set(func() []Sample { return []Sample{{Value: 3}} })
+
+	var out strings.Builder
+	registry.Write(&out)
+
+	for _, want := range []string{
+		"# TYPE test_events_total counter\ntest_events_total{kind=\"a\"} 2\ntest_events_total{kind=\"b\"} 1\n",
+		"test_duration_seconds_bucket{step=\"x\",le=\"1\"} 1\n",
+		"test_duration_seconds_bucket{step=\"x\",le=\"10\"} 2\n",
+		"test_duration_seconds_bucket{step=\"x\",le=\"+Inf\"} 3\n",
+		"test_duration_seconds_sum{step=\"x\"} 55.5\n",
+		"test_duration_seconds_count{step=\"x\"} 3\n",
+		"# TYPE test_depth gauge\ntest_depth 3\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("exposition missing %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package objstore
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS keeps objects as read-only files under a directory, fanned out by hash
+// prefix, as aaa/bbb/ccc/rest by default, so no directory grows too large.
+type FS struct {
+	root   string
+	layout []int
+}
+
+// NewFS returns a store rooted at root, which is created on the first Put.
+// layout is the number of hex characters of each directory level; nil
+// means 3/3/3.
+func NewFS(root string, layout []int) *FS {
+	if len(layout) == 0 {
+		layout = []int{3, 3, 3}
+	}
+	return &FS{root: root, layout: layout}
+}
+
+func (s *FS) Name() string { return "fs" }
+
+// Path returns the file an object is kept in.
+func (s *FS) Path(hash string) string {
+	parts := make([]string, 0, len(s.layout)+2)
+	parts = append(parts, s.root)
+	start := 0
+	for _, width := range s.layout {
+		parts = append(parts, hash[start:start+width])
+		start += width
+	}
+	return filepath.Join(append(parts, hash[start:])...)
+}
+
+func (s *FS) Put(hash string, data []byte) error {
+	path := s.Path(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create object dir: %w", err)
+	}
+	// Write to temp file then rename for atomicity.
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0444); err != nil {
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to rename object file: %w", err)
+	}
+	return nil
+}
+
+func (s *FS) Open(hash string) (io.ReadSeekCloser, error) {
+	return os.Open(s.Path(hash))
+}
+
+func (s *FS) Stat(hash string) (Info, error) {
+	info, err := os.Stat(s.Path(hash))
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Key: hash, Hash: hash, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes an object, or a listed file by its path.
+func (s *FS) Delete(key string) error {
+	path := key
+	if isHash(key) {
+		path = s.Path(key)
+	} else if rel, err := filepath.Rel(s.root, key); err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is not in the object store", key)
+	}
+	err := os.Remove(path)
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// List reports every file under the root. Files other than objects and
+// temporary files are keyed by their path.
+func (s *FS) List(fn func(Info) error) error {
+	return filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		item := Info{Key: path, Size: info.Size(), ModTime: info.ModTime()}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		if hash := strings.ReplaceAll(filepath.ToSlash(rel), "/", ""); isHash(hash) && s.Path(hash) == path {
+			item.Key, item.Hash = hash, hash
+		} else {
+			item.Partial = strings.HasSuffix(path, ".tmp")
+		}
+		return fn(item)
+	})
+}
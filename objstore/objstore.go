@@ -0,0 +1,79 @@
+// Package objstore holds the content of objects too large to keep inline in
+// Badger. An object is named by the hex SHA-256 of its content; what is
+// stored under the name (an object header, compressed bytes, a chunk list)
+// is up to the caller.
+//
+// The database records with each object the Name of the store it went to, so
+// objects stay readable after the store new objects go to changes.
+package objstore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+)
+
+// Store is a place to keep objects.
+type Store interface {
+	// Name identifies the kind of store and is recorded with every object
+	// put in it.
+	Name() string
+	// Put stores data under hash. A failed Put leaves no object behind,
+	// though it may leave a partial entry for List to report.
+	Put(hash string, data []byte) error
+	// Open returns a reader over what is stored under hash.
+	Open(hash string) (io.ReadSeekCloser, error)
+	// Stat describes what is stored under hash.
+	Stat(hash string) (Info, error)
+	// Delete removes an object, or a listed entry by its Key. Deleting
+	// something that does not exist is not an error.
+	Delete(key string) error
+	// List calls fn for every entry in the store, in no particular order.
+	List(fn func(Info) error) error
+}
+
+// Names lists the Name of every kind of store.
+var Names = []string{"fs", "s3"}
+
+// Info describes an entry of a store. Missing entries are reported with an
+// error matching fs.ErrNotExist.
+type Info struct {
+	// Key names the entry for Delete. For objects it is the hash.
+	Key string
+	// Hash is the object's hash, or empty for entries that are not objects.
+	Hash    string
+	Size    int64
+	ModTime time.Time
+	// Partial marks what an interrupted Put left behind.
+	Partial bool
+}
+
+// Open returns the store a URI describes:
+//
+//	s3://bucket/prefix?endpoint=host:port&region=us-east-1&insecure=true
+//
+// Only S3-compatible stores are opened this way; the local store is always
+// the repo's objects/ directory.
+func Open(uri string) (Store, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid object store %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3FromURL(u)
+	default:
+		return nil, fmt.Errorf("unsupported object store %q: want s3://bucket/prefix", uri)
+	}
+}
+
+// isHash reports whether s is a hex SHA-256.
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
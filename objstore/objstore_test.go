@@ -0,0 +1,87 @@
+package objstore_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+
+	"grit/objstore"
+	"grit/objstore/s3test"
+)
+
+func TestStores(t *testing.T) {
+	server := s3test.NewServer()
+	defer server.Close()
+	s3, err := objstore.NewS3(server.Config("objects", "repo/"))
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+
+	for _, store := range []objstore.Store{objstore.NewFS(t.TempDir(), nil), s3} {
+		t.Run(store.Name(), func(t *testing.T) {
+			data := []byte("stored bytes, whatever they encode")
+			sum := sha256.Sum256(data)
+			hash := hex.EncodeToString(sum[:])
+			if err := store.Put(hash, data); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			r, err := store.Open(hash)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if _, err := r.Seek(7, io.SeekStart); err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			got, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(got, data[7:]) {
+				t.Errorf("read after Seek() = %q, %v; want %q", got, err, data[7:])
+			}
+
+			info, err := store.Stat(hash)
+			if err != nil || info.Size != int64(len(data)) || info.ModTime.IsZero() {
+				t.Errorf("Stat() = %+v, %v; want %d bytes", info, err, len(data))
+			}
+
+			var listed []objstore.Info
+			err = store.List(func(info objstore.Info) error {
+				listed = append(listed, info)
+				return nil
+			})
+			if err != nil || len(listed) != 1 || listed[0].Hash != hash || listed[0].Partial {
+				t.Errorf("List() = %+v, %v; want the object", listed, err)
+			}
+
+			if err := store.Delete(hash); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := store.Delete(hash); err != nil {
+				t.Errorf("Delete(deleted) error = %v", err)
+			}
+			if _, err := store.Open(hash); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Open(deleted) error = %v, want fs.ErrNotExist", err)
+			}
+			if _, err := store.Stat(hash); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Stat(deleted) error = %v, want fs.ErrNotExist", err)
+			}
+		})
+	}
+}
+
+func TestOpenURI(t *testing.T) {
+	store, err := objstore.Open("s3://bucket/some/prefix?endpoint=localhost:9000&insecure=true")
+	if err != nil || store.Name() != "s3" {
+		t.Errorf("Open(s3) = %v, %v; want an s3 store", store, err)
+	}
+	if _, err := objstore.Open("gs://bucket"); err == nil {
+		t.Errorf("Open(gs) succeeded, want an error")
+	}
+	if _, err := objstore.Open("s3:///prefix"); err == nil {
+		t.Errorf("Open(no bucket) succeeded, want an error")
+	}
+}
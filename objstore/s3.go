@@ -0,0 +1,147 @@
+package objstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config describes a bucket in an S3-compatible store.
+type S3Config struct {
+	// Endpoint is host[:port], s3.amazonaws.com by default.
+	Endpoint string
+	Bucket   string
+	// Prefix is prepended to every object name.
+	Prefix string
+	Region string
+	// Insecure uses plain HTTP, for local stand-ins such as MinIO.
+	Insecure bool
+	// Credentials default to the AWS_ and MINIO_ environment variables,
+	// then the AWS shared credentials file.
+	Credentials *credentials.Credentials
+}
+
+// S3 keeps objects in a bucket of an S3-compatible store, named by prefix
+// and hash.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a store for cfg. It does not contact the endpoint.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3 object store needs a bucket")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "s3.amazonaws.com"
+	}
+	if cfg.Credentials == nil {
+		cfg.Credentials = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+		})
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  cfg.Credentials,
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create s3 client: %w", err)
+	}
+	return &S3{client: client, bucket: cfg.Bucket, prefix: cfg.Prefix}, nil
+}
+
+func newS3FromURL(u *url.URL) (*S3, error) {
+	query := u.Query()
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return NewS3(S3Config{
+		Endpoint: query.Get("endpoint"),
+		Bucket:   u.Host,
+		Prefix:   prefix,
+		Region:   query.Get("region"),
+		Insecure: query.Get("insecure") == "true",
+	})
+}
+
+func (s *S3) Name() string { return "s3" }
+
+func (s *S3) Put(hash string, data []byte) error {
+	_, err := s.client.PutObject(context.Background(), s.bucket, s.prefix+hash, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) Open(hash string) (io.ReadSeekCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.prefix+hash, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s.wrap(hash, err)
+	}
+	// GetObject is lazy; Stat surfaces a missing object now rather than on
+	// the first Read.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s.wrap(hash, err)
+	}
+	return obj, nil
+}
+
+func (s *S3) Stat(hash string) (Info, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.prefix+hash, minio.StatObjectOptions{})
+	if err != nil {
+		return Info{}, s.wrap(hash, err)
+	}
+	return Info{Key: hash, Hash: hash, Size: info.Size, ModTime: info.LastModified}, nil
+}
+
+func (s *S3) Delete(key string) error {
+	err := s.client.RemoveObject(context.Background(), s.bucket, s.prefix+key, minio.RemoveObjectOptions{})
+	if err != nil && !errors.Is(s.wrap(key, err), fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) List(fn func(Info) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return fmt.Errorf("failed to list objects: %w", obj.Err)
+		}
+		item := Info{Key: strings.TrimPrefix(obj.Key, s.prefix), Size: obj.Size, ModTime: obj.LastModified}
+		if isHash(item.Key) {
+			item.Hash = item.Key
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wrap makes a missing object match fs.ErrNotExist, the way the FS store
+// reports it.
+func (s *S3) wrap(key string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return &fs.PathError{Op: "open", Path: "s3://" + s.bucket + "/" + s.prefix + key, Err: fs.ErrNotExist}
+	}
+	return err
+}
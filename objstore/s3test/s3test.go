@@ -0,0 +1,206 @@
+// Package s3test runs an in-memory stand-in for an S3-compatible store,
+// enough of the API for objstore.S3: put, get with ranges, head, delete and
+// list. Requests are not authenticated.
+package s3test
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grit/objstore"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Server is a running stand-in. Buckets exist on first use.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string]object // by bucket/key
+}
+
+type object struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer() *Server {
+	s := &Server{objects: make(map[string]object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Config returns the configuration of an objstore.S3 for bucket and prefix
+// on this server.
+func (s *Server) Config(bucket, prefix string) objstore.S3Config {
+	u, _ := url.Parse(s.URL)
+	return objstore.S3Config{
+		Endpoint:    u.Host,
+		Bucket:      bucket,
+		Prefix:      prefix,
+		Region:      "us-east-1",
+		Insecure:    true,
+		Credentials: credentials.NewStaticV4("test", "testsecret", ""),
+	}
+}
+
+// URI returns the object store URI of bucket and prefix on this server, as
+// accepted by objstore.Open. Credentials come from the environment.
+func (s *Server) URI(bucket, prefix string) string {
+	u, _ := url.Parse(s.URL)
+	return fmt.Sprintf("s3://%s/%s?endpoint=%s&region=us-east-1&insecure=true", bucket, prefix, u.Host)
+}
+
+// Len returns the number of objects stored.
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		s.list(w, r, bucket)
+	case key == "":
+		// Bucket operations other than listing always succeed.
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodPut:
+		s.put(w, r, bucket+"/"+key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.get(w, r, bucket+"/"+key)
+	case r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(s.objects, bucket+"/"+key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.URL.Path)
+	}
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", name)
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		if body, err = decodeChunked(body); err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", name)
+			return
+		}
+	}
+	sum := md5.Sum(body)
+	obj := object{data: body, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modTime: time.Now().UTC().Truncate(time.Second)}
+	s.mu.Lock()
+	s.objects[name] = obj
+	s.mu.Unlock()
+	w.Header().Set("ETag", obj.etag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, name string) {
+	s.mu.Lock()
+	obj, ok := s.objects[name]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", name)
+		return
+	}
+	w.Header().Set("ETag", obj.etag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", obj.modTime, bytes.NewReader(obj.data))
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listEntry
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+	res := listResult{Name: bucket, Prefix: prefix, MaxKeys: 1000}
+	s.mu.Lock()
+	for name, obj := range s.objects {
+		key, ok := strings.CutPrefix(name, bucket+"/")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		res.Contents = append(res.Contents, listEntry{
+			Key:          key,
+			LastModified: obj.modTime.Format(time.RFC3339),
+			ETag:         obj.etag,
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+	sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+	res.KeyCount = len(res.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func writeError(w http.ResponseWriter, status int, code, resource string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Resource>%s</Resource></Error>", code, resource)
+}
+
+// decodeChunked strips the aws-chunked framing of a streaming-signed upload:
+// a hex length and signature before each chunk.
+func decodeChunked(body []byte) ([]byte, error) {
+	var out []byte
+	br := bufio.NewReader(bytes.NewReader(body))
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(size, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return out, nil
+		}
+		chunk := make([]byte, n+2) // and the trailing CRLF
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		out = append(out, chunk[:n]...)
+	}
+}
@@ -0,0 +1,11 @@
+package pipeline
+
+import (
+	"grit/metrics"
+)
+
+var (
+	tasksStarted  = metrics.NewCounterVec("grit_tasks_started_total", "Tasks started, by step.", "step")
+	tasksFinished = metrics.NewCounterVec("grit_tasks_finished_total", "Tasks finished, by step and outcome (succeeded, failed or skipped).", "step", "outcome")
+	taskDuration  = metrics.NewHistogramVec("grit_task_duration_seconds", "Task run time, by step.", metrics.DurationBuckets, "step")
+)
@@ -1,13 +1,19 @@
 package pipeline
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"grit/db"
 	"grit/exec"
 	"grit/log"
+	"grit/tracing"
 	"grit/types"
 
 	"github.com/danhab99/idk/workers"
@@ -18,73 +24,163 @@ var pipelineLogger = log.NewLogger("PIPELINE")
 type Pipeline struct {
 	database *db.Database
 	executor *exec.ScriptExecutor
-}
+	opts     Options
+	// startedAt marks the start of this run for the "always" seed policy.
+	startedAt time.Time
 
-func NewPipeline(executor *exec.ScriptExecutor, database *db.Database) (*Pipeline, error) {
-	return &Pipeline{database, executor}, nil
+	tallyMu sync.Mutex
+	tally   map[string]*types.RunStep
 }
 
-func (p *Pipeline) ExecuteStep(step types.Step, maxParallel int) int64 {
-	database := p.database
+// Options narrows what a pipeline run executes. The zero value runs
+// everything.
+type Options struct {
+	// Sample, when positive, runs each map step on a deterministic sample of
+	// this many inputs (chosen by SampleSeed) instead of scheduling them all.
+	Sample     int
+	SampleSeed int64
+	// Source is the database inputs are sampled from. Defaults to the
+	// pipeline database; set it when tasks are written to an overlay.
+	Source *db.Database
 
-	if step.Input == "" {
-		pipelineLogger.Printf("Executing seed step %s\n", step.Name)
+	// Shard and Shards restrict execution to tasks whose input hashes into
+	// shard Shard (1-based) of Shards. Other tasks are left unprocessed.
+	Shard, Shards int
+}
 
-		var startTask types.Task
+func NewPipeline(executor *exec.ScriptExecutor, database *db.Database, opts Options) (*Pipeline, error) {
+	if opts.Source == nil {
+		opts.Source = database
+	}
+	return &Pipeline{
+		database:  database,
+		executor:  executor,
+		opts:      opts,
+		startedAt: time.Now(),
+		tally:     make(map[string]*types.RunStep),
+	}, nil
+}
 
-		startStepCount, err := database.CountTasksForStep(step.ID)
-		if err != nil {
-			panic(err)
-		}
+// count adds to the tasks step scheduled or executed during this run.
+func (p *Pipeline) count(step types.Step, add func(*types.RunStep)) {
+	p.tallyMu.Lock()
+	defer p.tallyMu.Unlock()
+	t, ok := p.tally[step.ID]
+	if !ok {
+		t = &types.RunStep{StepID: step.ID, Name: step.Name, Version: step.Version}
+		p.tally[step.ID] = t
+	}
+	add(t)
+}
 
-		if startStepCount > 0 {
-			startTask = <-database.GetTasksForStep(step.ID)
+// Tally returns what this run did with each of steps, in order.
+func (p *Pipeline) Tally(steps []types.Step) []types.RunStep {
+	p.tallyMu.Lock()
+	defer p.tallyMu.Unlock()
+	out := make([]types.RunStep, 0, len(steps))
+	for _, step := range steps {
+		if t, ok := p.tally[step.ID]; ok {
+			out = append(out, *t)
 		} else {
-			t, err := database.CreateAndGetTask(types.Task{
-				StepID: step.ID,
-			})
-			if err != nil {
-				panic(err)
-			}
-			startTask = *t
+			out = append(out, types.RunStep{StepID: step.ID, Name: step.Name, Version: step.Version})
 		}
+	}
+	return out
+}
 
-		if !startTask.Processed {
-			err = p.executor.Execute(startTask, step)
-			var errorMsg *string
-			if err != nil {
-				msg := err.Error()
-				errorMsg = &msg
-				pipelineLogger.Printf("Seed task %s failed: %v\n", startTask.ID, err)
-			}
-
-			// Mark the seed task as processed
-			err = database.UpdateTaskStatus(startTask.ID, true, errorMsg)
-			if err != nil {
-				pipelineLogger.Printf("Error updating seed task %s: %v\n", startTask.ID, err)
-			}
-			return 1
+// ScheduleStep creates whatever tasks are due for step: seed runs according
+// to its seed policy (runStart is when the current run began), or tasks for
+// new inputs. A step whose after dependencies are not quiescent yet is left
+// alone and reported as not ready.
+func ScheduleStep(database *db.Database, step types.Step, runStart time.Time) (int64, bool, error) {
+	if len(step.After) > 0 {
+		ready, blocking, err := database.AreStepsQuiescent(step.After)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to check dependencies of step %s: %w", step.Name, err)
+		}
+		if !ready {
+			pipelineLogger.Debug("Waiting for dependencies", "step", step.Name, "blocking", blocking)
+			return 0, false, nil
 		}
-
-		return 0
 	}
 
-	// Schedule new tasks for this step
-	tasksCreated, err := database.ScheduleTasksForStep(step.ID)
-	if err != nil {
-		pipelineLogger.Printf("Error scheduling tasks for step %s: %v\n", step.Name, err)
-		return 0
+	var created int64
+	var err error
+	if step.Input == "" {
+		created, err = database.ScheduleSeedTasks(step, runStart)
+	} else {
+		created, err = database.ScheduleTasksForStep(step.ID)
 	}
+	return created, true, err
+}
 
-	if tasksCreated > 0 {
-		pipelineLogger.Printf("Step %s: scheduled %d new tasks\n", step.Name, tasksCreated)
+// FlushHeldBatches writes the partial batches that steps with a batch_timeout
+// are holding back and returns how many tasks that created. The tasks are
+// left for the next ExecuteStep.
+func (p *Pipeline) FlushHeldBatches(steps []types.Step) (int64, error) {
+	var total int64
+	for _, step := range steps {
+		if !step.Batched() || step.BatchTimeout == 0 {
+			continue
+		}
+		created, err := p.database.FlushBatchesForStep(step.ID)
+		if err != nil {
+			return total, fmt.Errorf("failed to flush batches of step %s: %w", step.Name, err)
+		}
+		if created > 0 {
+			p.count(step, func(t *types.RunStep) { t.Scheduled += created })
+			pipelineLogger.Info("Flushed held batch", "step", step.Name, "tasks", created)
+		}
+		total += created
 	}
+	return total, nil
+}
 
-	err = database.ForceSaveWAL()
-	if err != nil {
-		panic(err)
+// ExecuteStep schedules and runs the step's pending tasks and returns how
+// many it executed. The step is traced as a child of any span in ctx.
+func (p *Pipeline) ExecuteStep(ctx context.Context, step types.Step, maxParallel int) (executed int64) {
+	ctx, span := tracing.Start(ctx, "step",
+		tracing.StepKey.String(step.Name),
+		tracing.StepVersionKey.Int(step.Version),
+	)
+	defer func() {
+		span.SetAttributes(tracing.ExecutedKey.Int64(executed))
+		span.End()
+	}()
+	database := p.database
+
+	var taskChan chan types.Task
+	if p.opts.Sample > 0 {
+		taskChan = p.sampleTasks(step)
+		if taskChan == nil {
+			return 0
+		}
+	} else {
+		_, scheduleSpan := tracing.Start(ctx, "schedule")
+		tasksCreated, ready, err := ScheduleStep(database, step, p.startedAt)
+		tracing.End(scheduleSpan, err)
+		if err != nil {
+			pipelineLogger.Error("Failed to schedule tasks", "step", step.Name, "error", err)
+			return 0
+		}
+		if !ready {
+			return 0
+		}
+
+		if tasksCreated > 0 {
+			p.count(step, func(t *types.RunStep) { t.Scheduled += tasksCreated })
+			pipelineLogger.Info("Scheduled new tasks", "step", step.Name, "tasks", tasksCreated)
+		}
+
+		err = database.ForceSaveWAL()
+		if err != nil {
+			panic(err)
+		}
+		taskChan = database.GetUnprocessedTasks(step.ID)
+	}
+	if p.opts.Shards > 1 {
+		taskChan = p.shardFilter(taskChan)
 	}
-	taskChan := database.GetUnprocessedTasks(step.ID)
 
 	var executionCount atomic.Int64
 	pr := step.Parallel
@@ -95,8 +191,10 @@ func (p *Pipeline) ExecuteStep(step types.Step, maxParallel int) int64 {
 
 	// Streaming flusher: writes status updates in bounded batches as tasks
 	// complete, so the in-memory buffer never grows larger than 2×flushBatch
-	// regardless of how many tasks the step contains.
+	// regardless of how many tasks the step contains. Slow steps are flushed
+	// every flushInterval too, so progress stays current.
 	const flushBatch = 500
+	const flushInterval = 2 * time.Second
 	updateCh := make(chan db.TaskStatusUpdate, flushBatch*2)
 
 	var flusherDone sync.WaitGroup
@@ -114,28 +212,58 @@ func (p *Pipeline) ExecuteStep(step types.Step, maxParallel int) int64 {
 			}
 			buf = buf[:0]
 		}
-		for u := range updateCh {
-			buf = append(buf, u)
-			if len(buf) >= flushBatch {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case u, ok := <-updateCh:
+				if !ok {
+					flush()
+					return
+				}
+				buf = append(buf, u)
+				if len(buf) >= flushBatch {
+					flush()
+				}
+			case <-ticker.C:
 				flush()
 			}
 		}
-		flush()
 	}()
 
 	workers.Parallel0(taskChan, *pr, func(task types.Task) {
-		pipelineLogger.Verbosef("Executing task %s for step %s\n", task.ID, step.Name)
+		logger := pipelineLogger.With("step", step.Name, "task_id", task.ID)
+		if task.InputResourceID != nil {
+			logger = logger.With("resource", *task.InputResourceID)
+		}
+		logger.Debug("Executing task")
 
-		execErr := p.executor.Execute(task, step)
+		tasksStarted.Inc(step.Name)
+		database.TaskStarted(step.ID)
+		started := time.Now()
+		execErr := p.executor.Execute(ctx, task, step)
+		database.TaskStopped(step.ID)
+		elapsed := time.Since(started)
+		taskDuration.Observe(elapsed.Seconds(), step.Name)
 
-		var errorMsg *string
-		if execErr != nil {
+		update := db.TaskStatusUpdate{ID: task.ID, Processed: true}
+		if errors.Is(execErr, exec.ErrSkipped) {
+			update.Skipped = true
+			tasksFinished.Inc(step.Name, "skipped")
+			p.count(step, func(t *types.RunStep) { t.Skipped++ })
+			logger.Debug("Task skipped", "duration", elapsed)
+		} else if execErr != nil {
 			msg := execErr.Error()
-			errorMsg = &msg
-			pipelineLogger.Printf("Task %s failed: %v\n", task.ID, execErr)
+			update.Error = &msg
+			tasksFinished.Inc(step.Name, "failed")
+			p.count(step, func(t *types.RunStep) { t.Failed++ })
+			logger.Error("Task failed", "duration", elapsed, "error", execErr)
+		} else {
+			tasksFinished.Inc(step.Name, "succeeded")
+			p.count(step, func(t *types.RunStep) { t.Succeeded++ })
 		}
 
-		updateCh <- db.TaskStatusUpdate{ID: task.ID, Processed: true, Error: errorMsg}
+		updateCh <- update
 		executionCount.Add(1)
 	})
 
@@ -143,10 +271,82 @@ func (p *Pipeline) ExecuteStep(step types.Step, maxParallel int) int64 {
 	flusherDone.Wait()
 
 	if flushLastErr != nil {
-		pipelineLogger.Printf("Error flushing task statuses for step %s: %v\n", step.Name, flushLastErr)
+		pipelineLogger.Error("Failed to flush task statuses", "step", step.Name, "error", flushLastErr)
 	}
 
 	return executionCount.Load()
 }
 
+// sampleTasks creates tasks for a deterministic sample of the step's inputs
+// and returns them. Only map steps with an input are sampled; for anything
+// else it logs and returns nil. Inputs that already have a task are not
+// sampled again, so repeating a sampled run with the same seed is a no-op.
+func (p *Pipeline) sampleTasks(step types.Step) chan types.Task {
+	if step.Input == "" || step.Reduce() || step.Batched() {
+		pipelineLogger.Warn("Step not sampled: only map steps with an input are", "step", step.Name)
+		return nil
+	}
 
+	// In an overlay, a step whose input was produced there by an earlier
+	// step samples those outputs rather than the ones in the source.
+	var ids []string
+	var err error
+	if p.opts.Source != p.database {
+		ids, err = p.database.SampleResources(step, p.opts.Sample, p.opts.SampleSeed)
+	}
+	if err == nil && len(ids) == 0 {
+		ids, err = p.opts.Source.SampleResources(step, p.opts.Sample, p.opts.SampleSeed)
+	}
+	if err != nil {
+		pipelineLogger.Error("Failed to sample inputs", "step", step.Name, "error", err)
+		return nil
+	}
+	taskIDs, err := p.database.CreateTasksFromResources(step.ID, ids)
+	if err != nil {
+		pipelineLogger.Error("Failed to create sampled tasks", "step", step.Name, "error", err)
+		return nil
+	}
+	pipelineLogger.Info("Sampled inputs", "step", step.Name, "inputs", len(ids), "tasks", len(taskIDs))
+	p.count(step, func(t *types.RunStep) { t.Scheduled += int64(len(taskIDs)) })
+
+	ch := make(chan types.Task)
+	go func() {
+		defer close(ch)
+		for _, id := range taskIDs {
+			task, err := p.database.GetTask(id)
+			if err != nil || task == nil {
+				pipelineLogger.Error("Failed to load sampled task", "step", step.Name, "task_id", id, "error", err)
+				continue
+			}
+			ch <- *task
+		}
+	}()
+	return ch
+}
+
+// shardFilter passes through only the tasks that belong to this run's shard.
+func (p *Pipeline) shardFilter(in chan types.Task) chan types.Task {
+	out := make(chan types.Task)
+	go func() {
+		defer close(out)
+		for task := range in {
+			if ShardOf(task, p.opts.Shards) == p.opts.Shard {
+				out <- task
+			}
+		}
+	}()
+	return out
+}
+
+// ShardOf returns the 1-based shard of shards that a task belongs to. Tasks
+// are hashed by their first input resource ID, so the assignment is stable
+// across reschedules; seed and reduce tasks fall back to the task ID.
+func ShardOf(task types.Task, shards int) int {
+	key := task.ID
+	if ids := task.InputIDs(); len(ids) > 0 {
+		key = ids[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32()%uint32(shards)) + 1
+}
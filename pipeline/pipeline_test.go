@@ -0,0 +1,67 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"grit/db"
+	"grit/exec"
+	"grit/types"
+)
+
+func TestOverlayStepsSeeUpstreamOutputs(t *testing.T) {
+	main, err := db.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase(main) error = %v", err)
+	}
+	defer main.Close()
+	overlay, err := db.NewDatabase(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDatabase(overlay) error = %v", err)
+	}
+	defer overlay.Close()
+
+	for i := range 4 {
+		if _, _, err := main.CreateResourceFromReader("row", bytes.NewReader([]byte(fmt.Sprint(i)))); err != nil {
+			t.Fatalf("CreateResourceFromReader(%d) error = %v", i, err)
+		}
+	}
+	var steps []types.Step
+	for _, step := range []types.Step{
+		{Name: "up", Script: `cp "$INPUT_FILE" "$OUTPUT_DIR/mid"`, Input: "row"},
+		{Name: "down", Script: `cp "$INPUT_FILE" "$OUTPUT_DIR/out"`, Input: "mid"},
+	} {
+		step.ID, err = overlay.CreateStep(step)
+		if err != nil {
+			t.Fatalf("CreateStep(%s) error = %v", step.Name, err)
+		}
+		steps = append(steps, step)
+	}
+
+	executor := exec.NewScriptExecutor(exec.NewOverlayStore(&main, &overlay))
+	p, err := NewPipeline(executor, &overlay, Options{Sample: 2, SampleSeed: 1, Source: &main})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		if n := p.ExecuteStep(context.Background(), step, 1); n != 2 {
+			t.Errorf("ExecuteStep(%s) ran %d tasks, want 2", step.Name, n)
+		}
+	}
+
+	count := func(d db.Database, name string) int {
+		var n int
+		if err := d.ListResourcesByName(name, func(db.Resource) error { n++; return nil }); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(overlay, "out"); n != 2 {
+		t.Errorf("overlay has %d outputs of the downstream step, want 2", n)
+	}
+	if n := count(main, "mid") + count(main, "out"); n != 0 {
+		t.Errorf("main database has %d outputs of the overlay run, want 0", n)
+	}
+}
@@ -0,0 +1,42 @@
+// Package repo opens grit repos for commands, with the grit.toml overrides
+// given on the command line.
+package repo
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"grit/db"
+)
+
+// overrides are the -set flags.
+var overrides settings
+
+// RegisterFlags adds the -set flag shared by every command.
+func RegisterFlags(fs *flag.FlagSet) {
+	fs.Var(&overrides, "set", "override a "+db.ConfigFile+" setting for this command, e.g. badger.block_cache=256MiB (repeatable)")
+}
+
+// Options returns the database options given by the flags added by
+// RegisterFlags.
+func Options() db.Options {
+	return db.Options{Overrides: overrides}
+}
+
+// Open opens the repo at path with the options given on the command line.
+func Open(path string) (db.Database, error) {
+	return db.Open(path, Options())
+}
+
+type settings []string
+
+func (s *settings) String() string { return strings.Join(*s, ",") }
+
+func (s *settings) Set(value string) error {
+	if _, _, ok := strings.Cut(value, "="); !ok {
+		return fmt.Errorf("want key=value, got %q", value)
+	}
+	*s = append(*s, value)
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package tracing emits OpenTelemetry spans for runs, steps and tasks. Until
+// Setup installs an exporter every span is a no-op, so instrumented code
+// pays next to nothing when tracing is off.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span attributes shared by grit's spans.
+const (
+	StepKey        = attribute.Key("grit.step")
+	StepVersionKey = attribute.Key("grit.step.version")
+	TaskIDKey      = attribute.Key("grit.task_id")
+	InputHashKey   = attribute.Key("grit.input.hash")
+	InputCountKey  = attribute.Key("grit.input.count")
+	OutputCountKey = attribute.Key("grit.output.count")
+	SkippedKey     = attribute.Key("grit.task.skipped")
+	ExecutedKey    = attribute.Key("grit.tasks.executed")
+)
+
+var tracer = otel.Tracer("grit")
+
+// Start starts a span as a child of any span in ctx.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, on span and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Options selects where spans are exported. With neither Endpoint nor File
+// set tracing stays off.
+type Options struct {
+	// Endpoint is an OTLP/HTTP collector URL such as http://localhost:4318.
+	Endpoint string
+	// File receives one JSON object per span, for offline analysis.
+	File string
+	// TaskSampleRatio is the fraction of tasks traced, 0 to 1. Run and step
+	// spans are always kept.
+	TaskSampleRatio float64
+}
+
+var (
+	flagEndpoint *string
+	flagFile     *string
+	flagSample   *float64
+)
+
+// RegisterFlags adds the tracing flags to a command that runs tasks.
+func RegisterFlags(fs *flag.FlagSet) {
+	flagEndpoint = fs.String("trace-endpoint", "", "export OpenTelemetry spans over OTLP/HTTP to this URL (e.g. http://localhost:4318)")
+	flagFile = fs.String("trace-file", "", "write OpenTelemetry spans to this file as JSON lines")
+	flagSample = fs.Float64("trace-sample", 1, "fraction of tasks to trace, 0 to 1")
+}
+
+// ApplyFlags sets up tracing from the flags added by RegisterFlags.
+func ApplyFlags() (shutdown func(), err error) {
+	return Setup(Options{Endpoint: *flagEndpoint, File: *flagFile, TaskSampleRatio: *flagSample})
+}
+
+// Setup installs the exporters chosen by opts. The returned shutdown func
+// flushes buffered spans and must be called before the process exits.
+func Setup(opts Options) (shutdown func(), err error) {
+	if opts.Endpoint == "" && opts.File == "" {
+		return func() {}, nil
+	}
+	if opts.TaskSampleRatio < 0 || opts.TaskSampleRatio > 1 {
+		return nil, fmt.Errorf("trace sample ratio must be between 0 and 1, got %v", opts.TaskSampleRatio)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(attribute.String("service.name", "grit")),
+		resource.WithHost(),
+		resource.WithProcessPID(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe trace resource: %w", err)
+	}
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(taskSampler{ratio: opts.TaskSampleRatio}),
+	}
+
+	var file *os.File
+	if opts.File != "" {
+		file, err = os.Create(opts.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create trace file: %w", err)
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+	}
+	if opts.Endpoint != "" {
+		exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(opts.Endpoint))
+		if err != nil {
+			if file != nil {
+				file.Close()
+			}
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exporter))
+	}
+
+	provider := sdktrace.NewTracerProvider(providerOpts...)
+	otel.SetTracerProvider(provider)
+
+	return func() {
+		err := provider.Shutdown(context.Background())
+		if file != nil {
+			err = errors.Join(err, file.Close())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error flushing traces: %v\n", err)
+		}
+	}, nil
+}
+
+// taskSampler keeps every run and step span and a deterministic fraction of
+// task spans, chosen by task ID. Spans below a task follow their task.
+type taskSampler struct {
+	ratio float64
+}
+
+func (s taskSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	decision := sdktrace.RecordAndSample
+	parent := trace.SpanContextFromContext(p.ParentContext)
+	for _, attr := range p.Attributes {
+		if attr.Key == TaskIDKey {
+			if !s.sampled(attr.Value.AsString()) {
+				decision = sdktrace.Drop
+			}
+			return sdktrace.SamplingResult{Decision: decision, Tracestate: parent.TraceState()}
+		}
+	}
+	if parent.IsValid() && !parent.IsSampled() {
+		decision = sdktrace.Drop
+	}
+	return sdktrace.SamplingResult{Decision: decision, Tracestate: parent.TraceState()}
+}
+
+func (s taskSampler) sampled(taskID string) bool {
+	if s.ratio >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(taskID))
+	// FNV barely mixes its last bytes into the high bits; finish it off so
+	// IDs that differ only at the end still spread evenly.
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	return float64(x) < s.ratio*math.MaxUint64
+}
+
+func (s taskSampler) Description() string {
+	return fmt.Sprintf("TaskSampler{%g}", s.ratio)
+}
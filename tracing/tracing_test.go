@@ -0,0 +1,44 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTaskSampler(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(taskSampler{ratio: 0.5}),
+		sdktrace.WithSpanProcessor(recorder),
+	)
+	tr := provider.Tracer("test")
+
+	ctx, run := tr.Start(context.Background(), "run")
+	const tasks = 200
+	for i := range tasks {
+		taskCtx, task := tr.Start(ctx, "task", trace.WithAttributes(TaskIDKey.String(fmt.Sprint(i))))
+		_, child := tr.Start(taskCtx, "execute_script")
+		child.End()
+		task.End()
+	}
+	run.End()
+
+	counts := make(map[string]int)
+	for _, span := range recorder.Ended() {
+		counts[span.Name()]++
+	}
+	if counts["run"] != 1 {
+		t.Errorf("run spans = %d, want 1", counts["run"])
+	}
+	if counts["task"] < tasks/4 || counts["task"] > tasks*3/4 {
+		t.Errorf("task spans = %d, want about %d", counts["task"], tasks/2)
+	}
+	if counts["execute_script"] != counts["task"] {
+		t.Errorf("child spans = %d, want one per sampled task (%d)", counts["execute_script"], counts["task"])
+	}
+}
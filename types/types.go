@@ -1,38 +1,196 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type Step struct {
-	ID       string `msgpack:"id"`
-	Name     string `msgpack:"name"`
-	Script   string `msgpack:"script"`
-	Parallel *int   `msgpack:"parallel,omitempty"`
-	Input    string `msgpack:"input,omitempty"`
-	Version  int    `msgpack:"version"`
+	ID     string `msgpack:"id" json:"id"`
+	Name   string `msgpack:"name" json:"name"`
+	Script string `msgpack:"script" json:"script"`
+	// ScriptFile is the manifest-relative path Script was loaded from, kept
+	// for display only. Script always holds the file contents so that editing
+	// the file bumps the step version.
+	ScriptFile  string   `msgpack:"script_file,omitempty" json:"script_file,omitempty"`
+	Shell       []string `msgpack:"shell,omitempty" json:"shell,omitempty"`
+	Interpreter string   `msgpack:"interpreter,omitempty" json:"interpreter,omitempty"`
+	Command     []string `msgpack:"command,omitempty" json:"command,omitempty"`
+	Parallel    *int     `msgpack:"parallel,omitempty" json:"parallel,omitempty"`
+	Input       string   `msgpack:"input,omitempty" json:"input,omitempty"`
+	Version     int      `msgpack:"version" json:"version"`
+
+	// BatchSize groups up to this many input resources into a single task.
+	// Zero means one task per resource.
+	BatchSize int `msgpack:"batch_size,omitempty" json:"batch_size,omitempty"`
+	// BatchTimeout holds back a partial batch until its oldest member is at
+	// least this old. Zero schedules partial batches immediately.
+	BatchTimeout time.Duration `msgpack:"batch_timeout,omitempty" json:"batch_timeout,omitempty"`
+
+	// Mode is StepModeMap (one task per input) or StepModeReduce.
+	Mode string `msgpack:"mode,omitempty" json:"mode,omitempty"`
+	// InputFormat selects how a reduce step receives its inputs.
+	InputFormat string `msgpack:"input_format,omitempty" json:"input_format,omitempty"`
+	// When is an expr condition evaluated against each input resource before
+	// a task is created for it. Empty means every input qualifies.
+	When string `msgpack:"when,omitempty" json:"when,omitempty"`
+	// After names steps that must be quiescent before this one is scheduled,
+	// without this step consuming their output.
+	After []string `msgpack:"after,omitempty" json:"after,omitempty"`
+	// Outputs declares the resource names the step writes. A reduce over one
+	// of them waits for this step even before it has produced anything.
+	Outputs []string `msgpack:"outputs,omitempty" json:"outputs,omitempty"`
+
+	// Seed is the re-run policy for steps without an input.
+	Seed string `msgpack:"seed,omitempty" json:"seed,omitempty"`
+	// SeedEvery is the minimum time between runs for SeedEvery seeds.
+	SeedEvery time.Duration `msgpack:"seed_every,omitempty" json:"seed_every,omitempty"`
+	// SeedParams runs the seed once per value, passed as SEED_PARAM.
+	SeedParams []string `msgpack:"seed_params,omitempty" json:"seed_params,omitempty"`
+
+	// Compress is how the step's outputs are compressed when stored.
+	Compress string `msgpack:"compress,omitempty" json:"compress,omitempty"`
+	// Chunk stores large outputs as content-defined chunks, so that outputs
+	// that differ only a little share most of their storage.
+	Chunk bool `msgpack:"chunk,omitempty" json:"chunk,omitempty"`
+}
+
+const (
+	// SeedOnce runs a seed a single time per step version.
+	SeedOnce = ""
+	// SeedAlways runs a seed once per pipeline run.
+	SeedAlways = "always"
+	// SeedEvery runs a seed when its last run is older than Step.SeedEvery.
+	SeedEvery = "every"
+)
+
+const (
+	StepModeMap    = ""
+	StepModeReduce = "reduce"
+)
+
+const (
+	// InputFormatDir exposes every input as a file in INPUT_DIR and lists
+	// their paths in INPUT_FILE.
+	InputFormatDir = "dir"
+	// InputFormatConcat concatenates every input into INPUT_FILE.
+	InputFormatConcat = "concat"
+)
+
+const (
+	// CompressAuto compresses objects of at least 512 bytes when that makes
+	// them smaller.
+	CompressAuto = ""
+	// CompressZstd compresses every object when that makes it smaller.
+	CompressZstd = "zstd"
+	// CompressNone stores objects uncompressed.
+	CompressNone = "none"
+)
+
+// Reduce reports whether the step runs once over every resource of its input.
+func (s Step) Reduce() bool {
+	return s.Mode == StepModeReduce
+}
+
+// Batched reports whether tasks for this step take a set of input resources.
+func (s Step) Batched() bool {
+	return s.BatchSize > 0
 }
 
 type Task struct {
-	ID              string  `msgpack:"id"`
-	StepID          string  `msgpack:"step_id"`
-	InputResourceID *string `msgpack:"input_resource_id,omitempty"`
-	Processed       bool    `msgpack:"processed"`
-	Error           *string `msgpack:"error,omitempty"`
+	ID              string  `msgpack:"id" json:"id"`
+	StepID          string  `msgpack:"step_id" json:"step_id"`
+	InputResourceID *string `msgpack:"input_resource_id,omitempty" json:"input_resource_id,omitempty"`
+	// InputResourceIDs is set instead of InputResourceID for batched steps.
+	// Reduce tasks keep their input set in a database index instead; it is
+	// only filled in when a coordinator leases one to a worker.
+	InputResourceIDs []string `msgpack:"input_resource_ids,omitempty" json:"input_resource_ids,omitempty"`
+	Processed        bool     `msgpack:"processed" json:"processed"`
+	Error            *string  `msgpack:"error,omitempty" json:"error,omitempty"`
+	// Fingerprint identifies the input set a reduce task was scheduled for.
+	Fingerprint string `msgpack:"fingerprint,omitempty" json:"fingerprint,omitempty"`
+	// Skipped is set when the script exited with the reserved skip code.
+	Skipped bool `msgpack:"skipped,omitempty" json:"skipped,omitempty"`
+	// SeedParam is the seed_params value a seed task runs with.
+	SeedParam *string `msgpack:"seed_param,omitempty" json:"seed_param,omitempty"`
+	// RunID is the run that scheduled the task, if any.
+	RunID string `msgpack:"run_id,omitempty" json:"run_id,omitempty"`
+
+	CreatedAt  string `msgpack:"created_at,omitempty" json:"created_at,omitempty"`
+	FinishedAt string `msgpack:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
+
+// InputIDs returns every resource this task consumes.
+func (t Task) InputIDs() []string {
+	if t.InputResourceID != nil {
+		return []string{*t.InputResourceID}
+	}
+	return t.InputResourceIDs
 }
 
 const (
 	StorageBackendInline = "inline"
 	StorageBackendFS     = "fs"
+	StorageBackendS3     = "s3"
 )
 
 type Resource struct {
-	ID              string  `msgpack:"id"`
-	Name            string  `msgpack:"name"`
-	ObjectHash      string  `msgpack:"object_hash"`
-	CreatedAt       string  `msgpack:"created_at"`
-	CreatedByTaskID *string `msgpack:"created_by_task_id,omitempty"`
-	StorageBackend  string  `msgpack:"storage_backend,omitempty"`
+	ID              string  `msgpack:"id" json:"id"`
+	Name            string  `msgpack:"name" json:"name"`
+	ObjectHash      string  `msgpack:"object_hash" json:"object_hash"`
+	CreatedAt       string  `msgpack:"created_at" json:"created_at"`
+	CreatedByTaskID *string `msgpack:"created_by_task_id,omitempty" json:"created_by_task_id,omitempty"`
+	StorageBackend  string  `msgpack:"storage_backend,omitempty" json:"storage_backend,omitempty"`
+	// Size is the object size in bytes. Zero for resources created before
+	// sizes were recorded; see Database.ResourceSize.
+	Size   int64             `msgpack:"size,omitempty" json:"size,omitempty"`
+	Labels map[string]string `msgpack:"labels,omitempty" json:"labels,omitempty"`
+	// RunID is the run that created the resource, if any.
+	RunID string `msgpack:"run_id,omitempty" json:"run_id,omitempty"`
 }
 
+// Run records one `grit run` invocation.
+type Run struct {
+	ID         string `msgpack:"id" json:"id"`
+	StartedAt  string `msgpack:"started_at" json:"started_at"`
+	FinishedAt string `msgpack:"finished_at,omitempty" json:"finished_at,omitempty"`
+	// Status is one of the RunStatus values.
+	Status string `msgpack:"status" json:"status"`
+	// Error explains a failed run.
+	Error string `msgpack:"error,omitempty" json:"error,omitempty"`
+
+	ManifestPath string `msgpack:"manifest_path" json:"manifest_path"`
+	// ManifestHash is the hex SHA-256 of Manifest.
+	ManifestHash string `msgpack:"manifest_hash" json:"manifest_hash"`
+	Manifest     string `msgpack:"manifest" json:"manifest"`
+
+	// Args are the command-line arguments after `grit run`.
+	Args        []string `msgpack:"args,omitempty" json:"args,omitempty"`
+	Host        string   `msgpack:"host" json:"host"`
+	GritVersion string   `msgpack:"grit_version" json:"grit_version"`
+
+	// Steps holds what the run did with each of its steps, in run order.
+	Steps []RunStep `msgpack:"steps,omitempty" json:"steps,omitempty"`
+}
+
+// RunStep counts the tasks one step scheduled and executed during a run.
+type RunStep struct {
+	StepID    string `msgpack:"step_id" json:"step_id"`
+	Name      string `msgpack:"name" json:"name"`
+	Version   int    `msgpack:"version" json:"version"`
+	Scheduled int64  `msgpack:"scheduled" json:"scheduled"`
+	Succeeded int64  `msgpack:"succeeded" json:"succeeded"`
+	Failed    int64  `msgpack:"failed" json:"failed"`
+	Skipped   int64  `msgpack:"skipped" json:"skipped"`
+}
+
+const (
+	RunStatusRunning     = "running"
+	RunStatusSucceeded   = "succeeded"
+	RunStatusFailed      = "failed"
+	RunStatusInterrupted = "interrupted"
+)
+
 func (t Task) String() string {
 	var e string
 	if t.Error == nil {
@@ -40,5 +198,5 @@ func (t Task) String() string {
 	} else {
 		e = *t.Error
 	}
-	return fmt.Sprintf("Task(id=%s step_id=%s processed=%v error=%s)", t.ID, t.StepID, t.Processed, e)
+	return fmt.Sprintf("Task(id=%s step_id=%s processed=%v skipped=%v error=%s)", t.ID, t.StepID, t.Processed, t.Skipped, e)
 }
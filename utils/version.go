@@ -0,0 +1,33 @@
+package utils
+
+import "runtime/debug"
+
+// Version is set at build time with -ldflags "-X grit/utils.Version=...".
+var Version string
+
+// GritVersion returns the build's version, falling back to the VCS revision
+// Go embedded in the binary, or "dev".
+func GritVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	var revision, modified string
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = "-dirty"
+			}
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	return revision[:min(12, len(revision))] + modified
+}